@@ -0,0 +1,278 @@
+// Command mockidp is a stand-in OpenID provider for trying out and testing
+// the OIDC login locally. It signs in whoever is typed into its login form,
+// so it must never be exposed to anyone else.
+//
+//	go run ./cmd/mockidp
+//
+// and start the API with
+//
+//	OIDC_ISSUER=http://localhost:9090 OIDC_CLIENT_ID=library \
+//	OIDC_REDIRECT_URL=http://localhost:8080/users/oidc/callback
+//
+// Scripts can skip the form by adding mock_sub, mock_email and mock_groups
+// to the authorization URL.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const KEY_ID = "mock"
+
+// CODE_TTL is how long an issued authorization code can be redeemed.
+const CODE_TTL = time.Minute
+
+type grant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	subject     string
+	email       string
+	groups      []string
+	expiresAt   time.Time
+}
+
+type provider struct {
+	issuer string
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+func main() {
+	addr := os.Getenv("MOCK_IDP_ADDR")
+	if addr == "" {
+		addr = ":9090"
+	}
+
+	issuer := strings.TrimSuffix(os.Getenv("MOCK_IDP_ISSUER"), "/")
+	if issuer == "" {
+		issuer = "http://localhost" + addr[strings.LastIndex(addr, ":"):]
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	p := &provider{issuer: issuer, key: key, grants: map[string]grant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+
+	log.Printf("mock OpenID provider %s listening on %s", issuer, addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": KEY_ID,
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<title>Mock OpenID provider</title>
+<h1>Sign in to the mock OpenID provider</h1>
+<form method="post">
+{{range $name, $values := .}}<input type="hidden" name="{{$name}}" value="{{index $values 0}}">
+{{end}}<p><label>Subject <input name="mock_sub" required></label></p>
+<p><label>Email <input name="mock_email" type="email"></label></p>
+<p><label>Groups <input name="mock_groups" placeholder="library-staff,library-admins"></label></p>
+<p><button>Sign in</button></p>
+</form>
+`))
+
+// authorize shows the login form, and approves the login once it is filled
+// in or mock_sub is given.
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := r.Form
+	if form.Get("response_type") != "code" || form.Get("client_id") == "" || form.Get("redirect_uri") == "" {
+		http.Error(w, "response_type=code, client_id and redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+
+	if form.Get("code_challenge") == "" || form.Get("code_challenge_method") != "S256" {
+		http.Error(w, "an S256 code_challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	if form.Get("mock_sub") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginForm.Execute(w, form)
+		return
+	}
+
+	redirect, err := url.Parse(form.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+
+	var groups []string
+	for _, group := range strings.Split(form.Get("mock_groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	p.mu.Lock()
+	p.grants[code] = grant{
+		clientID:    form.Get("client_id"),
+		redirectURI: form.Get("redirect_uri"),
+		challenge:   form.Get("code_challenge"),
+		nonce:       form.Get("nonce"),
+		subject:     form.Get("mock_sub"),
+		email:       form.Get("mock_email"),
+		groups:      groups,
+		expiresAt:   time.Now().Add(CODE_TTL),
+	}
+	p.mu.Unlock()
+
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", form.Get("state"))
+	redirect.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token redeems an authorization code for a signed ID token, once.
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if user, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(user)
+	}
+
+	p.mu.Lock()
+	code := r.PostForm.Get("code")
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	p.mu.Unlock()
+
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	case !ok || time.Now().After(g.expiresAt):
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	case clientID != g.clientID || r.PostForm.Get("redirect_uri") != g.redirectURI:
+		tokenError(w, "invalid_grant", "client_id or redirect_uri does not match the authorization")
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		tokenError(w, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                p.issuer,
+		"sub":                g.subject,
+		"aud":                g.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              g.nonce,
+		"preferred_username": g.subject,
+		"groups":             g.groups,
+	}
+
+	if g.email != "" {
+		claims["email"] = g.email
+		claims["email_verified"] = true
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = KEY_ID
+
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		tokenError(w, "server_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
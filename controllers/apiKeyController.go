@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var apiKeyValidate = validator.New()
+
+// APIKeyView is a newly created API key together with the key itself, which
+// is shown only this once.
+type APIKeyView struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey issues a named API key for integrations. The key acts as the
+// user creating it and can only be given permissions that user holds.
+func CreateAPIKey(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		// a leaked key must not be able to mint others
+		if c.GetString("api_key_id") != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot create API keys, log in instead"})
+			return
+		}
+
+		var body struct {
+			Name          *string  `json:"name" validate:"required"`
+			Permissions   []string `json:"permissions" validate:"required,min=1,dive,required"`
+			ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := apiKeyValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if err := validatePermissions(body.Permissions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, permission := range body.Permissions {
+			if !helper.HasPermission(c, permission) {
+				c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("cannot grant permission %s you do not hold", permission)})
+				return
+			}
+		}
+
+		key, prefix, hash, err := helper.GenerateAPIKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while creating API key"})
+			return
+		}
+
+		now := time.Now()
+		apiKey := models.APIKey{
+			Name:        body.Name,
+			Prefix:      prefix,
+			KeyHash:     hash,
+			Permissions: body.Permissions,
+			CreatedBy:   userId,
+			CreatedAt:   now,
+		}
+
+		if body.ExpiresInDays > 0 {
+			expiresAt := now.Add(time.Duration(body.ExpiresInDays) * 24 * time.Hour)
+			apiKey.ExpiresAt = &expiresAt
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		if err := store.APIKeys().Insert(ctx, &apiKey); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while creating API key"})
+			return
+		}
+
+		auditChange(c, models.AUDIT_API_KEY_CREATE, "apikey:"+apiKey.ID.Hex(), nil, apiKey)
+		c.JSON(http.StatusCreated, APIKeyView{APIKey: apiKey, Key: key})
+	}
+}
+
+func GetAPIKeys(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		keys, err := store.APIKeys().List(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing API keys"})
+			return
+		}
+
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+// RevokeAPIKey stops a key from working on its next request. Revoked keys
+// stay listed, with revoked_at set.
+func RevokeAPIKey(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyId, err := primitive.ObjectIDFromHex(c.Param("key_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		apiKey, err := store.APIKeys().Revoke(ctx, keyId, time.Now())
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found or already revoked"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while revoking API key"})
+			return
+		}
+
+		before := *apiKey
+		before.RevokedAt = nil
+
+		auditChange(c, models.AUDIT_API_KEY_REVOKE, "apikey:"+keyId.Hex(), before, apiKey)
+		c.JSON(http.StatusOK, apiKey)
+	}
+}
@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// recordAudit writes an audit entry for the request in c right away, for
+// events worth keeping even though the request itself fails.
+func recordAudit(ctx context.Context, store repository.Store, c *gin.Context, action, target, detail string) error {
+	entry := helper.NewAuditEntry(c, action, target)
+	entry.Detail = detail
+
+	return store.Audit().Insert(ctx, entry)
+}
+
+// auditChange queues an audit entry for a change to target, which
+// middleware.Audit writes once the handler has answered. before and after
+// are the states of target around the change; nil for the one that does not
+// exist when target is created or deleted. The entry is returned so that
+// details can still be added.
+func auditChange(c *gin.Context, action, target string, before, after interface{}) *models.AuditEntry {
+	entry := helper.NewAuditEntry(c, action, target)
+	entry.Before, entry.After = helper.AuditDiff(before, after)
+
+	helper.QueueAudit(c, entry)
+	return entry
+}
+
+// auditUpdate is auditChange for an update that $set the fields in set.
+func auditUpdate(c *gin.Context, action, target string, before interface{}, set bson.M) *models.AuditEntry {
+	return auditChange(c, action, target, before, helper.AuditUpdated(before, set))
+}
+
+// GetAuditLog lists the audit log, oldest entry first. It can be narrowed
+// down to an actor (user id), a target, an action and a time range given as
+// RFC 3339 timestamps, from inclusive and to exclusive.
+func GetAuditLog(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := pageParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter := repository.AuditFilter{Target: c.Query("target"), Action: c.Query("action")}
+
+		if actor := c.Query("actor"); actor != "" {
+			if filter.ActorID, err = primitive.ObjectIDFromHex(actor); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor id"})
+				return
+			}
+		}
+
+		for param, at := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			if value := c.Query(param); value != "" {
+				if *at, err = time.Parse(time.RFC3339, value); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be an RFC 3339 timestamp"})
+					return
+				}
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		entries, err := store.Audit().ListPage(ctx, filter, page)
+		if err != nil {
+			pageErrorResponse(c, err, "Error occurred while listing the audit log")
+			return
+		}
+
+		c.JSON(http.StatusOK, pageResponse(entries))
+	}
+}
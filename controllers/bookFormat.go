@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+)
+
+// bookFormat is a representation of books other than JSON, that GetBook and
+// GetBooks answer with when asked for it.
+type bookFormat struct {
+	ContentType string
+	Extension   string
+	Write       func(w io.Writer, books []models.Book) error
+}
+
+// bookFormats are the representations of books besides JSON, by the name
+// format= gives them.
+var bookFormats = map[string]bookFormat{
+	"marc":    {ContentType: "application/marc", Extension: "mrc", Write: helper.WriteMARC},
+	"marcxml": {ContentType: "application/marcxml+xml", Extension: "xml", Write: helper.WriteMARCXML},
+	// citations
+	"bibtex":   {ContentType: "application/x-bibtex", Extension: "bib", Write: helper.WriteBibTeX},
+	"ris":      {ContentType: "application/x-research-info-systems", Extension: "ris", Write: helper.WriteRIS},
+	"csl-json": {ContentType: "application/vnd.citationstyles.csl+json", Extension: "json", Write: helper.WriteCSLJSON},
+	"dc":       {ContentType: "application/xml", Extension: "xml", Write: helper.WriteDublinCore},
+}
+
+// negotiateBookFormat picks the representation to answer with: the one the
+// format query parameter names, or else the one the Accept header prefers.
+// nil stands for JSON.
+func negotiateBookFormat(c *gin.Context) (*bookFormat, error) {
+	names := slices.Sorted(maps.Keys(bookFormats))
+
+	if name := c.Query("format"); name != "" {
+		if name == "json" {
+			return nil, nil
+		}
+
+		format, ok := bookFormats[name]
+		if !ok {
+			return nil, fmt.Errorf("format must be one of json, %s", strings.Join(names, ", "))
+		}
+
+		return &format, nil
+	}
+
+	c.Header("Vary", "Accept")
+
+	return acceptedBookFormat(c.GetHeader("Accept")), nil
+}
+
+// acceptedBookFormat picks the representation the Accept header weighs
+// highest of all the types it lists, the earlier one on a tie. Wildcards and
+// types that are not offered count too, so a browser's
+// "text/html,...,application/xml;q=0.9,*/*;q=0.8" gets JSON rather than dc.
+func acceptedBookFormat(accept string) *bookFormat {
+	var best *bookFormat
+	top := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				if q, _ = strconv.ParseFloat(strings.TrimSpace(value), 64); math.IsNaN(q) {
+					q = 0
+				}
+			}
+		}
+
+		if mediaType == "" || q <= top {
+			continue
+		}
+
+		top, best = q, nil
+		for _, format := range bookFormats {
+			if format.ContentType == mediaType {
+				best = &format
+			}
+		}
+	}
+
+	return best
+}
+
+// writeBooks answers with books in format, as a download named after name.
+func writeBooks(c *gin.Context, format *bookFormat, books []models.Book, name string) {
+	var buf bytes.Buffer
+	if err := format.Write(&buf, books); err != nil {
+		log.Printf("error exporting books as %s: %v", format.ContentType, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while exporting books"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format.Extension))
+	c.Data(http.StatusOK, format.ContentType, buf.Bytes())
+}
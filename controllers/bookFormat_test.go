@@ -0,0 +1,30 @@
+package controllers
+
+import "testing"
+
+func TestAcceptedBookFormatHonoursWeights(t *testing.T) {
+	for _, test := range []struct {
+		accept string
+		want   string // content type, empty for JSON
+	}{
+		{"", ""},
+		{"*/*", ""},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", ""},
+		{"application/x-bibtex", "application/x-bibtex"},
+		{"application/json;q=0.5, application/x-research-info-systems", "application/x-research-info-systems"},
+		{"application/json, application/marc", ""},
+		{"application/marc, application/json", "application/marc"},
+		{"application/xml;q=1, */*;q=1", "application/xml"},
+		{"application/marc;q=0", ""},
+		{"application/marc;q=junk", ""},
+	} {
+		got := ""
+		if format := acceptedBookFormat(test.accept); format != nil {
+			got = format.ContentType
+		}
+
+		if got != test.want {
+			t.Errorf("Accept %q answered with %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errCopyExists     = errors.New("a copy with this barcode already exists")
+	errCopyInUse      = errors.New("copy is on loan or set aside for a hold")
+	errCopyStatus     = errors.New("copy status can only be set to AVAILABLE, IN_REPAIR or LOST")
+	errBookCopiesUsed = errors.New("book has copies on loan or set aside for holds")
+)
+
+// shelfStatuses are the statuses a librarian can put a copy in by hand. The
+// others follow from loans and holds.
+var shelfStatuses = []string{models.STATUS_AVAILABLE, models.STATUS_IN_REPAIR, models.STATUS_LOST}
+
+// inUseStatuses are the statuses of copies that are out with a member or
+// waiting for one.
+var inUseStatuses = []string{models.STATUS_BORROWED, models.STATUS_ON_HOLD}
+
+type CopyView struct {
+	models.Copy
+	ISBN  *string               `json:"isbn,omitempty"`
+	Title *string               `json:"title,omitempty"`
+	Loan  *models.BorrowHistory `json:"loan,omitempty"` // The open loan while BORROWED
+}
+
+// syncStock recomputes the shelf count and status of a book from its copies.
+func syncStock(ctx context.Context, store repository.Store, bookId primitive.ObjectID, at time.Time) error {
+	available, err := store.Copies().CountAvailable(ctx, bookId)
+	if err != nil {
+		return err
+	}
+
+	return store.Books().SetStock(ctx, bookId, available, at)
+}
+
+// nextBarcode makes up a barcode for a copy that was added without one, from
+// the ISBN of the book and a running number.
+func nextBarcode(ctx context.Context, store repository.Store, book *models.Book) (string, error) {
+	copies, err := store.Copies().List(ctx, repository.CopyFilter{BookID: book.ID})
+	if err != nil {
+		return "", err
+	}
+
+	for n := len(copies) + 1; ; n++ {
+		barcode := fmt.Sprintf("%s-%03d", *book.ISBN, n)
+
+		count, err := store.Copies().CountByBarcode(ctx, barcode)
+		if err != nil {
+			return "", err
+		}
+
+		if count == 0 {
+			return barcode, nil
+		}
+	}
+}
+
+// addCopy registers a new copy of the book. An AVAILABLE copy goes straight to
+// the first member waiting for the book, if any.
+func addCopy(ctx context.Context, store repository.Store, book *models.Book, item *models.Copy, now time.Time) error {
+	if item.Barcode == nil || *item.Barcode == "" {
+		barcode, err := nextBarcode(ctx, store, book)
+		if err != nil {
+			return err
+		}
+		item.Barcode = &barcode
+	}
+
+	count, err := store.Copies().CountByBarcode(ctx, *item.Barcode)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return errCopyExists
+	}
+
+	if item.Status == "" {
+		item.Status = models.STATUS_AVAILABLE
+	}
+
+	if !slices.Contains(shelfStatuses, item.Status) {
+		return errCopyStatus
+	}
+
+	if item.Condition == nil {
+		condition := models.CONDITION_GOOD
+		item.Condition = &condition
+	}
+
+	if item.AcquiredAt.IsZero() {
+		item.AcquiredAt = now
+	}
+
+	item.ID = primitive.NilObjectID
+	item.BookID = book.ID
+	item.CreatedAt = now
+	item.UpdatedAt = now
+
+	// the check above can race with another insert, the unique index cannot
+	err = store.Copies().Insert(ctx, item)
+	if errors.Is(err, repository.ErrDuplicate) {
+		return errCopyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	if item.Status == models.STATUS_AVAILABLE {
+		return releaseCopy(ctx, store, *item, now)
+	}
+
+	return syncStock(ctx, store, book.ID, now)
+}
+
+// BackfillCopies gives books catalogued before copies were tracked one copy
+// record per unit of their old Qty, plus one for every open loan and every
+// copy set aside for a hold, so the derived stock matches what was on record.
+func BackfillCopies(store repository.Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	books, err := store.Books().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			return backfillBookCopies(ctx, store, &book)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func backfillBookCopies(ctx context.Context, store repository.Store, book *models.Book) error {
+	now := time.Now()
+
+	if book.ISBN == nil {
+		return nil
+	}
+
+	copies, err := store.Copies().List(ctx, repository.CopyFilter{BookID: book.ID})
+	if err != nil || len(copies) > 0 {
+		return err
+	}
+
+	// registers a copy in a state that needs no hold queue handling
+	register := func(status string) (*models.Copy, error) {
+		barcode, err := nextBarcode(ctx, store, book)
+		if err != nil {
+			return nil, err
+		}
+
+		condition := models.CONDITION_GOOD
+		item := models.Copy{
+			BookID:     book.ID,
+			Barcode:    &barcode,
+			Condition:  &condition,
+			AcquiredAt: book.CreatedAt,
+			Status:     status,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+
+		return &item, store.Copies().Insert(ctx, &item)
+	}
+
+	loans, err := store.Loans().List(ctx, repository.LoanFilter{BookID: book.ID, Statuses: repository.ActiveLoanStatuses})
+	if err != nil {
+		return err
+	}
+
+	for _, loan := range loans {
+		item, err := register(models.STATUS_BORROWED)
+		if err != nil {
+			return err
+		}
+
+		if err = store.Loans().Update(ctx, loan.ID, bson.M{"copy_id": item.ID, "barcode": *item.Barcode}); err != nil {
+			return err
+		}
+	}
+
+	holds, err := store.Holds().List(ctx, repository.HoldFilter{BookID: book.ID, Statuses: []string{models.STATUS_READY}})
+	if err != nil {
+		return err
+	}
+
+	for _, hold := range holds {
+		item, err := register(models.STATUS_ON_HOLD)
+		if err != nil {
+			return err
+		}
+
+		if err = store.Holds().Update(ctx, hold.ID, bson.M{"copy_id": item.ID}); err != nil {
+			return err
+		}
+	}
+
+	for range book.Qty {
+		if _, err := register(models.STATUS_AVAILABLE); err != nil {
+			return err
+		}
+	}
+
+	if len(loans)+len(holds)+book.Qty > 0 {
+		log.Printf("registered %d copies for book %s", len(loans)+len(holds)+book.Qty, *book.ISBN)
+	}
+
+	return syncStock(ctx, store, book.ID, now)
+}
+
+func copyErrorResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errBookNotFound), errors.Is(err, errCopyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errCopyStatus):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errCopyExists), errors.Is(err, errCopyInUse), errors.Is(err, errBookCopiesUsed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating copies"})
+	}
+}
+
+func GetBookCopies(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		book, err := store.Books().FindByISBN(ctx, isbn)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+			return
+		}
+
+		copies, err := store.Copies().List(ctx, repository.CopyFilter{BookID: book.ID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing copies"})
+			return
+		}
+
+		c.JSON(http.StatusOK, copies)
+	}
+}
+
+func AddCopy(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		var item models.Copy
+		if err := c.BindJSON(&item); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := bookValidate.StructExcept(item, "Barcode"); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			book, err := store.Books().FindByISBN(ctx, isbn)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errBookNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			return addCopy(ctx, store, book, &item, time.Now())
+		})
+		if err != nil {
+			copyErrorResponse(c, err)
+			return
+		}
+
+		auditChange(c, models.AUDIT_COPY_CREATE, "copy:"+*item.Barcode, nil, item)
+		c.JSON(http.StatusCreated, item)
+	}
+}
+
+func GetCopy(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		barcode := c.Param("barcode")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		item, err := store.Copies().FindByBarcode(ctx, barcode)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "copy not found"})
+			return
+		}
+
+		view := CopyView{Copy: *item}
+
+		if book, err := store.Books().FindByID(ctx, item.BookID); err == nil {
+			view.ISBN = book.ISBN
+			view.Title = book.Title
+		}
+
+		loans, err := store.Loans().List(ctx, repository.LoanFilter{CopyID: item.ID, Statuses: repository.ActiveLoanStatuses})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching loan"})
+			return
+		}
+
+		if len(loans) > 0 {
+			view.Loan = &loans[0]
+		}
+
+		c.JSON(http.StatusOK, view)
+	}
+}
+
+func UpdateCopy(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		barcode := c.Param("barcode")
+
+		var update models.Copy
+		if err := c.BindJSON(&update); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := bookValidate.StructExcept(update, "Barcode"); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var before, item *models.Copy
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			now := time.Now()
+
+			var err error
+			item, err = store.Copies().FindByBarcode(ctx, barcode)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errCopyNotFound
+			}
+			if err != nil {
+				return err
+			}
+			before = item
+
+			updateObj := bson.M{}
+
+			if update.Condition != nil {
+				updateObj["condition"] = update.Condition
+			}
+
+			if update.ShelfLocation != nil {
+				updateObj["shelf_location"] = update.ShelfLocation
+			}
+
+			if !update.AcquiredAt.IsZero() {
+				updateObj["acquired_at"] = update.AcquiredAt
+			}
+
+			statusChanged := update.Status != "" && update.Status != item.Status
+			if statusChanged {
+				if slices.Contains(inUseStatuses, item.Status) {
+					return errCopyInUse
+				}
+
+				if !slices.Contains(shelfStatuses, update.Status) {
+					return errCopyStatus
+				}
+
+				updateObj["status"] = update.Status
+			}
+
+			updateObj["updated_at"] = now
+
+			if err = store.Copies().Update(ctx, item.ID, updateObj); err != nil {
+				return err
+			}
+
+			item, err = store.Copies().FindByID(ctx, item.ID)
+			if err != nil || !statusChanged {
+				return err
+			}
+
+			// a copy back from repair serves the hold queue before the shelf
+			if item.Status == models.STATUS_AVAILABLE {
+				if err = releaseCopy(ctx, store, *item, now); err != nil {
+					return err
+				}
+
+				item, err = store.Copies().FindByID(ctx, item.ID)
+				return err
+			}
+
+			return syncStock(ctx, store, item.BookID, now)
+		})
+		if err != nil {
+			copyErrorResponse(c, err)
+			return
+		}
+
+		auditChange(c, models.AUDIT_COPY_UPDATE, "copy:"+barcode, before, item)
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+func DeleteCopy(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		barcode := c.Param("barcode")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var item *models.Copy
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			item, err = store.Copies().FindByBarcode(ctx, barcode)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errCopyNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			if slices.Contains(inUseStatuses, item.Status) {
+				return errCopyInUse
+			}
+
+			if err = store.Copies().Delete(ctx, item.ID); err != nil {
+				return err
+			}
+
+			return syncStock(ctx, store, item.BookID, time.Now())
+		})
+		if err != nil {
+			copyErrorResponse(c, err)
+			return
+		}
+
+		auditChange(c, models.AUDIT_COPY_DELETE, "copy:"+barcode, item, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "copy deleted successfully"})
+	}
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var fineValidate = validator.New()
+
+var (
+	errFineNotFound = errors.New("fine not found")
+	errFineSettled  = errors.New("fine is already settled")
+	errOverpayment  = errors.New("payment is more than the outstanding amount")
+)
+
+type FineStatement struct {
+	Outstanding int64         `json:"outstanding"`
+	Fines       []models.Fine `json:"fines"`
+}
+
+func fineStatement(ctx context.Context, store repository.Store, userId primitive.ObjectID) (*FineStatement, error) {
+	fines, err := store.Fines().List(ctx, repository.FineFilter{UserID: userId})
+	if err != nil {
+		return nil, err
+	}
+
+	statement := FineStatement{Fines: []models.Fine{}}
+	statement.Fines = append(statement.Fines, fines...)
+	for _, fine := range fines {
+		statement.Outstanding += fine.Outstanding()
+	}
+
+	return &statement, nil
+}
+
+func MemberFines(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		statement, err := fineStatement(ctx, store, memberId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing fines"})
+			return
+		}
+
+		c.JSON(http.StatusOK, statement)
+	}
+}
+
+func GetUserFines(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIdStr := c.Param("user_id")
+		userId, err := primitive.ObjectIDFromHex(userIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		statement, err := fineStatement(ctx, store, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing fines"})
+			return
+		}
+
+		c.JSON(http.StatusOK, statement)
+	}
+}
+
+// findUnpaidFine loads a fine that can still be paid or waived.
+func findUnpaidFine(ctx context.Context, store repository.Store, fineId primitive.ObjectID) (*models.Fine, error) {
+	fine, err := store.Fines().FindByID(ctx, fineId)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errFineNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if fine.Status != models.STATUS_UNPAID {
+		return nil, errFineSettled
+	}
+
+	return fine, nil
+}
+
+func RecordFinePayment(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fineId, err := primitive.ObjectIDFromHex(c.Param("fine_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fine id"})
+			return
+		}
+
+		librarianId, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		var payment models.FinePayment
+		if err := c.BindJSON(&payment); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := fineValidate.Struct(payment); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		payment.ReceivedBy = librarianId
+		payment.PaidAt = time.Now()
+
+		var before, fine *models.Fine
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			before, err = findUnpaidFine(ctx, store, fineId)
+			if err != nil {
+				return err
+			}
+
+			paid := *before
+			fine = &paid
+
+			if payment.Amount > fine.Outstanding() {
+				return errOverpayment
+			}
+
+			fine.Paid += payment.Amount
+			fine.Payments = append(fine.Payments, payment)
+			fine.UpdatedAt = payment.PaidAt
+			if fine.Paid >= fine.Amount {
+				fine.Status = models.STATUS_PAID
+			}
+
+			return store.Fines().Update(ctx, fineId, bson.M{
+				"paid":       fine.Paid,
+				"payments":   fine.Payments,
+				"status":     fine.Status,
+				"updated_at": fine.UpdatedAt,
+			})
+		})
+
+		switch {
+		case err == nil:
+			auditChange(c, models.AUDIT_FINE_PAYMENT, "fine:"+fineId.Hex(), before, fine)
+			c.JSON(http.StatusOK, fine)
+		case errors.Is(err, errFineNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, errFineSettled), errors.Is(err, errOverpayment):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while recording payment"})
+		}
+	}
+}
+
+func WaiveFine(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fineId, err := primitive.ObjectIDFromHex(c.Param("fine_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fine id"})
+			return
+		}
+
+		librarianId, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		var waiver struct {
+			Reason string `json:"reason" validate:"required"`
+		}
+		if err := c.BindJSON(&waiver); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := fineValidate.Struct(waiver); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var fine *models.Fine
+		updateObj := bson.M{
+			"status":        models.STATUS_WAIVED,
+			"waived_by":     librarianId,
+			"waiver_reason": waiver.Reason,
+			"updated_at":    time.Now(),
+		}
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			if fine, err = findUnpaidFine(ctx, store, fineId); err != nil {
+				return err
+			}
+
+			return store.Fines().Update(ctx, fineId, updateObj)
+		})
+
+		switch {
+		case err == nil:
+			auditUpdate(c, models.AUDIT_FINE_WAIVE, "fine:"+fineId.Hex(), fine, updateObj)
+			c.JSON(http.StatusOK, gin.H{"message": "fine waived successfully"})
+		case errors.Is(err, errFineNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, errFineSettled):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while waiving fine"})
+		}
+	}
+}
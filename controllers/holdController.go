@@ -0,0 +1,396 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errHoldNotFound    = errors.New("hold not found")
+	errHoldClosed      = errors.New("hold is no longer active")
+	errBookAvailable   = errors.New("book is available, borrow it instead of placing a hold")
+	errAlreadyOnHold   = errors.New("you already have a hold on this book")
+	errAlreadyBorrowed = errors.New("you have already borrowed this book")
+)
+
+type HoldView struct {
+	models.Hold
+	Position int     `json:"position,omitempty"` // Place in the queue while WAITING
+	Username *string `json:"username,omitempty"`
+	ISBN     *string `json:"isbn,omitempty"`
+	Title    *string `json:"title,omitempty"`
+}
+
+// releaseCopy hands a copy that has come back to the library to the next member
+// in the hold queue, or puts it back on the shelf when nobody is waiting.
+func releaseCopy(ctx context.Context, store repository.Store, item models.Copy, now time.Time) error {
+	waiting, err := store.Holds().List(ctx, repository.HoldFilter{BookID: item.BookID, Statuses: []string{models.STATUS_WAITING}})
+	if err != nil {
+		return err
+	}
+
+	if len(waiting) == 0 {
+		err = store.Copies().Update(ctx, item.ID, bson.M{"status": models.STATUS_AVAILABLE, "updated_at": now})
+	} else {
+		err = store.Copies().Update(ctx, item.ID, bson.M{"status": models.STATUS_ON_HOLD, "updated_at": now})
+		if err != nil {
+			return err
+		}
+
+		err = store.Holds().Update(ctx, waiting[0].ID, bson.M{
+			"status":     models.STATUS_READY,
+			"copy_id":    item.ID,
+			"ready_at":   now,
+			"expires_at": now.Add(helpers.LOAN_POLICY.HoldPickupWindow),
+			"updated_at": now,
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	return syncStock(ctx, store, item.BookID, now)
+}
+
+// releaseHeldCopy passes on the copy that was set aside for a READY hold.
+func releaseHeldCopy(ctx context.Context, store repository.Store, hold models.Hold, now time.Time) error {
+	if hold.CopyID == nil {
+		return nil
+	}
+
+	item, err := store.Copies().FindByID(ctx, *hold.CopyID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return releaseCopy(ctx, store, *item, now)
+}
+
+// expireHolds closes holds that were not picked up in time and passes their
+// copies on to the next member in line.
+func expireHolds(ctx context.Context, store repository.Store, now time.Time) error {
+	expired, err := store.Holds().ExpireReady(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, hold := range expired {
+		if err := releaseHeldCopy(ctx, store, hold, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func placeHold(ctx context.Context, store repository.Store, isbn string, memberId primitive.ObjectID) (*models.Hold, error) {
+	now := time.Now()
+
+	if err := expireHolds(ctx, store, now); err != nil {
+		return nil, err
+	}
+
+	book, err := store.Books().FindByISBN(ctx, isbn)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errBookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if book.Qty > 0 && (book.Status == nil || *book.Status != models.STATUS_OUT_OF_STOCK) {
+		return nil, errBookAvailable
+	}
+
+	holds, err := store.Holds().List(ctx, repository.HoldFilter{UserID: memberId, BookID: book.ID, Statuses: repository.ActiveHoldStatuses})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(holds) > 0 {
+		return nil, errAlreadyOnHold
+	}
+
+	loans, err := store.Loans().List(ctx, repository.LoanFilter{UserID: memberId, BookID: book.ID, Statuses: repository.ActiveLoanStatuses})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(loans) > 0 {
+		return nil, errAlreadyBorrowed
+	}
+
+	hold := models.Hold{
+		UserID:    memberId,
+		BookID:    book.ID,
+		Status:    models.STATUS_WAITING,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := store.Holds().Insert(ctx, &hold); err != nil {
+		return nil, err
+	}
+
+	return &hold, nil
+}
+
+// cancelHold withdraws a hold. When memberId is set only that member's holds
+// can be cancelled. A copy already set aside goes to the next member in line.
+// The hold is returned as it was before it was cancelled.
+func cancelHold(ctx context.Context, store repository.Store, holdId primitive.ObjectID, memberId *primitive.ObjectID) (*models.Hold, error) {
+	now := time.Now()
+
+	hold, err := store.Holds().FindByID(ctx, holdId)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && memberId != nil && hold.UserID != *memberId) {
+		return nil, errHoldNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if hold.Status != models.STATUS_WAITING && hold.Status != models.STATUS_READY {
+		return nil, errHoldClosed
+	}
+
+	err = store.Holds().Update(ctx, hold.ID, bson.M{"status": models.STATUS_CANCELLED, "updated_at": now})
+	if err != nil {
+		return nil, err
+	}
+
+	if hold.Status == models.STATUS_READY {
+		return hold, releaseHeldCopy(ctx, store, *hold, now)
+	}
+
+	return hold, nil
+}
+
+// holdViews decorates holds with their queue position and the member and book they refer to.
+func holdViews(ctx context.Context, store repository.Store, holds []models.Hold) ([]HoldView, error) {
+	views := []HoldView{}
+	queues := map[primitive.ObjectID][]models.Hold{}
+
+	for _, hold := range holds {
+		view := HoldView{Hold: hold}
+
+		if hold.Status == models.STATUS_WAITING {
+			queue, ok := queues[hold.BookID]
+			if !ok {
+				var err error
+				queue, err = store.Holds().List(ctx, repository.HoldFilter{BookID: hold.BookID, Statuses: []string{models.STATUS_WAITING}})
+				if err != nil {
+					return nil, err
+				}
+				queues[hold.BookID] = queue
+			}
+
+			for i, queued := range queue {
+				if queued.ID == hold.ID {
+					view.Position = i + 1
+				}
+			}
+		}
+
+		if user, err := store.Users().FindByID(ctx, hold.UserID); err == nil {
+			view.Username = user.Username
+		}
+
+		if book, err := store.Books().FindByID(ctx, hold.BookID); err == nil {
+			view.ISBN = book.ISBN
+			view.Title = book.Title
+		}
+
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+func holdErrorResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errBookNotFound), errors.Is(err, errHoldNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errBookAvailable), errors.Is(err, errAlreadyOnHold), errors.Is(err, errAlreadyBorrowed), errors.Is(err, errHoldClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating holds"})
+	}
+}
+
+func PlaceHold(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var hold *models.Hold
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			hold, err = placeHold(ctx, store, isbn, memberId)
+			return err
+		})
+		if err != nil {
+			holdErrorResponse(c, err)
+			return
+		}
+
+		views, err := holdViews(ctx, store, []models.Hold{*hold})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing holds"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, views[0])
+	}
+}
+
+func MemberHolds(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			return expireHolds(ctx, store, time.Now())
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while expiring holds"})
+			return
+		}
+
+		holds, err := store.Holds().List(ctx, repository.HoldFilter{UserID: memberId, Statuses: repository.ActiveHoldStatuses})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing holds"})
+			return
+		}
+
+		views, err := holdViews(ctx, store, holds)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing holds"})
+			return
+		}
+
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+func CancelMemberHold(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		holdId, err := primitive.ObjectIDFromHex(c.Param("hold_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hold id"})
+			return
+		}
+
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var hold *models.Hold
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			hold, err = cancelHold(ctx, store, holdId, &memberId)
+			return err
+		})
+		if err != nil {
+			holdErrorResponse(c, err)
+			return
+		}
+
+		auditUpdate(c, models.AUDIT_HOLD_CANCEL, "hold:"+holdId.Hex(), hold, bson.M{"status": models.STATUS_CANCELLED})
+		c.JSON(http.StatusOK, gin.H{"message": "hold cancelled successfully"})
+	}
+}
+
+func GetBookHolds(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			return expireHolds(ctx, store, time.Now())
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while expiring holds"})
+			return
+		}
+
+		book, err := store.Books().FindByISBN(ctx, isbn)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
+			return
+		}
+
+		holds, err := store.Holds().List(ctx, repository.HoldFilter{BookID: book.ID, Statuses: repository.ActiveHoldStatuses})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing holds"})
+			return
+		}
+
+		views, err := holdViews(ctx, store, holds)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing holds"})
+			return
+		}
+
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+func CancelHold(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		holdId, err := primitive.ObjectIDFromHex(c.Param("hold_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hold id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var hold *models.Hold
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			hold, err = cancelHold(ctx, store, holdId, nil)
+			return err
+		})
+		if err != nil {
+			holdErrorResponse(c, err)
+			return
+		}
+
+		auditUpdate(c, models.AUDIT_HOLD_CANCEL, "hold:"+holdId.Hex(), hold, bson.M{"status": models.STATUS_CANCELLED})
+		c.JSON(http.StatusOK, gin.H{"message": "hold cancelled successfully"})
+	}
+}
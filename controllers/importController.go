@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+// What became of a row of a catalog import.
+const (
+	IMPORT_ACCEPTED = "ACCEPTED" // added as a new book
+	IMPORT_MERGED   = "MERGED"   // the details of the book catalogued under its ISBN were updated
+	IMPORT_SKIPPED  = "SKIPPED"  // a book is catalogued under its ISBN already
+	IMPORT_REJECTED = "REJECTED"
+)
+
+// Modes of a catalog import, telling what happens to rows whose ISBN is
+// catalogued already.
+const (
+	IMPORT_MODE_SKIP   = "skip"
+	IMPORT_MODE_UPSERT = "upsert"
+)
+
+type ImportResult struct {
+	Row    int    `json:"row"` // line in the file
+	ISBN   string `json:"isbn,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type ImportReport struct {
+	Format   string         `json:"format"`
+	Mode     string         `json:"mode"`
+	DryRun   bool           `json:"dry_run"`
+	Accepted int            `json:"accepted"`
+	Merged   int            `json:"merged"`
+	Skipped  int            `json:"skipped"`
+	Rejected int            `json:"rejected"`
+	Rows     []ImportResult `json:"rows"`
+}
+
+func (r *ImportReport) add(result ImportResult) {
+	switch result.Result {
+	case IMPORT_ACCEPTED:
+		r.Accepted++
+	case IMPORT_MERGED:
+		r.Merged++
+	case IMPORT_SKIPPED:
+		r.Skipped++
+	case IMPORT_REJECTED:
+		r.Rejected++
+	}
+
+	r.Rows = append(r.Rows, result)
+}
+
+func (r *ImportReport) csv() []byte {
+	var buf bytes.Buffer
+
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"row", "isbn", "title", "result", "reason"})
+	for _, row := range r.Rows {
+		writer.Write([]string{strconv.Itoa(row.Row), row.ISBN, row.Title, row.Result, row.Reason})
+	}
+	writer.Flush()
+
+	return buf.Bytes()
+}
+
+// ImportBooks adds the books of a CSV or JSON Lines file to the catalog, one
+// row at a time: a row that fails is reported and the others go on. The file
+// is the request body, or the file field of a form upload. Rows whose ISBN is
+// catalogued already are skipped, or with mode=upsert merged into the book.
+// qty gives the copies of new books whose row has none, as MARC records never
+// do. dry_run=true only reports what would happen, and report=csv answers
+// with the report as a CSV download.
+func ImportBooks(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mode := c.DefaultQuery("mode", IMPORT_MODE_SKIP)
+		if mode != IMPORT_MODE_SKIP && mode != IMPORT_MODE_UPSERT {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be skip or upsert"})
+			return
+		}
+
+		reportFormat := c.DefaultQuery("report", "json")
+		if reportFormat != "json" && reportFormat != "csv" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "report must be json or csv"})
+			return
+		}
+
+		qty := 0
+		if value := c.Query("qty"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "qty must be a whole number greater than 0"})
+				return
+			}
+			qty = n
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, helper.IMPORT_MAX_BYTES)
+
+		var body io.Reader = c.Request.Body
+		var filename string
+		if c.ContentType() == "multipart/form-data" {
+			file, header, err := c.Request.FormFile("file")
+			if err != nil {
+				importErrorResponse(c, fmt.Errorf("the upload needs a file field: %w", err))
+				return
+			}
+			defer file.Close()
+
+			body, filename = file, header.Filename
+		}
+
+		format := c.Query("format")
+		if format == "" {
+			format = helper.ImportFormat(c.ContentType(), filename)
+		}
+
+		rows, err := helper.ReadBookImport(body, format)
+		if err != nil {
+			importErrorResponse(c, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		report := ImportReport{Format: format, Mode: mode, DryRun: c.Query("dry_run") == "true", Rows: []ImportResult{}}
+
+		// rows repeating an ISBN of the file name the row they repeat
+		seen := map[string]int{}
+		for _, row := range rows {
+			report.add(importBook(ctx, store, c, row, mode, qty, report.DryRun, seen))
+		}
+
+		entry := auditChange(c, models.AUDIT_BOOK_IMPORT, "books", nil, nil)
+		entry.Detail = fmt.Sprintf("%s import, %d accepted, %d merged, %d skipped, %d rejected", format, report.Accepted, report.Merged, report.Skipped, report.Rejected)
+		if report.DryRun {
+			entry.Detail = "dry run of a " + entry.Detail
+		}
+
+		if reportFormat == "csv" {
+			c.Header("Content-Disposition", `attachment; filename="book-import-report.csv"`)
+			c.Data(http.StatusOK, "text/csv; charset=utf-8", report.csv())
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+func importErrorResponse(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("the import is larger than %d MB", helper.IMPORT_MAX_BYTES>>20)})
+	case errors.Is(err, helper.ErrImportTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// importBook adds or merges the book of one row, like AddBook and UpdateBook
+// would, unless dryRun is set. New books without a qty get qty copies.
+func importBook(ctx context.Context, store repository.Store, c *gin.Context, row helper.ImportRow, mode string, qty int, dryRun bool, seen map[string]int) ImportResult {
+	book := row.Book
+
+	result := ImportResult{Row: row.Row}
+	if book.ISBN != nil {
+		result.ISBN = *book.ISBN
+	}
+	if book.Title != nil {
+		result.Title = *book.Title
+	}
+
+	reject := func(reason string) ImportResult {
+		result.Result, result.Reason = IMPORT_REJECTED, reason
+		return result
+	}
+
+	if row.Err != nil {
+		return reject(row.Err.Error())
+	}
+
+	if book.ISBN == nil {
+		return reject("isbn is required")
+	}
+
+	if err := normalizeBook(&book); err != nil {
+		return reject(err.Error())
+	}
+	result.ISBN = *book.ISBN
+
+	if first, ok := seen[*book.ISBN]; ok {
+		return reject(fmt.Sprintf("row %d has the same isbn", first))
+	}
+	seen[*book.ISBN] = row.Row
+
+	// stock follows the copies
+	book.Status = nil
+
+	existing, err := store.Books().FindByISBN(ctx, *book.ISBN)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("error importing book %s: %v", *book.ISBN, err)
+		return reject("Error occurred while checking for book isbn")
+	}
+
+	if existing != nil {
+		return mergeImportedBook(ctx, store, c, book, existing, mode, dryRun, result)
+	}
+
+	if book.Author == nil {
+		book.Author = primaryAuthor(book.Contributors)
+	}
+
+	if book.Qty == 0 {
+		book.Qty = qty
+	}
+
+	if validationErr := bookValidate.Struct(book); validationErr != nil {
+		return reject(validationErr.Error())
+	}
+
+	if book.Qty <= 0 {
+		return reject("Quantity should be greater than 0")
+	}
+
+	result.Result = IMPORT_ACCEPTED
+	if dryRun {
+		return result
+	}
+
+	err = createBook(ctx, store, &book, make([]models.Copy, book.Qty), time.Now())
+	if errors.Is(err, errCopyExists) || errors.Is(err, errCopyStatus) {
+		return reject(err.Error())
+	}
+	if err != nil {
+		log.Printf("error importing book %s: %v", *book.ISBN, err)
+		return reject("Error occurred while adding book")
+	}
+
+	auditChange(c, models.AUDIT_BOOK_CREATE, "book:"+*book.ISBN, nil, book)
+	return result
+}
+
+// mergeImportedBook handles a row for a book catalogued already. Merging
+// changes the details the row gives and leaves the copies alone.
+func mergeImportedBook(ctx context.Context, store repository.Store, c *gin.Context, book models.Book, existing *models.Book, mode string, dryRun bool, result ImportResult) ImportResult {
+	if mode == IMPORT_MODE_SKIP {
+		result.Result, result.Reason = IMPORT_SKIPPED, "a book with this isbn already exists"
+		return result
+	}
+
+	if validationErr := bookValidate.StructExcept(book, "ISBN", "Title", "Author"); validationErr != nil {
+		result.Result, result.Reason = IMPORT_REJECTED, validationErr.Error()
+		return result
+	}
+
+	result.Result = IMPORT_MERGED
+	if book.Qty != 0 {
+		result.Reason = "qty is ignored, add copies to change the stock"
+	}
+
+	set := bookUpdate(&book)
+	if dryRun || len(set) == 0 {
+		return result
+	}
+
+	set["updated_at"] = time.Now()
+	if err := store.Books().Update(ctx, *book.ISBN, set); err != nil {
+		log.Printf("error importing book %s: %v", *book.ISBN, err)
+		result.Result, result.Reason = IMPORT_REJECTED, "Error occurred while updating book"
+		return result
+	}
+
+	auditUpdate(c, models.AUDIT_BOOK_UPDATE, "book:"+*book.ISBN, existing, set)
+	return result
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// InvitationView is a newly created invitation together with its code, which
+// is shown only this once.
+type InvitationView struct {
+	models.Invitation
+	Code string `json:"code"`
+}
+
+// CreateInvitation issues a code for signing up with a staff role. Librarians
+// can only invite to roles whose permissions they hold themselves.
+func CreateInvitation(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		librarianId, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		var body struct {
+			Role          *string `json:"role" validate:"required"`
+			ExpiresInDays int     `json:"expires_in_days" validate:"omitempty,min=1,max=90"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := userValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if body.ExpiresInDays == 0 {
+			body.ExpiresInDays = 7
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		if _, err := findGrantableRole(ctx, store, c, *body.Role); err != nil {
+			roleErrorResponse(c, err, "Error occurred while checking role")
+			return
+		}
+
+		code, hash, err := helper.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while creating invitation"})
+			return
+		}
+
+		now := time.Now()
+		invitation := models.Invitation{
+			CodeHash:  hash,
+			Role:      body.Role,
+			CreatedBy: librarianId,
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Duration(body.ExpiresInDays) * 24 * time.Hour),
+		}
+
+		if err := store.Invitations().Insert(ctx, &invitation); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while creating invitation"})
+			return
+		}
+
+		auditChange(c, models.AUDIT_INVITATION_CREATE, "invitation:"+invitation.ID.Hex(), nil, invitation)
+		c.JSON(http.StatusCreated, InvitationView{Invitation: invitation, Code: code})
+	}
+}
+
+func GetInvitations(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		invitations, err := store.Invitations().List(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing invitations"})
+			return
+		}
+
+		c.JSON(http.StatusOK, invitations)
+	}
+}
+
+func DeleteInvitation(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invitationId, err := primitive.ObjectIDFromHex(c.Param("invitation_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invitation id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err = store.Invitations().Delete(ctx, invitationId)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting invitation"})
+			return
+		}
+
+		auditChange(c, models.AUDIT_INVITATION_DELETE, "invitation:"+invitationId.Hex(), nil, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "invitation deleted successfully"})
+	}
+}
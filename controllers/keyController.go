@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+// RotateSigningKeys keeps the token signing keys on schedule: it creates a
+// key right away if none is active, creates the next key once it is due to be
+// published, drops keys whose tokens have all expired and loads the rest into
+// helper.KEY_RING. Every instance runs it, so it also picks up keys created by
+// the others.
+func RotateSigningKeys(store repository.Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	keys, err := store.SigningKeys().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var current, next *models.SigningKey
+	for i := range keys {
+		if keys[i].ActivatesAt.After(now) {
+			next = &keys[i]
+		} else {
+			current = &keys[i]
+		}
+	}
+
+	var activatesAt time.Time
+	switch {
+	case current == nil:
+		activatesAt = now
+	case next == nil && !now.Before(current.ActivatesAt.Add(helper.KEY_ROTATION_PERIOD-helper.KEY_PUBLISH_AHEAD)):
+		activatesAt = current.ActivatesAt.Add(helper.KEY_ROTATION_PERIOD)
+		if earliest := now.Add(helper.KEY_PUBLISH_AHEAD); activatesAt.Before(earliest) {
+			activatesAt = earliest
+		}
+	}
+
+	if !activatesAt.IsZero() {
+		key, err := helper.GenerateSigningKey(now, activatesAt)
+		if err != nil {
+			return err
+		}
+
+		if err := store.SigningKeys().Insert(ctx, key); err != nil {
+			return err
+		}
+
+		if keys, err = store.SigningKeys().List(ctx); err != nil {
+			return err
+		}
+	}
+
+	kept := keys[:0]
+	for i, key := range keys {
+		if i+1 < len(keys) && helper.KeyExpired(keys[i+1].ActivatesAt, now) {
+			if err := store.SigningKeys().Delete(ctx, key.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		kept = append(kept, key)
+	}
+
+	return helper.LoadSigningKeys(kept)
+}
+
+// ScheduleKeyRotation runs RotateSigningKeys every minute until the process
+// exits.
+func ScheduleKeyRotation(store repository.Store) {
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := RotateSigningKeys(store); err != nil {
+				log.Printf("error rotating token signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// GetJWKS publishes the public keys that verify access tokens, so other
+// services can check them without calling the API.
+func GetJWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, helper.JWKS(time.Now()))
+	}
+}
@@ -1,474 +1,814 @@
-package controllers
-
-import (
-	"context"
-	"net/http"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
-	"github.com/roh4nyh/iit_bombay/helpers"
-	"github.com/roh4nyh/iit_bombay/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-var bookValidate = validator.New()
-
-func AddBook() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var book models.Book
-		if err := c.BindJSON(&book); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		validationErr := bookValidate.Struct(book)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-			return
-		}
-
-		if book.Qty <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity should be greater than 0"})
-			return
-		}
-
-		count, err := BookCollection.CountDocuments(ctx, bson.M{"isbn": book.ISBN})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for book isbn"})
-			return
-		}
-
-		if count > 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "this book already exists"})
-			return
-		}
-
-		book.CreatedAt = time.Now()
-		book.UpdatedAt = time.Now()
-
-		_, err = BookCollection.InsertOne(ctx, book)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while adding book"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "book added successfully"})
-	}
-}
-
-func UpdateBook() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		isbn := c.Param("isbn")
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var book models.Book
-
-		if err := c.BindJSON(&book); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		updateObj := bson.M{}
-
-		if book.Title != nil {
-			updateObj["title"] = book.Title
-		}
-
-		if book.Author != nil {
-			updateObj["author"] = book.Author
-		}
-
-		if book.Status != nil {
-			updateObj["status"] = book.Status
-		}
-
-		if book.Qty != 0 {
-			updateObj["qty"] = book.Qty
-		}
-
-		if book.ISBN != nil {
-			updateObj["isbn"] = book.ISBN
-		}
-
-		if book.Qty <= 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity should be greater than 0"})
-			return
-		}
-
-		updateObj["updated_at"] = time.Now()
-
-		filter := bson.M{"isbn": bson.M{"$eq": isbn}}
-		update := bson.M{"$set": updateObj}
-
-		_, err := BookCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating book"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "book updated successfully"})
-	}
-}
-
-func DeleteBook() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		isbn := c.Param("isbn")
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		filter := bson.M{"isbn": bson.M{"$eq": isbn}}
-
-		_, err := BookCollection.DeleteOne(ctx, filter)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting book"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "book deleted successfully"})
-	}
-}
-
-func GetUsers() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var users []models.User
-
-		cursor, err := UserCollection.Find(ctx, bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
-			return
-		}
-
-		if err = cursor.All(ctx, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding user data"})
-			return
-		}
-
-		if len(users) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no user available"})
-			return
-		}
-
-		c.JSON(http.StatusOK, users)
-	}
-}
-
-func GetUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		memberIdStr := c.Param("user_id")
-		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var user models.User
-		err = UserCollection.FindOne(ctx, bson.M{"_id": memberId, "role": models.ROLE_MEMBER}).Decode(&user)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
-
-		if user.Username == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, user)
-	}
-}
-
-func AddUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var user models.User
-
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		validationErr := userValidate.Struct(user)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-			return
-		}
-
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"username": user.Username})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for username"})
-			return
-		}
-
-		if count > 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "this user already exists"})
-			return
-		}
-
-		tempRole := models.ROLE_MEMBER
-		tempIsActive := false
-
-		user.Role = &tempRole
-		user.IsActive = &tempIsActive
-		user.CreatedAt = time.Now()
-		user.UpdatedAt = time.Now()
-		user.ID = primitive.NewObjectID()
-		user.UserID = user.ID.Hex()
-
-		password := HashPassword(*user.Password)
-		user.Password = &password
-
-		token, _ := helpers.GenerateUserToken(*user.Username, user.UserID, *user.Role, *user.IsActive)
-		user.Token = &token
-
-		_, err = UserCollection.InsertOne(ctx, user)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user occurred while adding customer"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "user added successfully"})
-	}
-}
-
-func UpdateUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userIdStr := c.Param("user_id")
-		userId, err := primitive.ObjectIDFromHex(userIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var user models.User
-
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		updateObj := bson.M{}
-
-		if user.Username != nil {
-			updateObj["username"] = user.Username
-		}
-
-		if user.Role != nil {
-			updateObj["role"] = user.Role
-		}
-
-		if user.IsActive != nil {
-			updateObj["is_active"] = user.IsActive
-		}
-
-		if user.Password != nil {
-			updateObj["password"] = user.Password
-		}
-
-		updateObj["updated_at"] = time.Now()
-
-		filter := bson.M{"_id": bson.M{"$eq": userId}}
-		update := bson.M{"$set": updateObj}
-
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "user updated successfully"})
-	}
-}
-
-func DeActivateUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		memberIdStr := c.Param("user_id")
-		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		var user models.User
-		err = UserCollection.FindOne(ctx, bson.M{"_id": memberId, "role": models.ROLE_MEMBER}).Decode(&user)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
-
-		if user.Username == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			return
-		}
-
-		updateObj := bson.M{}
-
-		updateObj["is_active"] = false
-
-		updateObj["updated_at"] = time.Now()
-
-		filter := bson.M{"_id": bson.M{"$eq": memberId}}
-		update := bson.M{"$set": updateObj}
-
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "user de-activated successfully"})
-	}
-}
-
-func DeleteUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userIdStr := c.Param("user_id")
-		userId, err := primitive.ObjectIDFromHex(userIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		// Check if the user is a librarian
-		var user models.User
-		err = UserCollection.FindOne(ctx, bson.M{"_id": userId}).Decode(&user)
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
-			}
-			return
-		}
-
-		if *user.Role == models.ROLE_LIBRARIAN {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete a librarian"})
-			return
-		}
-
-		filter := bson.M{"_id": userId}
-		result, err := UserCollection.DeleteOne(ctx, filter)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Error occurred while deleting user"})
-			return
-		}
-
-		if result.DeletedCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
-	}
-}
-
-func GetActiveUsers() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var users []models.User
-
-		cursor, err := UserCollection.Find(ctx, bson.M{"is_active": true})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing customers"})
-			return
-		}
-
-		if err = cursor.All(ctx, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding users data"})
-			return
-		}
-
-		if len(users) == 0 {
-			c.JSON(http.StatusOK, []models.User{})
-			return
-		}
-
-		c.JSON(http.StatusOK, users)
-	}
-}
-
-func GetNonActiveUsers() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var users []models.User
-
-		cursor, err := UserCollection.Find(ctx, bson.M{"is_active": false})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
-			return
-		}
-
-		if err = cursor.All(ctx, &users); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding users data"})
-			return
-		}
-
-		if len(users) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no users available"})
-			return
-		}
-
-		c.JSON(http.StatusOK, users)
-	}
-}
-
-func GetTransactionHistory() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userIdStr := c.Param("user_id")
-		userId, err := primitive.ObjectIDFromHex(userIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var borrowHistory []models.BorrowHistory
-
-		cursor, err := BorrowHistoryCollection.Find(ctx, bson.M{"user_id": userId})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing borrowed books"})
-			return
-		}
-
-		if err = cursor.All(ctx, &borrowHistory); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding borrowed books data"})
-			return
-		}
-
-		if len(borrowHistory) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no borrowed books available"})
-			return
-		}
-
-		c.JSON(http.StatusOK, borrowHistory)
-	}
-}
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var bookValidate = newBookValidator()
+
+// callNumberPatterns tell the call numbers of each scheme apart from typos.
+var callNumberPatterns = map[string]*regexp.Regexp{
+	models.CALL_NUMBER_DDC: regexp.MustCompile(`^\d{3}(\.\d+)?( \S.*)?$`),
+	models.CALL_NUMBER_LCC: regexp.MustCompile(`^[A-Z]{1,3} ?\d+(\.\d+)?( ?\S.*)?$`),
+}
+
+// newBookValidator returns a validator that also checks call numbers against
+// the pattern of their scheme.
+func newBookValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		callNumber := sl.Current().Interface().(models.CallNumber)
+		if pattern, ok := callNumberPatterns[callNumber.Scheme]; ok && !pattern.MatchString(callNumber.Number) {
+			sl.ReportError(callNumber.Number, "Number", "Number", "callnumber", callNumber.Scheme)
+		}
+	}, models.CallNumber{})
+
+	return validate
+}
+
+// normalizeBook fills in what the catalog derives from the metadata given:
+// the ISBN is stored in its canonical form along with its ISBN-10, and
+// contributors without a role are authors.
+func normalizeBook(book *models.Book) error {
+	book.ISBN10 = nil
+	if book.ISBN != nil {
+		isbn, err := helper.NormalizeISBN(*book.ISBN)
+		if err != nil {
+			return err
+		}
+
+		book.ISBN = &isbn
+		if isbn10 := helper.ISBN10(isbn); isbn10 != "" {
+			book.ISBN10 = &isbn10
+		}
+	}
+
+	for i := range book.Contributors {
+		if book.Contributors[i].Role == "" {
+			book.Contributors[i].Role = models.CONTRIBUTOR_AUTHOR
+		}
+	}
+
+	return nil
+}
+
+// isbnParam is the :isbn of the route in its canonical form, so that any form
+// of an ISBN finds the book. Anything else is passed on as it is.
+func isbnParam(c *gin.Context) string {
+	isbn := c.Param("isbn")
+	if canonical, err := helper.NormalizeISBN(isbn); err == nil {
+		return canonical
+	}
+
+	return isbn
+}
+
+// NormalizeISBNs stores the ISBNs of books catalogued before ISBNs were
+// checked in their canonical form. Books whose ISBN is invalid, or whose
+// canonical ISBN another book already has, are left alone and logged.
+func NormalizeISBNs(store repository.Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	books, err := store.Books().List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		if book.ISBN == nil {
+			continue
+		}
+
+		isbn := *book.ISBN
+		if err := normalizeBook(&book); err != nil {
+			log.Printf("book %s keeps its invalid isbn %q", book.ID.Hex(), isbn)
+			continue
+		}
+
+		if *book.ISBN == isbn {
+			continue
+		}
+
+		count, err := store.Books().CountByISBN(ctx, *book.ISBN)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			log.Printf("book %s keeps its isbn %q, another book has isbn %s", book.ID.Hex(), isbn, *book.ISBN)
+			continue
+		}
+
+		if err := store.Books().Update(ctx, isbn, bson.M{"isbn": book.ISBN, "isbn_10": book.ISBN10}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// primaryAuthor is the name of the first author among contributors, or of the
+// first contributor if none of them is an author.
+func primaryAuthor(contributors []models.Contributor) *string {
+	for _, contributor := range contributors {
+		if contributor.Role == models.CONTRIBUTOR_AUTHOR {
+			return contributor.Name
+		}
+	}
+
+	if len(contributors) > 0 {
+		return contributors[0].Name
+	}
+
+	return nil
+}
+
+// createBook catalogs a new book together with its copies.
+func createBook(ctx context.Context, store repository.Store, book *models.Book, copies []models.Copy, now time.Time) error {
+	status := models.STATUS_OUT_OF_STOCK
+
+	book.Qty = 0
+	book.Status = &status
+	book.CreatedAt = now
+	book.UpdatedAt = now
+
+	return store.WithTransaction(ctx, func(ctx context.Context) error {
+		book.ID = primitive.NilObjectID
+		if err := store.Books().Insert(ctx, book); err != nil {
+			return err
+		}
+
+		for i := range copies {
+			if err := addCopy(ctx, store, book, &copies[i], now); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// bookUpdate lists the changes to the details of a book that book asks for,
+// leaving out the ISBN and the fields it does not give.
+func bookUpdate(book *models.Book) bson.M {
+	set := bson.M{}
+
+	if book.Title != nil {
+		set["title"] = book.Title
+	}
+
+	if book.Author != nil {
+		set["author"] = book.Author
+	}
+
+	if book.Contributors != nil {
+		set["contributors"] = book.Contributors
+	}
+
+	if book.Publisher != nil {
+		set["publisher"] = book.Publisher
+	}
+
+	if book.PublicationYear != 0 {
+		set["publication_year"] = book.PublicationYear
+	}
+
+	if book.Edition != nil {
+		set["edition"] = book.Edition
+	}
+
+	if book.Language != nil {
+		set["language"] = book.Language
+	}
+
+	if book.Pages != 0 {
+		set["pages"] = book.Pages
+	}
+
+	if book.Subjects != nil {
+		set["subjects"] = book.Subjects
+	}
+
+	if book.CallNumber != nil {
+		set["call_number"] = book.CallNumber
+	}
+
+	if book.Series != nil {
+		set["series"] = book.Series
+	}
+
+	if book.Description != nil {
+		set["description"] = book.Description
+	}
+
+	if book.CoverURL != nil {
+		set["cover_url"] = book.CoverURL
+	}
+
+	return set
+}
+
+func AddBook(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		// copies can be listed one by one, or qty copies are registered with
+		// generated barcodes
+		var book struct {
+			models.Book
+			Copies []models.Copy `json:"copies"`
+		}
+		if err := c.BindJSON(&book); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := normalizeBook(&book.Book); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// a book listing its contributors but no author is shown under the
+		// first of them
+		if book.Author == nil {
+			book.Author = primaryAuthor(book.Contributors)
+		}
+
+		validationErr := bookValidate.Struct(book.Book)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		for _, item := range book.Copies {
+			if validationErr := bookValidate.StructExcept(item, "Barcode"); validationErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+				return
+			}
+		}
+
+		if len(book.Copies) == 0 && book.Qty <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity should be greater than 0"})
+			return
+		}
+
+		if len(book.Copies) == 0 {
+			book.Copies = make([]models.Copy, book.Qty)
+		}
+
+		count, err := store.Books().CountByISBN(ctx, *book.ISBN)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for book isbn"})
+			return
+		}
+
+		if count > 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "this book already exists"})
+			return
+		}
+
+		err = createBook(ctx, store, &book.Book, book.Copies, time.Now())
+		if errors.Is(err, errCopyExists) || errors.Is(err, errCopyStatus) {
+			copyErrorResponse(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while adding book"})
+			return
+		}
+
+		auditChange(c, models.AUDIT_BOOK_CREATE, "book:"+*book.ISBN, nil, book.Book)
+		c.JSON(http.StatusOK, gin.H{"message": "book added successfully"})
+	}
+}
+
+func UpdateBook(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var book models.Book
+
+		if err := c.BindJSON(&book); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// stock follows the copies, change those instead
+		if book.Qty != 0 || book.Status != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity and status are derived from the copies of the book"})
+			return
+		}
+
+		if err := normalizeBook(&book); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// only the fields given are changed, so none of them is required
+		if validationErr := bookValidate.StructExcept(book, "ISBN", "Title", "Author"); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		updateObj := bookUpdate(&book)
+
+		if book.ISBN != nil && *book.ISBN != isbn {
+			count, err := store.Books().CountByISBN(ctx, *book.ISBN)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for book isbn"})
+				return
+			}
+
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "a book with this isbn already exists"})
+				return
+			}
+
+			updateObj["isbn"] = book.ISBN
+			updateObj["isbn_10"] = book.ISBN10
+		}
+
+		updateObj["updated_at"] = time.Now()
+
+		before, err := store.Books().FindByISBN(ctx, isbn)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating book"})
+			return
+		}
+
+		err = store.Books().Update(ctx, isbn, updateObj)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating book"})
+			return
+		}
+
+		if before != nil {
+			auditUpdate(c, models.AUDIT_BOOK_UPDATE, "book:"+isbn, before, updateObj)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "book updated successfully"})
+	}
+}
+
+func DeleteBook(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var book *models.Book
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			book, err = store.Books().FindByISBN(ctx, isbn)
+			if errors.Is(err, repository.ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			inUse, err := store.Copies().List(ctx, repository.CopyFilter{BookID: book.ID, Statuses: inUseStatuses})
+			if err != nil {
+				return err
+			}
+
+			if len(inUse) > 0 {
+				return errBookCopiesUsed
+			}
+
+			if err = store.Copies().DeleteByBook(ctx, book.ID); err != nil {
+				return err
+			}
+
+			return store.Books().Delete(ctx, isbn)
+		})
+		if errors.Is(err, errBookCopiesUsed) {
+			copyErrorResponse(c, err)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while deleting book"})
+			return
+		}
+
+		if book != nil {
+			auditChange(c, models.AUDIT_BOOK_DELETE, "book:"+isbn, book, nil)
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "book deleted successfully"})
+	}
+}
+
+func GetUsers(store repository.Store) gin.HandlerFunc {
+	return listUsers(store, repository.UserFilter{})
+}
+
+// listUsers answers with one page of the users matching filter.
+func listUsers(store repository.Store, filter repository.UserFilter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := pageParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		users, err := store.Users().ListPage(ctx, filter, page)
+		if err != nil {
+			pageErrorResponse(c, err, "Error occurred while listing users")
+			return
+		}
+
+		c.JSON(http.StatusOK, pageResponse(users))
+	}
+}
+
+func GetUser(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberIdStr := c.Param("user_id")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		user, err := findMember(ctx, store, memberId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+// findMember looks up a user by id, only matching members.
+func findMember(ctx context.Context, store repository.Store, memberId primitive.ObjectID) (*models.User, error) {
+	user, err := store.Users().FindByID(ctx, memberId)
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && (user.Role == nil || *user.Role != models.ROLE_MEMBER)) {
+		return nil, errors.New("user not found")
+	}
+
+	return user, err
+}
+
+func AddUser(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var user models.User
+
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := userValidate.Struct(user)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := store.Users().CountByUsername(ctx, *user.Username)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for username"})
+			return
+		}
+
+		if count > 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "this user already exists"})
+			return
+		}
+
+		tempRole := models.ROLE_MEMBER
+		tempIsActive := false
+
+		user.Role = &tempRole
+		user.IsActive = &tempIsActive
+		user.AccountStatus = models.STATUS_APPROVED
+		user.CreatedAt = time.Now()
+		user.UpdatedAt = time.Now()
+		user.ID = primitive.NewObjectID()
+		user.UserID = user.ID.Hex()
+
+		password := HashPassword(*user.Password)
+		user.Password = &password
+
+		err = store.Users().Insert(ctx, &user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user occurred while adding customer"})
+			return
+		}
+
+		auditChange(c, models.AUDIT_USER_CREATE, "user:"+user.UserID, nil, user)
+		c.JSON(http.StatusOK, gin.H{"message": "user added successfully"})
+	}
+}
+
+func UpdateUser(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIdStr := c.Param("user_id")
+		userId, err := primitive.ObjectIDFromHex(userIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var user models.User
+
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		if user.Username != nil {
+			updateObj["username"] = user.Username
+		}
+
+		if user.Role != nil {
+			if _, err := findGrantableRole(ctx, store, c, *user.Role); err != nil {
+				roleErrorResponse(c, err, "Error occurred while checking role")
+				return
+			}
+
+			updateObj["role"] = user.Role
+		}
+
+		if user.IsActive != nil {
+			updateObj["is_active"] = user.IsActive
+		}
+
+		// a new password is hashed like any other and logs the user out
+		var hash string
+		if user.Password != nil {
+			if len(*user.Password) < 4 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the password must be at least 4 characters long"})
+				return
+			}
+
+			hash = HashPassword(*user.Password)
+		}
+
+		var before *models.User
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			before, err = store.Users().FindByID(ctx, userId)
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				return err
+			}
+
+			// the role the user holds now must not outrank the caller either
+			if before != nil {
+				if err := checkManageable(ctx, store, c, before); err != nil {
+					return err
+				}
+			}
+
+			now := time.Now()
+			updateObj["updated_at"] = now
+
+			if err := store.Users().Update(ctx, userId, updateObj); err != nil {
+				return err
+			}
+
+			if hash == "" {
+				return nil
+			}
+
+			return setPassword(ctx, store, userId, hash, now)
+		})
+		if err != nil {
+			roleErrorResponse(c, err, "Error occurred while updating user")
+			return
+		}
+
+		if before != nil {
+			entry := auditUpdate(c, models.AUDIT_USER_UPDATE, "user:"+userIdStr, before, updateObj)
+			if hash != "" {
+				entry.Detail = "password changed"
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "user updated successfully"})
+	}
+}
+
+func DeActivateUser(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberIdStr := c.Param("user_id")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		member, err := findMember(ctx, store, memberId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		updateObj := bson.M{}
+
+		updateObj["is_active"] = false
+
+		updateObj["updated_at"] = time.Now()
+
+		err = store.Users().Update(ctx, memberId, updateObj)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
+			return
+		}
+
+		auditUpdate(c, models.AUDIT_USER_DEACTIVATE, "user:"+memberIdStr, member, updateObj)
+		c.JSON(http.StatusOK, gin.H{"message": "user de-activated successfully"})
+	}
+}
+
+func DeleteUser(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIdStr := c.Param("user_id")
+		userId, err := primitive.ObjectIDFromHex(userIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		// Check if the user is a librarian
+		user, err := store.Users().FindByID(ctx, userId)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+			}
+			return
+		}
+
+		if *user.Role == models.ROLE_LIBRARIAN || *user.Role == models.ROLE_ADMIN {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete a librarian or an admin"})
+			return
+		}
+
+		if err := checkManageable(ctx, store, c, user); err != nil {
+			roleErrorResponse(c, err, "Error fetching user")
+			return
+		}
+
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := store.Users().Delete(ctx, userId); err != nil {
+				return err
+			}
+
+			_, err := store.Sessions().RevokeByUser(ctx, userId, time.Now())
+			return err
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error occurred while deleting user"})
+			return
+		}
+
+		auditChange(c, models.AUDIT_USER_DELETE, "user:"+userIdStr, user, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	}
+}
+
+// GetPendingUsers lists the members who signed up and wait for approval.
+func GetPendingUsers(store repository.Store) gin.HandlerFunc {
+	return listUsers(store, repository.UserFilter{AccountStatus: models.STATUS_PENDING})
+}
+
+// ApproveUser lets a member who signed up log in.
+func ApproveUser(store repository.Store) gin.HandlerFunc {
+	return reviewSignup(store, models.STATUS_APPROVED)
+}
+
+// RejectUser turns down a signup. The account is kept, so the username stays taken.
+func RejectUser(store repository.Store) gin.HandlerFunc {
+	return reviewSignup(store, models.STATUS_REJECTED)
+}
+
+func reviewSignup(store repository.Store, status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.Param("user_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		user, err := store.Users().FindByID(ctx, userId)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+			}
+			return
+		}
+
+		if user.AccountStatus != models.STATUS_PENDING {
+			c.JSON(http.StatusConflict, gin.H{"error": "user is not waiting for approval"})
+			return
+		}
+
+		updateObj := bson.M{"account_status": status, "updated_at": time.Now()}
+		err = store.Users().Update(ctx, userId, updateObj)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
+			return
+		}
+
+		action := models.AUDIT_USER_APPROVE
+		if status == models.STATUS_REJECTED {
+			action = models.AUDIT_USER_REJECT
+		}
+		auditUpdate(c, action, "user:"+userId.Hex(), user, updateObj)
+
+		user.AccountStatus = status
+		c.JSON(http.StatusOK, user)
+	}
+}
+
+func GetActiveUsers(store repository.Store) gin.HandlerFunc {
+	isActive := true
+	return listUsers(store, repository.UserFilter{IsActive: &isActive})
+}
+
+func GetNonActiveUsers(store repository.Store) gin.HandlerFunc {
+	isActive := false
+	return listUsers(store, repository.UserFilter{IsActive: &isActive})
+}
+
+func GetTransactionHistory(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userIdStr := c.Param("user_id")
+		userId, err := primitive.ObjectIDFromHex(userIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		page, err := pageParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		if err := store.Loans().MarkOverdue(ctx, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating overdue loans"})
+			return
+		}
+
+		borrowHistory, err := store.Loans().ListPage(ctx, repository.LoanFilter{UserID: userId}, page)
+		if err != nil {
+			pageErrorResponse(c, err, "Error occurred while listing borrowed books")
+			return
+		}
+
+		c.JSON(http.StatusOK, pageResponse(borrowHistory))
+	}
+}
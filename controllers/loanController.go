@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OverdueLoan struct {
+	models.BorrowHistory
+	Username    *string `json:"username"`
+	ISBN        *string `json:"isbn"`
+	Title       *string `json:"title"`
+	DaysOverdue int     `json:"days_overdue"`
+}
+
+// overdueLoans adds the borrower and the book to each loan.
+func overdueLoans(ctx context.Context, store repository.Store, loans []models.BorrowHistory) []OverdueLoan {
+	overdueLoans := []OverdueLoan{}
+	for _, loan := range loans {
+		overdueLoan := OverdueLoan{
+			BorrowHistory: loan,
+			DaysOverdue:   int(math.Ceil(time.Since(loan.DueAt).Hours() / 24)),
+		}
+
+		if user, err := store.Users().FindByID(ctx, loan.UserID); err == nil {
+			overdueLoan.Username = user.Username
+		}
+
+		if book, err := store.Books().FindByID(ctx, loan.BookID); err == nil {
+			overdueLoan.ISBN = book.ISBN
+			overdueLoan.Title = book.Title
+		}
+
+		overdueLoans = append(overdueLoans, overdueLoan)
+	}
+
+	return overdueLoans
+}
+
+// listOverdueLoans answers with a page of the overdue loans filter matches,
+// marking the loans that fell due first.
+func listOverdueLoans(c *gin.Context, store repository.Store, filter repository.LoanFilter) {
+	page, err := pageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	if err := store.Loans().MarkOverdue(ctx, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating overdue loans"})
+		return
+	}
+
+	filter.Statuses = []string{models.STATUS_OVERDUE}
+
+	loans, err := store.Loans().ListPage(ctx, filter, page)
+	if err != nil {
+		pageErrorResponse(c, err, "Error occurred while listing overdue loans")
+		return
+	}
+
+	c.JSON(http.StatusOK, PageResponse[OverdueLoan]{
+		Data:       overdueLoans(ctx, store, loans.Items),
+		NextCursor: loans.NextCursor,
+		Total:      loans.Total,
+	})
+}
+
+func GetOverdueLoans(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listOverdueLoans(c, store, repository.LoanFilter{})
+	}
+}
+
+func MemberOverdueLoans(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		listOverdueLoans(c, store, repository.LoanFilter{UserID: memberId})
+	}
+}
+
+var (
+	errRenewalLimit   = errors.New("this loan has reached the renewal limit")
+	errRenewOverdue   = errors.New("overdue loans cannot be renewed, please return the book")
+	errRenewRequested = errors.New("another member is waiting for this book, please return it")
+)
+
+// renewLoan pushes the due date of the member's open loan for the book back by
+// one loan period. override lifts the limit on the number of renewals.
+func renewLoan(ctx context.Context, store repository.Store, isbn string, memberId primitive.ObjectID, override bool) (*models.BorrowHistory, error) {
+	now := time.Now()
+
+	book, err := store.Books().FindByISBN(ctx, isbn)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errLoanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	loans, err := store.Loans().List(ctx, repository.LoanFilter{UserID: memberId, BookID: book.ID, Statuses: repository.ActiveLoanStatuses})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(loans) == 0 {
+		return nil, errLoanNotFound
+	}
+
+	loan := loans[0]
+
+	if loan.Status == models.STATUS_OVERDUE || (!loan.DueAt.IsZero() && loan.DueAt.Before(now)) {
+		return nil, errRenewOverdue
+	}
+
+	if loan.RenewalCount >= helpers.LOAN_POLICY.MaxRenewals && !override {
+		return nil, errRenewalLimit
+	}
+
+	holds, err := store.Holds().List(ctx, repository.HoldFilter{BookID: book.ID, Statuses: repository.ActiveHoldStatuses})
+	if err != nil {
+		return nil, err
+	}
+
+	// a hold the borrower placed on the book themselves keeps no one waiting
+	waiting := slices.ContainsFunc(holds, func(hold models.Hold) bool { return hold.UserID != memberId })
+	if waiting {
+		return nil, errRenewRequested
+	}
+
+	dueFrom := loan.DueAt
+	if dueFrom.IsZero() {
+		dueFrom = now
+	}
+
+	loan.DueAt = helpers.LOAN_POLICY.DueDate(dueFrom)
+	loan.RenewalCount++
+
+	err = store.Loans().Update(ctx, loan.ID, bson.M{"due_at": loan.DueAt, "renewal_count": loan.RenewalCount})
+	if err != nil {
+		return nil, err
+	}
+
+	return &loan, nil
+}
+
+func RenewBook(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		override := helpers.HasPermission(c, models.PERM_LOANS_OVERRIDE)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var loan *models.BorrowHistory
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			loan, err = renewLoan(ctx, store, isbn, memberId, override)
+			return err
+		})
+
+		switch {
+		case err == nil:
+			c.JSON(http.StatusOK, loan)
+		case errors.Is(err, errLoanNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, errRenewalLimit), errors.Is(err, errRenewOverdue), errors.Is(err, errRenewRequested):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while renewing loan"})
+		}
+	}
+}
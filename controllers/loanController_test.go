@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRenewLoanIgnoresTheBorrowersOwnHold(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+
+	isbn, title, author := "9780131103627", "The C Programming Language", "Brian W. Kernighan"
+	book := models.Book{ISBN: &isbn, Title: &title, Author: &author}
+	if err := createBook(ctx, store, &book, make([]models.Copy, 1), time.Now()); err != nil {
+		t.Fatalf("createBook: %v", err)
+	}
+
+	memberIds := make([]primitive.ObjectID, 2)
+	for i := range memberIds {
+		role, active := models.ROLE_MEMBER, true
+		user := models.User{Role: &role, IsActive: &active}
+		if err := store.Users().Insert(ctx, &user); err != nil {
+			t.Fatalf("insert member: %v", err)
+		}
+		memberIds[i] = user.ID
+	}
+	borrower, other := memberIds[0], memberIds[1]
+
+	if err := borrowBook(ctx, store, &book, "", borrower, false); err != nil {
+		t.Fatalf("borrowBook: %v", err)
+	}
+
+	hold := models.Hold{UserID: borrower, BookID: book.ID, Status: models.STATUS_WAITING, CreatedAt: time.Now()}
+	if err := store.Holds().Insert(ctx, &hold); err != nil {
+		t.Fatalf("insert hold: %v", err)
+	}
+	if _, err := renewLoan(ctx, store, isbn, borrower, false); err != nil {
+		t.Errorf("renew with only the borrower's own hold: %v", err)
+	}
+
+	hold = models.Hold{UserID: other, BookID: book.ID, Status: models.STATUS_WAITING, CreatedAt: time.Now()}
+	if err := store.Holds().Insert(ctx, &hold); err != nil {
+		t.Fatalf("insert hold: %v", err)
+	}
+	if _, err := renewLoan(ctx, store, isbn, borrower, false); !errors.Is(err, errRenewRequested) {
+		t.Errorf("renew with another member's hold: %v, want errRenewRequested", err)
+	}
+}
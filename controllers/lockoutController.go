@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// loginKeys returns the keys failed logins are counted under: the username
+// tried and the address it was tried from.
+func loginKeys(c *gin.Context, username string) (userKey, ipKey string) {
+	return "user:" + username, "ip:" + c.ClientIP()
+}
+
+// loginReservation is an attempt reserveLogin counted under one key: the
+// record as it was before and after.
+type loginReservation struct {
+	before, after *models.LoginAttempt
+}
+
+// reserveLogin refuses a login attempt while any of keys is blocked, without
+// counting it, so that retrying cannot keep a key blocked. Otherwise it counts
+// the attempt as failed before it is checked, so that attempts sent at once
+// cannot all get past the delay: only the first finds the keys free. It
+// returns the time until which the most restricted of keys has to wait, and
+// takes the attempt back if that is still to come. Otherwise the attempt is
+// left counted under the returned records until forgiveLogin or lockOutLogin
+// settles it.
+func reserveLogin(ctx context.Context, store repository.Store, now time.Time, keys ...string) (map[string]loginReservation, time.Time, error) {
+	var until time.Time
+	for _, key := range keys {
+		attempt, err := store.LoginAttempts().FindByKey(ctx, key)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return nil, until, err
+		}
+
+		if blocked := helper.LOGIN_POLICY.BlockedUntil(attempt); blocked.After(until) {
+			until = blocked
+		}
+	}
+
+	if until.After(now) {
+		return nil, until, nil
+	}
+
+	attempts := map[string]loginReservation{}
+	for _, key := range keys {
+		before, after, err := store.LoginAttempts().RecordFailure(ctx, key, now, now.Add(-helper.LOGIN_POLICY.Window))
+		if err != nil {
+			return nil, until, err
+		}
+		attempts[key] = loginReservation{before: before, after: after}
+
+		if blocked := helper.LOGIN_POLICY.BlockedUntil(before); blocked.After(until) {
+			until = blocked
+		}
+	}
+
+	if until.After(now) {
+		return nil, until, forgiveLogin(ctx, store, attempts)
+	}
+
+	return attempts, until, nil
+}
+
+// forgiveLogin takes back an attempt reserveLogin counted, once it turned out
+// right, leaving the records as they were before it.
+func forgiveLogin(ctx context.Context, store repository.Store, attempts map[string]loginReservation) error {
+	for key, attempt := range attempts {
+		if err := store.LoginAttempts().Forgive(ctx, key, attempt.before, attempt.after); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tooManyLogins answers a login attempt made before until.
+func tooManyLogins(c *gin.Context, until, now time.Time) {
+	wait := int(math.Ceil(until.Sub(now).Seconds()))
+
+	c.Header("Retry-After", strconv.Itoa(wait))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("too many failed logins, try again in %d seconds", wait)})
+}
+
+// lockOutLogin settles an attempt reserveLogin counted that turned out wrong,
+// locking whichever of the username and the client address reached its limit
+// and auditing the lockout.
+func lockOutLogin(ctx context.Context, store repository.Store, c *gin.Context, attempts map[string]loginReservation, userKey, ipKey string, now time.Time) error {
+	policy := helper.LOGIN_POLICY
+	limits := map[string]int{userKey: policy.MaxFailures, ipKey: policy.MaxIPFailures}
+
+	for key, limit := range limits {
+		attempt := attempts[key].after
+		if attempt == nil || attempt.Failures < limit || (attempt.LockedUntil != nil && attempt.LockedUntil.After(now)) {
+			continue
+		}
+
+		until := now.Add(policy.Lockout)
+		if err := store.LoginAttempts().Lock(ctx, key, until); err != nil {
+			return err
+		}
+
+		detail := fmt.Sprintf("%d failed logins, locked until %s", attempt.Failures, until.Format(time.RFC3339))
+		if err := recordAudit(ctx, store, c, models.AUDIT_LOGIN_LOCKOUT, key, detail); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnlockUser lifts a login lockout of the user before it runs out. Lockouts
+// of client addresses are left to expire.
+func UnlockUser(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.Param("user_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		user, err := store.Users().FindByID(ctx, userId)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+			return
+		}
+
+		userKey, _ := loginKeys(c, *user.Username)
+		if err := store.LoginAttempts().Clear(ctx, userKey); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while unlocking user"})
+			return
+		}
+
+		auditChange(c, models.AUDIT_LOGIN_UNLOCK, userKey, nil, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "user unlocked successfully"})
+	}
+}
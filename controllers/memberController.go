@@ -1,351 +1,494 @@
-package controllers
-
-import (
-	"context"
-	"log"
-	"net/http"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/roh4nyh/iit_bombay/database"
-	"github.com/roh4nyh/iit_bombay/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-)
-
-const (
-	DatabaseName                = "Cluster0"
-	BookCollectionName          = "books"
-	BorrowHistoryCollectionName = "borrowHistory"
-)
-
-var BookCollection *mongo.Collection = database.OpenCollection(DatabaseName, BookCollectionName)
-var BorrowHistoryCollection *mongo.Collection = database.OpenCollection(DatabaseName, BorrowHistoryCollectionName)
-
-func GetBooks() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var books []models.Book
-		cursor, err := BookCollection.Find(ctx, bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing users"})
-			return
-		}
-
-		if err = cursor.All(ctx, &books); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding user data"})
-			return
-		}
-
-		if len(books) == 0 {
-			c.JSON(http.StatusOK, gin.H{"error": "no users available"})
-			return
-		}
-
-		c.JSON(http.StatusOK, books)
-	}
-}
-
-func GetBook() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		isbn := c.Param("isbn")
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var book models.Book
-		err := BookCollection.FindOne(ctx, bson.M{"isbn": isbn}).Decode(&book)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "book not found"})
-			return
-		}
-
-		if book.Title == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "book not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, book)
-	}
-}
-
-func DeActivateMember() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		memberIdStr := c.GetString("uid")
-		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		// make sure user returns all borrowed books before deactivating
-		var borrowedBooksHistory []models.BorrowHistory
-		cursor, err := BorrowHistoryCollection.Find(ctx, bson.M{"user_id": memberId, "status": models.STATUS_BORROWED})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing borrowed books"})
-			return
-		}
-
-		if err = cursor.All(ctx, &borrowedBooksHistory); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding borrowed books data"})
-			return
-		}
-
-		log.Println(borrowedBooksHistory)
-
-		if len(borrowedBooksHistory) > 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Return all borrowed books before deactivating user"})
-			return
-		}
-
-		// Deactivate user
-		updateObj := bson.M{}
-
-		updateObj["is_active"] = false
-		updateObj["updated_at"] = time.Now()
-
-		filter := bson.M{"_id": bson.M{"$eq": memberId}}
-		update := bson.M{"$set": updateObj}
-
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "user de-activated successfully"})
-	}
-}
-
-func BorrowedBooks() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		memberIdStr := c.GetString("uid")
-		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var borrowedBooksHistory []models.BorrowHistory
-
-		cursor, err := BorrowHistoryCollection.Find(ctx, bson.M{"user_id": memberId, "status": models.STATUS_BORROWED})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing borrowed books"})
-			return
-		}
-
-		if err = cursor.All(ctx, &borrowedBooksHistory); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding borrowed books data"})
-			return
-		}
-
-		var borrowedBooks []models.Book
-
-		for _, borrowedBook := range borrowedBooksHistory {
-			var book models.Book
-			err := BookCollection.FindOne(ctx, bson.M{"_id": borrowedBook.BookID}).Decode(&book)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching borrowed book"})
-				return
-			}
-
-			borrowedBooks = append(borrowedBooks, book)
-		}
-
-		// Return an empty array if no books have been borrowed
-		if len(borrowedBooks) == 0 {
-			c.JSON(http.StatusOK, []models.Book{})
-			return
-		}
-
-		c.JSON(http.StatusOK, borrowedBooks)
-	}
-}
-
-func BorrowBook() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		isbn := c.Param("isbn")
-
-		memberIdStr := c.GetString("uid")
-		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var book models.Book
-		err = BookCollection.FindOne(ctx, bson.M{"isbn": isbn}).Decode(&book)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "book not found"})
-			return
-		}
-
-		if book.Status == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "book not found"})
-			return
-		}
-
-		if *book.Status == models.STATUS_OUT_OF_STOCK {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "book is out of stock"})
-			return
-		}
-
-		updateObj := bson.M{}
-
-		// should remove
-		// updateObj["status"] = models.STATUS_BORROWED
-		// should remove
-		// updateObj["borrowed_by"] = memberId
-		updateObj["updated_at"] = time.Now()
-		updateObj["qty"] = book.Qty - 1
-
-		if updateObj["qty"].(int) <= 0 {
-			updateObj["status"] = models.STATUS_OUT_OF_STOCK
-		}
-
-		filter := bson.M{"isbn": bson.M{"$eq": isbn}}
-		update := bson.M{"$set": updateObj}
-
-		_, err = BookCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating book"})
-			return
-		}
-
-		borrowHistory := models.BorrowHistory{
-			UserID:     memberId,
-			BookID:     book.ID,
-			BorrowedAt: time.Now(),
-			Status:     models.STATUS_BORROWED,
-		}
-
-		_, err = BorrowHistoryCollection.InsertOne(ctx, borrowHistory)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while inserting borrow history"})
-			return
-		}
-
-		// Activate user if deactivated
-		updateObj = bson.M{}
-
-		updateObj["is_active"] = true
-		updateObj["updated_at"] = time.Now()
-
-		filter = bson.M{"_id": bson.M{"$eq": memberId}}
-		update = bson.M{"$set": updateObj}
-
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "book borrowed successfully"})
-	}
-}
-
-func ReturnBook() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		isbn := c.Param("isbn")
-
-		memberIdStr := c.GetString("uid")
-		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
-			return
-		}
-
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-		defer cancel()
-
-		var book models.Book
-		err = BookCollection.FindOne(ctx, bson.M{"isbn": isbn}).Decode(&book)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "book not found in your borrowed list"})
-			return
-		}
-
-		// Update book
-		updateObj := bson.M{}
-
-		// updateObj["borrowed_by"] = nil
-		updateObj["status"] = models.STATUS_AVAILABLE
-		updateObj["updated_at"] = time.Now()
-		updateObj["qty"] = book.Qty + 1
-
-		filter := bson.M{"isbn": bson.M{"$eq": isbn}}
-		update := bson.M{"$set": updateObj}
-
-		_, err = BookCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating book"})
-			return
-		}
-
-		// Update borrow history
-		var borrowHistory models.BorrowHistory
-		err = BorrowHistoryCollection.FindOne(ctx, bson.M{"book_id": book.ID, "user_id": memberId, "status": models.STATUS_BORROWED}).Decode(&borrowHistory)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "borrow history not found"})
-			return
-		}
-
-		updateObj = bson.M{}
-
-		updateObj["status"] = models.STATUS_RETURNED
-		updateObj["returned_at"] = time.Now()
-
-		filter = bson.M{"_id": bson.M{"$eq": borrowHistory.ID}}
-		update = bson.M{"$set": updateObj}
-
-		_, err = BorrowHistoryCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating borrow history"})
-			return
-		}
-
-		// // Deactivate user if all books are returned
-		// var borrowedBooks []models.Book
-		// cursor, err := BookCollection.Find(ctx, bson.M{"borrowed_by": memberId})
-		// if err != nil {
-		// 	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing borrowed books"})
-		// 	return
-		// }
-
-		// if err = cursor.All(ctx, &borrowedBooks); err != nil {
-		// 	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while decoding borrowed books data"})
-		// 	return
-		// }
-
-		// if len(borrowedBooks) == 0 {
-		// 	updateObj = bson.M{}
-
-		// 	updateObj["is_active"] = false
-
-		// 	updateObj["updated_at"] = time.Now()
-
-		// 	filter = bson.M{"_id": bson.M{"$eq": memberId}}
-		// 	update = bson.M{"$set": updateObj}
-
-		// 	_, err = UserCollection.UpdateOne(ctx, filter, update)
-		// 	if err != nil {
-		// 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
-		// 		return
-		// 	}
-		// }
-
-		c.JSON(http.StatusOK, gin.H{"message": "book returned successfully"})
-	}
-}
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type BookSearchResponse struct {
+	PageResponse[models.Book]
+	Facets repository.BookFacets `json:"facets"`
+}
+
+// GetBooks searches the catalog. q runs a text search over the title,
+// author, contributors, subjects, series and description; author, status,
+// subject, language and publisher filter the matches and sort orders them. Facet counts by author and status
+// come back with the results, a page at a time. A page
+// asked for as MARC or a citation format comes without facets, its paging in
+// the X-Next-Cursor and X-Total-Count headers.
+func GetBooks(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := pageParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		query := repository.BookQuery{
+			Text:      c.Query("q"),
+			Author:    c.Query("author"),
+			Status:    c.Query("status"),
+			Subject:   c.Query("subject"),
+			Language:  c.Query("language"),
+			Publisher: c.Query("publisher"),
+			Sort:      c.Query("sort"),
+		}
+
+		if query.Status != "" && query.Status != models.STATUS_AVAILABLE && query.Status != models.STATUS_OUT_OF_STOCK {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be AVAILABLE or OUT_OF_STOCK"})
+			return
+		}
+
+		format, err := negotiateBookFormat(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		result, err := store.Books().Search(ctx, query, page)
+		if errors.Is(err, repository.ErrInvalidSort) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			pageErrorResponse(c, err, "Error occurred while listing books")
+			return
+		}
+
+		if format != nil {
+			c.Header(NEXT_CURSOR_HEADER, result.NextCursor)
+			c.Header(TOTAL_COUNT_HEADER, strconv.FormatInt(result.Total, 10))
+			writeBooks(c, format, result.Items, "books")
+			return
+		}
+
+		c.JSON(http.StatusOK, BookSearchResponse{PageResponse: pageResponse(&result.Paged), Facets: result.Facets})
+	}
+}
+
+// GetBook finds a book by its ISBN, as JSON, MARC or a citation.
+func GetBook(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		format, err := negotiateBookFormat(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		book, err := store.Books().FindByISBN(ctx, isbn)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "book not found"})
+			return
+		}
+
+		if book.Title == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "book not found"})
+			return
+		}
+
+		if format != nil {
+			writeBooks(c, format, []models.Book{*book}, *book.ISBN)
+			return
+		}
+
+		c.JSON(http.StatusOK, book)
+	}
+}
+
+func DeActivateMember(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		// make sure user returns all borrowed books before deactivating
+		borrowedBooksHistory, err := store.Loans().List(ctx, repository.LoanFilter{UserID: memberId, Statuses: repository.ActiveLoanStatuses})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing borrowed books"})
+			return
+		}
+
+		log.Println(borrowedBooksHistory)
+
+		if len(borrowedBooksHistory) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Return all borrowed books before deactivating user"})
+			return
+		}
+
+		// Deactivate user
+		updateObj := bson.M{}
+
+		updateObj["is_active"] = false
+		updateObj["updated_at"] = time.Now()
+
+		err = store.Users().Update(ctx, memberId, updateObj)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while updating user"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "user de-activated successfully"})
+	}
+}
+
+func BorrowedBooks(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		borrowedBooksHistory, err := store.Loans().List(ctx, repository.LoanFilter{UserID: memberId, Statuses: repository.ActiveLoanStatuses})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing borrowed books"})
+			return
+		}
+
+		var borrowedBooks []models.Book
+
+		for _, borrowedBook := range borrowedBooksHistory {
+			book, err := store.Books().FindByID(ctx, borrowedBook.BookID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching borrowed book"})
+				return
+			}
+
+			borrowedBooks = append(borrowedBooks, *book)
+		}
+
+		// Return an empty array if no books have been borrowed
+		if len(borrowedBooks) == 0 {
+			c.JSON(http.StatusOK, []models.Book{})
+			return
+		}
+
+		c.JSON(http.StatusOK, borrowedBooks)
+	}
+}
+
+var (
+	errBookNotFound    = errors.New("book not found")
+	errCopyNotFound    = errors.New("copy not found")
+	errOutOfStock      = errors.New("book is out of stock, place a hold to join the queue")
+	errCopyUnavailable = errors.New("this copy is not available for loan")
+	errLoanNotFound    = errors.New("book not found in your borrowed list")
+	errUnpaidFines     = errors.New("you have unpaid fines, please clear them before borrowing")
+)
+
+// borrowBook hands one copy of the book to the member and opens a loan for it.
+// A non-empty barcode asks for that particular copy, and override skips the
+// unpaid fines check. It must run inside a transaction so the copy, loan and
+// user writes land together.
+func borrowBook(ctx context.Context, store repository.Store, book *models.Book, barcode string, memberId primitive.ObjectID, override bool) error {
+	borrowedAt := time.Now()
+
+	balance, err := store.Fines().OutstandingBalance(ctx, memberId)
+	if err != nil {
+		return err
+	}
+
+	if balance > helpers.LOAN_POLICY.MaxOutstandingFines && !override {
+		return errUnpaidFines
+	}
+
+	if err := expireHolds(ctx, store, borrowedAt); err != nil {
+		return err
+	}
+
+	// a copy set aside for the member's hold is already off the shelf
+	ready, err := store.Holds().List(ctx, repository.HoldFilter{UserID: memberId, BookID: book.ID, Statuses: []string{models.STATUS_READY}})
+	if err != nil {
+		return err
+	}
+
+	var item, held *models.Copy
+	if len(ready) > 0 {
+		err = store.Holds().Update(ctx, ready[0].ID, bson.M{"status": models.STATUS_FULFILLED, "updated_at": borrowedAt})
+		if err != nil {
+			return err
+		}
+
+		if ready[0].CopyID != nil {
+			held, err = store.Copies().FindByID(ctx, *ready[0].CopyID)
+			if err != nil && !errors.Is(err, repository.ErrNotFound) {
+				return err
+			}
+		}
+	}
+
+	if held != nil && (barcode == "" || *held.Barcode == barcode) {
+		item = held
+		err = store.Copies().Update(ctx, item.ID, bson.M{"status": models.STATUS_BORROWED, "updated_at": borrowedAt})
+	} else {
+		item, err = store.Copies().Take(ctx, book.ID, barcode, models.STATUS_BORROWED, borrowedAt)
+	}
+	if errors.Is(err, repository.ErrOutOfStock) && barcode != "" {
+		return errCopyUnavailable
+	}
+	if errors.Is(err, repository.ErrOutOfStock) {
+		return errOutOfStock
+	}
+	if err != nil {
+		return err
+	}
+
+	// the member picked another copy, so the one set aside for them moves on
+	if held != nil && held.ID != item.ID {
+		if err = releaseCopy(ctx, store, *held, borrowedAt); err != nil {
+			return err
+		}
+	}
+
+	if err = syncStock(ctx, store, book.ID, borrowedAt); err != nil {
+		return err
+	}
+
+	borrowHistory := models.BorrowHistory{
+		UserID:     memberId,
+		BookID:     book.ID,
+		CopyID:     &item.ID,
+		Barcode:    *item.Barcode,
+		BorrowedAt: borrowedAt,
+		DueAt:      helpers.LOAN_POLICY.DueDate(borrowedAt),
+		Status:     models.STATUS_BORROWED,
+	}
+
+	err = store.Loans().Insert(ctx, &borrowHistory)
+	if err != nil {
+		return err
+	}
+
+	// Activate user if deactivated
+	return store.Users().Update(ctx, memberId, bson.M{"is_active": true, "updated_at": borrowedAt})
+}
+
+// returnBook closes the member's open loan matching filter, passes the copy to
+// the hold queue or back to the shelf and charges a fine if it came back late.
+func returnBook(ctx context.Context, store repository.Store, filter repository.LoanFilter) error {
+	returnedAt := time.Now()
+
+	loan, err := store.Loans().Close(ctx, filter, returnedAt)
+	if errors.Is(err, repository.ErrNotFound) {
+		return errLoanNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if loan.CopyID != nil {
+		item, err := store.Copies().FindByID(ctx, *loan.CopyID)
+		if err == nil {
+			err = releaseCopy(ctx, store, *item, returnedAt)
+		}
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+	}
+
+	daysLate, amount := helpers.LOAN_POLICY.LateFine(loan.DueAt, returnedAt)
+	if amount <= 0 {
+		return nil
+	}
+
+	fine := models.Fine{
+		UserID:    loan.UserID,
+		LoanID:    loan.ID,
+		BookID:    loan.BookID,
+		DaysLate:  daysLate,
+		Amount:    amount,
+		Status:    models.STATUS_UNPAID,
+		Payments:  []models.FinePayment{},
+		CreatedAt: returnedAt,
+		UpdatedAt: returnedAt,
+	}
+
+	return store.Fines().Insert(ctx, &fine)
+}
+
+func borrowResponse(c *gin.Context, err error) {
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": "book borrowed successfully"})
+	case errors.Is(err, errBookNotFound), errors.Is(err, errCopyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errOutOfStock), errors.Is(err, errCopyUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errUnpaidFines):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while borrowing book"})
+	}
+}
+
+func returnResponse(c *gin.Context, err error) {
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"message": "book returned successfully"})
+	case errors.Is(err, errLoanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while returning book"})
+	}
+}
+
+func BorrowBook(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		override := helpers.HasPermission(c, models.PERM_LOANS_OVERRIDE)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			book, err := store.Books().FindByISBN(ctx, isbn)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errBookNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			return borrowBook(ctx, store, book, "", memberId, override)
+		})
+
+		borrowResponse(c, err)
+	}
+}
+
+func BorrowCopy(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		barcode := c.Param("barcode")
+
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		override := helpers.HasPermission(c, models.PERM_LOANS_OVERRIDE)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			item, err := store.Copies().FindByBarcode(ctx, barcode)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errCopyNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			book, err := store.Books().FindByID(ctx, item.BookID)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errCopyNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			return borrowBook(ctx, store, book, barcode, memberId, override)
+		})
+
+		borrowResponse(c, err)
+	}
+}
+
+func ReturnBook(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		isbn := isbnParam(c)
+
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			book, err := store.Books().FindByISBN(ctx, isbn)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errLoanNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			return returnBook(ctx, store, repository.LoanFilter{UserID: memberId, BookID: book.ID})
+		})
+
+		returnResponse(c, err)
+	}
+}
+
+func ReturnCopy(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		barcode := c.Param("barcode")
+
+		memberIdStr := c.GetString("uid")
+		memberId, err := primitive.ObjectIDFromHex(memberIdStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			item, err := store.Copies().FindByBarcode(ctx, barcode)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errLoanNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			return returnBook(ctx, store, repository.LoanFilter{UserID: memberId, CopyID: item.ID})
+		})
+
+		returnResponse(c, err)
+	}
+}
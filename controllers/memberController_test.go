@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestBorrowBookLastCopyIsNotOversold covers the transaction path on the
+// memory store, which runs one transaction at a time; that Copies().Take
+// itself hands out a copy once is tested in the repository package.
+func TestBorrowBookLastCopyIsNotOversold(t *testing.T) {
+	const members = 20
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+
+	isbn, title, author := "9780131103627", "The C Programming Language", "Brian W. Kernighan"
+	book := models.Book{ISBN: &isbn, Title: &title, Author: &author}
+	if err := createBook(ctx, store, &book, make([]models.Copy, 1), time.Now()); err != nil {
+		t.Fatalf("createBook: %v", err)
+	}
+
+	memberIds := make([]primitive.ObjectID, members)
+	for i := range memberIds {
+		role, active := models.ROLE_MEMBER, true
+		user := models.User{Role: &role, IsActive: &active}
+		if err := store.Users().Insert(ctx, &user); err != nil {
+			t.Fatalf("insert member: %v", err)
+		}
+		memberIds[i] = user.ID
+	}
+
+	errs := make([]error, members)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i, memberId := range memberIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			errs[i] = store.WithTransaction(ctx, func(ctx context.Context) error {
+				book, err := store.Books().FindByISBN(ctx, isbn)
+				if err != nil {
+					return err
+				}
+
+				return borrowBook(ctx, store, book, "", memberId, false)
+			})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	borrowed := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			borrowed++
+		case errors.Is(err, errOutOfStock):
+		default:
+			t.Errorf("member %d: unexpected error %v", i, err)
+		}
+	}
+	if borrowed != 1 {
+		t.Errorf("%d members borrowed the only copy, want 1", borrowed)
+	}
+
+	loans, err := store.Loans().List(ctx, repository.LoanFilter{BookID: book.ID, Statuses: repository.ActiveLoanStatuses})
+	if err != nil {
+		t.Fatalf("list loans: %v", err)
+	}
+	if len(loans) != 1 {
+		t.Errorf("%d open loans, want 1", len(loans))
+	}
+
+	stocked, err := store.Books().FindByISBN(ctx, isbn)
+	if err != nil {
+		t.Fatalf("find book: %v", err)
+	}
+	if stocked.Qty != 0 || stocked.Status == nil || *stocked.Status != models.STATUS_OUT_OF_STOCK {
+		t.Errorf("book has qty %d, status %v, want 0 and OUT_OF_STOCK", stocked.Qty, stocked.Status)
+	}
+}
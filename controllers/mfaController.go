@@ -0,0 +1,496 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MFA_CHALLENGE_TTL      = 5 * time.Minute
+	MFA_CHALLENGE_ATTEMPTS = 3
+	MFA_RECOVERY_CODES     = 10
+)
+
+var (
+	errInvalidMFAToken = errors.New("the MFA token is invalid, used or expired")
+	errInvalidMFACode  = errors.New("the code is incorrect")
+	errMFAEnabled      = errors.New("MFA is already enabled")
+	errMFANotEnrolled  = errors.New("MFA enrollment has not been started")
+	errMFARequired     = errors.New("MFA is required for this role and cannot be turned off")
+)
+
+// MFAEnrollment is the secret of a new TOTP enrollment. ProvisioningURI is
+// meant to be shown as a QR code for an authenticator app to scan.
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// MFAChallengeResponse answers a correct password when a TOTP code is needed
+// as well. Enrollment is set when the role requires MFA and the user has not
+// enrolled yet; the first code confirms it.
+type MFAChallengeResponse struct {
+	MFARequired bool           `json:"mfa_required"`
+	MFAToken    string         `json:"mfa_token"`
+	ExpiresIn   int64          `json:"expires_in"`
+	Enrollment  *MFAEnrollment `json:"mfa_enrollment,omitempty"`
+}
+
+// MFALoginResponse is the login response of a user who just enrolled, along
+// with recovery codes that are shown this once.
+type MFALoginResponse struct {
+	*TokenResponse
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// needsMFA reports whether user has to pass a second step to log in.
+func needsMFA(user *models.User) bool {
+	return user.MFAEnabled() || helper.MFARequired(*user.Role)
+}
+
+// startMFAEnrollment keeps a new TOTP secret on user, replacing an enrollment
+// that was never confirmed.
+func startMFAEnrollment(ctx context.Context, store repository.Store, user *models.User) (*MFAEnrollment, error) {
+	secret, err := helper.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user.MFA = &models.MFA{Secret: secret}
+	if err := store.Users().Update(ctx, user.ID, bson.M{"mfa": user.MFA}); err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{Secret: secret, ProvisioningURI: helper.TOTPProvisioningURI(*user.Username, secret)}, nil
+}
+
+// startMFAChallenge opens the second step of the login of user, starting an
+// enrollment first if the role requires MFA and the user has none.
+func startMFAChallenge(ctx context.Context, store repository.Store, user *models.User, now time.Time) (*MFAChallengeResponse, error) {
+	response := MFAChallengeResponse{MFARequired: true, ExpiresIn: int64(MFA_CHALLENGE_TTL.Seconds())}
+
+	if !user.MFAEnabled() {
+		enrollment, err := startMFAEnrollment(ctx, store, user)
+		if err != nil {
+			return nil, err
+		}
+
+		response.Enrollment = enrollment
+	}
+
+	token, hash, err := helper.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := models.MFAChallenge{
+		UserID:    user.ID,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(MFA_CHALLENGE_TTL),
+	}
+	if err := store.MFAChallenges().Insert(ctx, &challenge); err != nil {
+		return nil, err
+	}
+
+	response.MFAToken = token
+	return &response, nil
+}
+
+// checkMFACode checks code against the TOTP secret of mfa, or once the
+// enrollment is confirmed against its unused recovery codes. It returns mfa
+// as it has to be stored afterwards, with the step or recovery code used up,
+// and which of them it was for Users().UseMFACode.
+func checkMFACode(mfa *models.MFA, code string, now time.Time) (*models.MFA, repository.MFACode, bool) {
+	if mfa == nil || mfa.Secret == "" {
+		return nil, repository.MFACode{}, false
+	}
+
+	updated := *mfa
+	code = strings.TrimSpace(code)
+
+	if step, ok := helper.MatchTOTP(mfa.Secret, code, now, mfa.LastStep); ok {
+		updated.LastStep = step
+		return &updated, repository.MFACode{Step: step}, true
+	}
+
+	if !mfa.Enabled {
+		return nil, repository.MFACode{}, false
+	}
+
+	hash := helper.HashSecret(strings.ToLower(code))
+	if i := slices.Index(mfa.RecoveryHashes, hash); i >= 0 {
+		updated.RecoveryHashes = slices.Delete(slices.Clone(mfa.RecoveryHashes), i, i+1)
+		return &updated, repository.MFACode{RecoveryHash: hash}, true
+	}
+
+	return nil, repository.MFACode{}, false
+}
+
+// useMFACode stores mfa once code is used up, returning errInvalidMFACode if
+// another request used it first.
+func useMFACode(ctx context.Context, store repository.Store, userId primitive.ObjectID, code repository.MFACode, mfa *models.MFA) error {
+	err := store.Users().UseMFACode(ctx, userId, code, mfa)
+	if errors.Is(err, repository.ErrNotFound) {
+		return errInvalidMFACode
+	}
+
+	return err
+}
+
+// confirmMFA turns MFA on for a checked enrollment, returning the recovery
+// codes to show the user.
+func confirmMFA(mfa *models.MFA, now time.Time) ([]string, error) {
+	codes, hashes, err := helper.GenerateRecoveryCodes(MFA_RECOVERY_CODES)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa.Enabled = true
+	mfa.EnabledAt = &now
+	mfa.RecoveryHashes = hashes
+
+	return codes, nil
+}
+
+func mfaErrorResponse(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, errInvalidMFAToken), errors.Is(err, errInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, errMFAEnabled), errors.Is(err, errMFANotEnrolled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errMFARequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+// UserLogInMFA is the second step of a login with MFA: the token from
+// UserLogIn and a TOTP or recovery code give the access and refresh tokens.
+func UserLogInMFA(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			MFAToken string `json:"mfa_token" validate:"required"`
+			Code     string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := userValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		now := time.Now()
+
+		challenge, err := store.MFAChallenges().FindActive(ctx, helper.HashSecret(body.MFAToken), now)
+		if errors.Is(err, repository.ErrNotFound) {
+			mfaErrorResponse(c, errInvalidMFAToken, "")
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking MFA token"})
+			return
+		}
+
+		user, err := store.Users().FindByID(ctx, challenge.UserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		// codes are guessed more easily than passwords, so they count the same
+		userKey, ipKey := loginKeys(c, *user.Username)
+
+		attempts, until, err := reserveLogin(ctx, store, now, userKey, ipKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking login attempts"})
+			return
+		}
+
+		if until.After(now) {
+			tooManyLogins(c, until, now)
+			return
+		}
+
+		mfa, used, ok := checkMFACode(user.MFA, body.Code, now)
+		if !ok {
+			err := store.MFAChallenges().Fail(ctx, challenge.ID, MFA_CHALLENGE_ATTEMPTS, now)
+			if err == nil {
+				err = lockOutLogin(ctx, store, c, attempts, userKey, ipKey, now)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while recording login attempt"})
+				return
+			}
+
+			mfaErrorResponse(c, errInvalidMFACode, "")
+			return
+		}
+
+		var recoveryCodes []string
+		if !mfa.Enabled {
+			if recoveryCodes, err = confirmMFA(mfa, now); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while enabling MFA"})
+				return
+			}
+		}
+
+		var response *TokenResponse
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			err := store.MFAChallenges().Redeem(ctx, challenge.ID, now)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errInvalidMFAToken
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := useMFACode(ctx, store, user.ID, used, mfa); err != nil {
+				return err
+			}
+
+			if err := forgiveLogin(ctx, store, attempts); err != nil {
+				return err
+			}
+
+			if err := store.LoginAttempts().Clear(ctx, userKey); err != nil {
+				return err
+			}
+
+			user.MFA = mfa
+			response, err = startSession(ctx, store, c, user, now)
+			return err
+		})
+		if err != nil {
+			mfaErrorResponse(c, err, "Error occurred while logging in")
+			return
+		}
+
+		c.JSON(http.StatusOK, MFALoginResponse{TokenResponse: response, RecoveryCodes: recoveryCodes})
+	}
+}
+
+// loggedInUser loads the user the access token of c belongs to.
+func loggedInUser(ctx context.Context, store repository.Store, c *gin.Context) (*models.User, error) {
+	userId, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+	if err != nil {
+		return nil, repository.ErrNotFound
+	}
+
+	return store.Users().FindByID(ctx, userId)
+}
+
+// EnrollMFA starts a TOTP enrollment for the logged in user, which
+// ConfirmMFA completes.
+func EnrollMFA(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		user, err := loggedInUser(ctx, store, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		if user.MFAEnabled() {
+			mfaErrorResponse(c, errMFAEnabled, "")
+			return
+		}
+
+		enrollment, err := startMFAEnrollment(ctx, store, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while starting MFA enrollment"})
+			return
+		}
+
+		c.JSON(http.StatusOK, enrollment)
+	}
+}
+
+// ConfirmMFA turns MFA on with a code from the authenticator app and returns
+// the recovery codes.
+func ConfirmMFA(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Code string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := userValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		user, err := loggedInUser(ctx, store, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		if user.MFAEnabled() {
+			mfaErrorResponse(c, errMFAEnabled, "")
+			return
+		}
+
+		if user.MFA == nil {
+			mfaErrorResponse(c, errMFANotEnrolled, "")
+			return
+		}
+
+		now := time.Now()
+		mfa, used, ok := checkMFACode(user.MFA, body.Code, now)
+		if !ok {
+			mfaErrorResponse(c, errInvalidMFACode, "")
+			return
+		}
+
+		recoveryCodes, err := confirmMFA(mfa, now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while enabling MFA"})
+			return
+		}
+
+		if err := useMFACode(ctx, store, user.ID, used, mfa); err != nil {
+			mfaErrorResponse(c, err, "Error occurred while enabling MFA")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "MFA enabled successfully", "recovery_codes": recoveryCodes})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the logged in user,
+// who proves to still have the authenticator with a code.
+func RegenerateRecoveryCodes(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Code string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := userValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		user, err := loggedInUser(ctx, store, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		if !user.MFAEnabled() {
+			mfaErrorResponse(c, errMFANotEnrolled, "")
+			return
+		}
+
+		now := time.Now()
+		mfa, used, ok := checkMFACode(user.MFA, body.Code, now)
+		if !ok {
+			mfaErrorResponse(c, errInvalidMFACode, "")
+			return
+		}
+
+		codes, hashes, err := helper.GenerateRecoveryCodes(MFA_RECOVERY_CODES)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while creating recovery codes"})
+			return
+		}
+
+		mfa.RecoveryHashes = hashes
+		if err := useMFACode(ctx, store, user.ID, used, mfa); err != nil {
+			mfaErrorResponse(c, err, "Error occurred while creating recovery codes")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// DisableMFA turns MFA off for the logged in user, which takes the password
+// and a code. Roles that require MFA cannot turn it off.
+func DisableMFA(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Password string `json:"password" validate:"required"`
+			Code     string `json:"code" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := userValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		user, err := loggedInUser(ctx, store, c)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		if !user.MFAEnabled() {
+			mfaErrorResponse(c, errMFANotEnrolled, "")
+			return
+		}
+
+		if helper.MFARequired(*user.Role) {
+			mfaErrorResponse(c, errMFARequired, "")
+			return
+		}
+
+		if !verifyUserPassword(user, body.Password) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "the password is incorrect"})
+			return
+		}
+
+		_, used, ok := checkMFACode(user.MFA, body.Code, time.Now())
+		if !ok {
+			mfaErrorResponse(c, errInvalidMFACode, "")
+			return
+		}
+
+		if err := useMFACode(ctx, store, user.ID, used, nil); err != nil {
+			mfaErrorResponse(c, err, "Error occurred while disabling MFA")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "MFA disabled successfully"})
+	}
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+func TestMFACodeIsUsedOnce(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	now := time.Now()
+
+	secret, err := helper.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes, hashes, err := helper.GenerateRecoveryCodes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	role := models.ROLE_MEMBER
+	user := models.User{Role: &role, MFA: &models.MFA{Secret: secret, Enabled: true, RecoveryHashes: hashes}}
+	if err := store.Users().Insert(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := helper.TOTPCode(secret, helper.TOTPStep(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two requests that both checked the code before either stored it
+	for _, code := range []string{code, codes[0]} {
+		first, used, ok := checkMFACode(user.MFA, code, now)
+		if !ok {
+			t.Fatalf("code %s refused", code)
+		}
+		second, _, _ := checkMFACode(user.MFA, code, now)
+
+		if err := useMFACode(ctx, store, user.ID, used, first); err != nil {
+			t.Errorf("first use of %s: %v", code, err)
+		}
+		if err := useMFACode(ctx, store, user.ID, used, second); !errors.Is(err, errInvalidMFACode) {
+			t.Errorf("second use of %s: %v, want errInvalidMFACode", code, err)
+		}
+	}
+}
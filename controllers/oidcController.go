@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDC_LOGIN_TTL is how long the user has at the OpenID provider before the
+// login has to be started again.
+const OIDC_LOGIN_TTL = 10 * time.Minute
+
+var (
+	errOIDCDisabled = errors.New("OIDC login is not configured")
+	errOIDCLogin    = errors.New("the login has expired or was already completed, start it again")
+	errOIDCLinked   = errors.New("the account with this email is linked to another OpenID account")
+	errOIDCUnlinked = errors.New("an account with this email already exists, log in with its password")
+)
+
+// oidcErrorResponse answers a failed OIDC login.
+func oidcErrorResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errOIDCDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errOIDCLogin):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, helper.ErrOIDCIDToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrOIDCIDToken.Error()})
+	case errors.Is(err, helper.ErrOIDCProvider):
+		c.JSON(http.StatusBadGateway, gin.H{"error": helper.ErrOIDCProvider.Error()})
+	case errors.Is(err, errUserExists), errors.Is(err, errOIDCLinked), errors.Is(err, errOIDCUnlinked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errRoleNotFound):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while logging in through OIDC"})
+	}
+}
+
+// OIDCLogin starts a login at the OpenID provider: it remembers the state,
+// nonce and PKCE verifier of the login and redirects to the provider.
+func OIDCLogin(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helper.OIDC_CONFIG.Enabled() {
+			oidcErrorResponse(c, errOIDCDisabled)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		state, stateHash, err := helper.GenerateSecret()
+		if err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		nonce, _, err := helper.GenerateSecret()
+		if err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		verifier, challenge, err := helper.GeneratePKCE()
+		if err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		target, err := helper.OIDCAuthorizationURL(ctx, state, nonce, challenge)
+		if err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		now := time.Now()
+		login := models.OIDCLogin{
+			StateHash:    stateHash,
+			Nonce:        nonce,
+			CodeVerifier: verifier,
+			CreatedAt:    now,
+			ExpiresAt:    now.Add(OIDC_LOGIN_TTL),
+		}
+
+		if err := store.OIDCLogins().Insert(ctx, &login); err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		c.Redirect(http.StatusFound, target)
+	}
+}
+
+// OIDCCallback finishes a login the provider sent back with an authorization
+// code, answering like a password login: users with MFA get a challenge to
+// complete through UserLogInMFA. Members are created on their first login,
+// and users whose claims map to a role get that role.
+func OIDCCallback(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helper.OIDC_CONFIG.Enabled() {
+			oidcErrorResponse(c, errOIDCDisabled)
+			return
+		}
+
+		if refused := c.Query("error"); refused != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("the OpenID provider refused the login: %s %s", refused, c.Query("error_description"))})
+			return
+		}
+
+		code, state := c.Query("code"), c.Query("state")
+		if code == "" || state == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		now := time.Now()
+
+		login, err := store.OIDCLogins().Redeem(ctx, helper.HashSecret(state), now)
+		if errors.Is(err, repository.ErrNotFound) {
+			err = errOIDCLogin
+		}
+		if err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		idToken, err := helper.ExchangeOIDCCode(ctx, code, login.CodeVerifier)
+		if err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		identity, err := helper.VerifyOIDCIDToken(ctx, idToken, login.Nonce)
+		if err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		var user, before *models.User
+		var action string
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			user, before, action, err = oidcUser(ctx, store, identity, now)
+			return err
+		})
+		if err != nil {
+			oidcErrorResponse(c, err)
+			return
+		}
+
+		// the user acts on their own account, no one is logged in yet
+		if action != "" {
+			entry := auditChange(c, action, "user:"+user.ID.Hex(), before, user)
+			entry.ActorID, entry.ActorRole = &user.ID, *user.Role
+		}
+
+		switch user.AccountStatus {
+		case models.STATUS_PENDING:
+			c.JSON(http.StatusForbidden, gin.H{"error": "your account is waiting for a librarian's approval"})
+			return
+		case models.STATUS_REJECTED:
+			c.JSON(http.StatusForbidden, gin.H{"error": "your signup was rejected"})
+			return
+		}
+
+		// the provider stands in for the password, not for the second factor
+		if needsMFA(user) {
+			challenge, err := startMFAChallenge(ctx, store, user, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while starting MFA"})
+				return
+			}
+
+			c.JSON(http.StatusOK, challenge)
+			return
+		}
+
+		response, err := startSession(ctx, store, c, user, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		entry := auditChange(c, models.AUDIT_LOGIN_OIDC, "user:"+user.ID.Hex(), nil, nil)
+		entry.ActorID, entry.ActorRole = &user.ID, *user.Role
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// oidcUser finds the user identity logs in as: the one linked to it, or else
+// the one named after its verified email, which gets linked if
+// OIDC_CONFIG.LinkByEmail allows it. Without either a member is created. A
+// role mapped from the claims replaces the user's role.
+// before and action describe the change made to the user, action is empty
+// if there was none. It must run inside a transaction.
+func oidcUser(ctx context.Context, store repository.Store, identity *helper.OIDCIdentity, now time.Time) (user, before *models.User, action string, err error) {
+	role, mapped := helper.OIDC_CONFIG.MapRole(identity.Roles)
+	if mapped {
+		if _, err := store.Roles().FindByName(ctx, role); errors.Is(err, repository.ErrNotFound) {
+			return nil, nil, "", fmt.Errorf("%w: %s, mapped from the OIDC claims", errRoleNotFound, role)
+		} else if err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	link := &models.OIDCLink{Issuer: identity.Issuer, Subject: identity.Subject}
+
+	user, err = store.Users().FindByOIDC(ctx, identity.Issuer, identity.Subject)
+	if errors.Is(err, repository.ErrNotFound) && identity.Email != "" && identity.EmailVerified {
+		user, err = store.Users().FindByUsername(ctx, identity.Email)
+		switch {
+		case err == nil && user.OIDC != nil:
+			return nil, nil, "", errOIDCLinked
+		case err == nil && !helper.OIDC_CONFIG.LinkByEmail:
+			return nil, nil, "", errOIDCUnlinked
+		}
+	}
+
+	if errors.Is(err, repository.ErrNotFound) {
+		username := identity.Subject
+		switch {
+		case identity.Email != "" && identity.EmailVerified:
+			username = identity.Email
+		case identity.PreferredUsername != "":
+			username = identity.PreferredUsername
+		}
+
+		if !mapped {
+			role = models.ROLE_MEMBER
+		}
+
+		user = &models.User{ID: primitive.NewObjectID(), Username: &username, OIDC: link}
+		if err := signUp(ctx, store, user, role, models.STATUS_APPROVED, now); err != nil {
+			return nil, nil, "", err
+		}
+
+		return user, nil, models.AUDIT_USER_PROVISION, nil
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	action = models.AUDIT_USER_UPDATE
+
+	set := bson.M{}
+	if user.OIDC == nil {
+		set["oidc"] = link
+		action = models.AUDIT_USER_LINK_OIDC
+	}
+
+	if mapped && (user.Role == nil || *user.Role != role) {
+		set["role"] = role
+	}
+
+	if len(set) == 0 {
+		return user, nil, "", nil
+	}
+
+	set["updated_at"] = now
+	if err := store.Users().Update(ctx, user.ID, set); err != nil {
+		return nil, nil, "", err
+	}
+
+	previous := *user
+	user.OIDC = link
+	user.UpdatedAt = now
+	if _, ok := set["role"]; ok {
+		user.Role = &role
+	}
+
+	return user, &previous, action, nil
+}
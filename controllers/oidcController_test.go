@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+func TestOIDCLinksByEmailOnlyWhenEnabled(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	now := time.Now()
+
+	role, username := models.ROLE_LIBRARIAN, "alice@iitb.ac.in"
+	user := models.User{Username: &username, Role: &role}
+	if err := store.Users().Insert(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	identity := &helper.OIDCIdentity{Issuer: "http://idp", Subject: "alice", Email: username, EmailVerified: true}
+	login := func() (*models.User, string, error) {
+		var found *models.User
+		var action string
+		err := store.WithTransaction(ctx, func(ctx context.Context) (err error) {
+			found, _, action, err = oidcUser(ctx, store, identity, now)
+			return err
+		})
+		return found, action, err
+	}
+
+	config := helper.OIDC_CONFIG
+	defer func() { helper.OIDC_CONFIG = config }()
+
+	helper.OIDC_CONFIG.LinkByEmail = false
+	if _, _, err := login(); !errors.Is(err, errOIDCUnlinked) {
+		t.Fatalf("login with linking off: %v, want errOIDCUnlinked", err)
+	}
+	if _, err := store.Users().FindByOIDC(ctx, identity.Issuer, identity.Subject); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("identity linked with linking off: %v", err)
+	}
+
+	helper.OIDC_CONFIG.LinkByEmail = true
+	found, action, err := login()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != user.ID || action != models.AUDIT_USER_LINK_OIDC {
+		t.Fatalf("logged in as %s with action %q, want %s linked", found.ID.Hex(), action, user.ID.Hex())
+	}
+
+	// once linked, the identity finds the user without looking at the email
+	helper.OIDC_CONFIG.LinkByEmail = false
+	if found, action, err = login(); err != nil || found.ID != user.ID || action != "" {
+		t.Fatalf("second login: %v, %q", err, action)
+	}
+}
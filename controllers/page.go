@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+// Headers carrying the paging of a list answered in a format without room for
+// the envelope, like a MARC export.
+const (
+	NEXT_CURSOR_HEADER = "X-Next-Cursor"
+	TOTAL_COUNT_HEADER = "X-Total-Count"
+)
+
+// PageResponse is the envelope every list endpoint answers with. Pass
+// next_cursor back as cursor to fetch the following page; it is empty on the
+// last one.
+type PageResponse[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor"`
+	Total      int64  `json:"total"`
+}
+
+func pageResponse[T any](paged *repository.Paged[T]) PageResponse[T] {
+	return PageResponse[T]{Data: paged.Items, NextCursor: paged.NextCursor, Total: paged.Total}
+}
+
+// pageParams reads the limit and cursor query parameters.
+func pageParams(c *gin.Context) (repository.Page, error) {
+	page := repository.Page{Limit: repository.DefaultPageLimit, Cursor: c.Query("cursor")}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > repository.MaxPageLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", repository.MaxPageLimit)
+		}
+		page.Limit = n
+	}
+
+	return page, nil
+}
+
+// pageErrorResponse answers a failed listing, blaming the caller for a cursor
+// that does not decode.
+func pageErrorResponse(c *gin.Context, err error, message string) {
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
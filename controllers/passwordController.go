@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errInvalidResetToken = errors.New("the reset token is invalid, used or expired")
+
+// setPassword stores the already hashed password of the user, then logs the
+// user out everywhere and voids any reset token still outstanding. It must
+// run inside a transaction.
+func setPassword(ctx context.Context, store repository.Store, userId primitive.ObjectID, hash string, now time.Time) error {
+	if err := store.Users().Update(ctx, userId, bson.M{"password": hash, "updated_at": now}); err != nil {
+		return err
+	}
+
+	if _, err := store.Sessions().RevokeByUser(ctx, userId, now); err != nil {
+		return err
+	}
+
+	return store.PasswordResets().InvalidateByUser(ctx, userId, now)
+}
+
+// ChangePassword replaces the password of the logged in user, who has to
+// give the current one. Every session of the user ends, so the response
+// carries a fresh pair of tokens.
+func ChangePassword(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		var body struct {
+			CurrentPassword string `json:"current_password" validate:"required"`
+			NewPassword     string `json:"new_password" validate:"required,min=4"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := userValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		user, err := store.Users().FindByID(ctx, userId)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		if !verifyUserPassword(user, body.CurrentPassword) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "the current password is incorrect"})
+			return
+		}
+
+		hash := HashPassword(body.NewPassword)
+		user.Password = &hash
+
+		var response *TokenResponse
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			now := time.Now()
+			if err := setPassword(ctx, store, userId, hash, now); err != nil {
+				return err
+			}
+
+			var err error
+			response, err = startSession(ctx, store, c, user, now)
+			return err
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while changing password"})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// ForgotPassword sends a reset token to the user through helper.NOTIFIER.
+// It answers the same whether or not the username exists, so it cannot be
+// used to find out which accounts there are.
+func ForgotPassword(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Username string `json:"username" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := userValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		sent := gin.H{"message": "if the account exists, a reset token has been sent to its owner"}
+
+		user, err := store.Users().FindByUsername(ctx, body.Username)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusOK, sent)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while fetching user"})
+			return
+		}
+
+		token, hash, err := helper.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while creating reset token"})
+			return
+		}
+
+		now := time.Now()
+		reset := models.PasswordReset{
+			UserID:    user.ID,
+			TokenHash: hash,
+			CreatedAt: now,
+			ExpiresAt: now.Add(helper.PASSWORD_RESET_TTL),
+		}
+
+		// only the newest token works
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			if err := store.PasswordResets().InvalidateByUser(ctx, user.ID, now); err != nil {
+				return err
+			}
+
+			return store.PasswordResets().Insert(ctx, &reset)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while creating reset token"})
+			return
+		}
+
+		message := helper.Message{
+			To:      *user.Username,
+			Subject: "Password reset",
+			Body:    fmt.Sprintf("Use the token %s with POST /users/password/reset before %s.", token, reset.ExpiresAt.Format(time.RFC3339)),
+			SentAt:  now,
+		}
+		if err := helper.NOTIFIER.Notify(ctx, message); err != nil {
+			log.Printf("error sending password reset to %s: %v", *user.Username, err)
+		}
+
+		c.JSON(http.StatusOK, sent)
+	}
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword. The
+// token works once, and every session of the user ends.
+func ResetPassword(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Token       string `json:"token" validate:"required"`
+			NewPassword string `json:"new_password" validate:"required,min=4"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := userValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		hash := HashPassword(body.NewPassword)
+
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			now := time.Now()
+
+			reset, err := store.PasswordResets().Redeem(ctx, helper.HashSecret(body.Token), now)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errInvalidResetToken
+			}
+			if err != nil {
+				return err
+			}
+
+			return setPassword(ctx, store, reset.UserID, hash, now)
+		})
+		switch {
+		case err == nil:
+			c.JSON(http.StatusOK, gin.H{"message": "password reset successfully, log in with the new password"})
+		case errors.Is(err, errInvalidResetToken):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while resetting password"})
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var roleValidate = validator.New()
+
+var (
+	errRoleNotFound = errors.New("role not found")
+	errRoleExists   = errors.New("a role with this name already exists")
+	errRoleBuiltIn  = errors.New("built-in roles cannot be deleted")
+	errRoleAdmin    = errors.New("the ADMIN role always holds every permission")
+	errRoleInUse    = errors.New("the role is still assigned to users")
+	errRoleGrant    = errors.New("you cannot grant a role with permissions you do not hold")
+	errRoleOutranks = errors.New("you cannot manage a user whose role has permissions you do not hold")
+	errRoleExceeds  = errors.New("you cannot give a role permissions you do not hold")
+)
+
+// defaultRoles are the built-in roles. LIBRARIAN and MEMBER grant what the
+// librarian and member routes needed before roles were stored.
+func defaultRoles() []models.Role {
+	role := func(name, description string, permissions ...string) models.Role {
+		return models.Role{Name: &name, Description: description, Permissions: permissions, BuiltIn: true}
+	}
+
+	return []models.Role{
+		role(models.ROLE_ADMIN, "Every permission, including managing roles", models.PERMISSIONS...),
+		role(models.ROLE_LIBRARIAN, "Runs the catalog, the circulation desk and member accounts",
+			models.PERM_BOOKS_READ, models.PERM_BOOKS_WRITE, models.PERM_COPIES_READ, models.PERM_COPIES_WRITE,
+			models.PERM_HOLDS_READ, models.PERM_HOLDS_CANCEL, models.PERM_LOANS_READ,
+			models.PERM_FINES_READ, models.PERM_FINES_COLLECT, models.PERM_FINES_WAIVE,
+			models.PERM_USERS_READ, models.PERM_USERS_WRITE, models.PERM_USERS_DEACTIVATE, models.PERM_USERS_DELETE,
+			models.PERM_SESSIONS_REVOKE, models.PERM_AUDIT_READ, models.PERM_API_KEYS_MANAGE,
+		),
+		role(models.ROLE_MEMBER, "Borrows books and places holds",
+			models.PERM_BOOKS_READ, models.PERM_LOANS_BORROW, models.PERM_HOLDS_PLACE, models.PERM_ACCOUNT_DELETE,
+		),
+	}
+}
+
+// EnsureRoles creates the built-in roles that are missing. Roles already in
+// the database keep their permissions, except ADMIN which is brought up to
+// every permission known to this version.
+func EnsureRoles(store repository.Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	for _, role := range defaultRoles() {
+		found, err := store.Roles().FindByName(ctx, *role.Name)
+		if errors.Is(err, repository.ErrNotFound) {
+			role.CreatedAt = now
+			role.UpdatedAt = now
+			if err := store.Roles().Insert(ctx, &role); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if *role.Name == models.ROLE_ADMIN && !slices.Equal(found.Permissions, role.Permissions) {
+			err = store.Roles().Update(ctx, found.ID, bson.M{"permissions": role.Permissions, "updated_at": now})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !slices.Contains(models.PERMISSIONS, permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+
+	return nil
+}
+
+// findGrantableRole returns the named role if the user behind c may hand it
+// out, that is if c holds every permission the role grants besides the
+// SELF_PERMISSIONS.
+func findGrantableRole(ctx context.Context, store repository.Store, c *gin.Context, name string) (*models.Role, error) {
+	role, err := store.Roles().FindByName(ctx, name)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errRoleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !holdsAll(c, role.Permissions) {
+		return nil, errRoleGrant
+	}
+
+	return role, nil
+}
+
+// checkManageable returns errRoleOutranks unless the user behind c holds every
+// permission the current role of user grants, by the rule findGrantableRole
+// applies to the role given. A role that no longer exists grants nothing.
+func checkManageable(ctx context.Context, store repository.Store, c *gin.Context, user *models.User) error {
+	if user.Role == nil {
+		return nil
+	}
+
+	role, err := store.Roles().FindByName(ctx, *user.Role)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !holdsAll(c, role.Permissions) {
+		return errRoleOutranks
+	}
+
+	return nil
+}
+
+// holdsAll reports whether the user behind c holds every one of permissions
+// that reaches beyond the holder's own account.
+func holdsAll(c *gin.Context, permissions []string) bool {
+	for _, permission := range permissions {
+		if !slices.Contains(models.SELF_PERMISSIONS, permission) && !helper.HasPermission(c, permission) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func roleErrorResponse(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, errRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, errRoleExists), errors.Is(err, errRoleInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errRoleBuiltIn), errors.Is(err, errRoleAdmin), errors.Is(err, errRoleGrant), errors.Is(err, errRoleOutranks), errors.Is(err, errRoleExceeds):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+func GetRoles(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		roles, err := store.Roles().List(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing roles"})
+			return
+		}
+
+		c.JSON(http.StatusOK, roles)
+	}
+}
+
+func AddRole(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var role models.Role
+		if err := c.BindJSON(&role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := roleValidate.Struct(role); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if err := validatePermissions(role.Permissions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// whoever can grant the role could otherwise take on the rest
+		if !holdsAll(c, role.Permissions) {
+			roleErrorResponse(c, errRoleExceeds, "Error occurred while adding role")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		now := time.Now()
+		role.ID = primitive.NilObjectID
+		role.BuiltIn = false
+		role.CreatedAt = now
+		role.UpdatedAt = now
+
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			_, err := store.Roles().FindByName(ctx, *role.Name)
+			if err == nil {
+				return errRoleExists
+			}
+			if !errors.Is(err, repository.ErrNotFound) {
+				return err
+			}
+
+			return store.Roles().Insert(ctx, &role)
+		})
+		if err != nil {
+			roleErrorResponse(c, err, "Error occurred while adding role")
+			return
+		}
+
+		auditChange(c, models.AUDIT_ROLE_CREATE, "role:"+*role.Name, nil, role)
+		c.JSON(http.StatusCreated, role)
+	}
+}
+
+func UpdateRole(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		var body struct {
+			Description *string  `json:"description"`
+			Permissions []string `json:"permissions" validate:"omitempty,dive,required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := roleValidate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if err := validatePermissions(body.Permissions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !holdsAll(c, body.Permissions) {
+			roleErrorResponse(c, errRoleExceeds, "Error occurred while updating role")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var found, role *models.Role
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			found, err = store.Roles().FindByName(ctx, name)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errRoleNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			if name == models.ROLE_ADMIN && body.Permissions != nil {
+				return errRoleAdmin
+			}
+
+			// a role one could not grant is not one's to change either
+			if !holdsAll(c, found.Permissions) {
+				return errRoleGrant
+			}
+
+			updateObj := bson.M{"updated_at": time.Now()}
+			if body.Description != nil {
+				updateObj["description"] = *body.Description
+			}
+			if body.Permissions != nil {
+				updateObj["permissions"] = body.Permissions
+			}
+
+			if err = store.Roles().Update(ctx, found.ID, updateObj); err != nil {
+				return err
+			}
+
+			role, err = store.Roles().FindByName(ctx, name)
+			return err
+		})
+		if err != nil {
+			roleErrorResponse(c, err, "Error occurred while updating role")
+			return
+		}
+
+		auditChange(c, models.AUDIT_ROLE_UPDATE, "role:"+name, found, role)
+		c.JSON(http.StatusOK, role)
+	}
+}
+
+func DeleteRole(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		var role *models.Role
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			role, err = store.Roles().FindByName(ctx, name)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errRoleNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			if role.BuiltIn {
+				return errRoleBuiltIn
+			}
+
+			if !holdsAll(c, role.Permissions) {
+				return errRoleGrant
+			}
+
+			holders, err := store.Users().ListPage(ctx, repository.UserFilter{Role: name}, repository.Page{Limit: 1})
+			if err != nil {
+				return err
+			}
+
+			if holders.Total > 0 {
+				return errRoleInUse
+			}
+
+			return store.Roles().Delete(ctx, role.ID)
+		})
+		if err != nil {
+			roleErrorResponse(c, err, "Error occurred while deleting role")
+			return
+		}
+
+		auditChange(c, models.AUDIT_ROLE_DELETE, "role:"+name, role, nil)
+		c.JSON(http.StatusOK, gin.H{"message": "role deleted successfully"})
+	}
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errSessionEnded        = errors.New("the session has expired or been revoked")
+	errRefreshTokenReused  = errors.New("refresh token was already used, the session has been revoked")
+)
+
+// TokenResponse is the user answered by login and refresh, with the access
+// token in Token and the refresh token of the session next to it.
+type TokenResponse struct {
+	*models.User
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // Seconds until the access token expires
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// startSession opens a new session for user and returns the tokens for it.
+func startSession(ctx context.Context, store repository.Store, c *gin.Context, user *models.User, now time.Time) (*TokenResponse, error) {
+	refreshToken, hash, err := helper.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	session := models.Session{
+		UserID:      user.ID,
+		RefreshHash: hash,
+		UserAgent:   c.Request.UserAgent(),
+		IP:          c.ClientIP(),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(helper.REFRESH_TOKEN_TTL),
+	}
+	if err := store.Sessions().Insert(ctx, &session); err != nil {
+		return nil, err
+	}
+
+	return tokenResponse(user, session.ID, refreshToken)
+}
+
+func tokenResponse(user *models.User, sessionId primitive.ObjectID, refreshToken string) (*TokenResponse, error) {
+	token, err := helper.GenerateUserToken(*user.Username, user.ID.Hex(), *user.Role, *user.IsActive, sessionId.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	user.Token = &token
+	return &TokenResponse{User: user, RefreshToken: refreshToken, ExpiresIn: int64(helper.ACCESS_TOKEN_TTL.Seconds())}, nil
+}
+
+// refreshSession trades a refresh token for a new one and a new access token.
+// Presenting a refresh token that was already rotated out means it leaked, so
+// the whole session is revoked.
+func refreshSession(ctx context.Context, store repository.Store, refreshToken string, now time.Time) (*TokenResponse, error) {
+	hash := helper.HashSecret(refreshToken)
+
+	session, err := store.Sessions().FindByRefreshHash(ctx, hash)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !session.Active(now) {
+		return nil, errSessionEnded
+	}
+
+	if session.RefreshHash != hash {
+		if err := store.Sessions().Revoke(ctx, session.ID, now); err != nil {
+			return nil, err
+		}
+		return nil, errRefreshTokenReused
+	}
+
+	user, err := store.Users().FindByID(ctx, session.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errSessionEnded
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	newToken, newHash, err := helper.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.Sessions().Rotate(ctx, session.ID, hash, newHash, now.Add(helper.REFRESH_TOKEN_TTL), now)
+	if errors.Is(err, repository.ErrNotFound) {
+		// another refresh with the same token got there first
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenResponse(user, session.ID, newToken)
+}
+
+func sessionErrorResponse(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, errInvalidRefreshToken), errors.Is(err, errSessionEnded), errors.Is(err, errRefreshTokenReused):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+	}
+}
+
+func bindRefreshToken(c *gin.Context) (string, bool) {
+	var body refreshRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+
+	if validationErr := userValidate.Struct(body); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return "", false
+	}
+
+	return body.RefreshToken, true
+}
+
+func UserRefresh(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		refreshToken, ok := bindRefreshToken(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		response, err := refreshSession(ctx, store, refreshToken, time.Now())
+		if err != nil {
+			sessionErrorResponse(c, err, "Error occurred while refreshing the session")
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func UserLogOut(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		refreshToken, ok := bindRefreshToken(c)
+		if !ok {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		session, err := store.Sessions().FindByRefreshHash(ctx, helper.HashSecret(refreshToken))
+		if errors.Is(err, repository.ErrNotFound) {
+			sessionErrorResponse(c, errInvalidRefreshToken, "")
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while logging out"})
+			return
+		}
+
+		if err := store.Sessions().Revoke(ctx, session.ID, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while logging out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+	}
+}
+
+// RevokeUserSessions logs the user out everywhere. Access tokens already
+// handed out stop working on their next request.
+func RevokeUserSessions(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := primitive.ObjectIDFromHex(c.Param("user_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user id"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		user, err := store.Users().FindByID(ctx, userId)
+		if err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+			}
+			return
+		}
+
+		if err := checkManageable(ctx, store, c, user); err != nil {
+			roleErrorResponse(c, err, "Error fetching user")
+			return
+		}
+
+		revoked, err := store.Sessions().RevokeByUser(ctx, userId, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while revoking sessions"})
+			return
+		}
+
+		entry := auditChange(c, models.AUDIT_SESSIONS_REVOKE, "user:"+userId.Hex(), nil, nil)
+		entry.Detail = fmt.Sprintf("%d sessions revoked", revoked)
+		c.JSON(http.StatusOK, gin.H{"message": "sessions revoked successfully", "revoked": revoked})
+	}
+}
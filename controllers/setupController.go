@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var errSetupDone = errors.New("an admin account already exists")
+
+// setupToken authorises creating the first admin. It is set once on start by
+// PrepareSetup and stays empty when there is nothing to set up.
+var setupToken string
+
+func hasAdmin(ctx context.Context, store repository.Store) (bool, error) {
+	admins, err := store.Users().ListPage(ctx, repository.UserFilter{Role: models.ROLE_ADMIN}, repository.Page{Limit: 1})
+	if err != nil {
+		return false, err
+	}
+
+	return admins.Total > 0, nil
+}
+
+// PrepareSetup opens POST /users/setup while no admin exists. The setup token
+// comes from SETUP_TOKEN, or is generated and written to the log so that only
+// whoever runs the server can claim the first admin account.
+func PrepareSetup(store repository.Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	done, err := hasAdmin(ctx, store)
+	if err != nil || done {
+		return err
+	}
+
+	token := os.Getenv("SETUP_TOKEN")
+	if token == "" {
+		token, _, err = helper.GenerateSecret()
+		if err != nil {
+			return err
+		}
+	}
+
+	setupToken = token
+	log.Printf("no admin account yet, create one with POST /users/setup and setup token %s", token)
+	return nil
+}
+
+// UserSetup creates the first admin account. It stops working as soon as an
+// admin exists.
+func UserSetup(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		var setup struct {
+			models.User
+			SetupToken string `json:"setup_token" validate:"required"`
+		}
+		if err := c.BindJSON(&setup); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := setup.User
+
+		if validationErr := userValidate.StructExcept(setup, "User.Role"); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if setupToken == "" || subtle.ConstantTimeCompare([]byte(setup.SetupToken), []byte(setupToken)) != 1 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid setup token"})
+			return
+		}
+
+		password := HashPassword(*user.Password)
+		user.Password = &password
+		user.ID = primitive.NewObjectID()
+
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			done, err := hasAdmin(ctx, store)
+			if err != nil {
+				return err
+			}
+
+			if done {
+				return errSetupDone
+			}
+
+			return signUp(ctx, store, &user, models.ROLE_ADMIN, models.STATUS_APPROVED, time.Now())
+		})
+		switch {
+		case err == nil:
+			c.JSON(http.StatusCreated, gin.H{"InsertedID": user.ID, "role": user.Role})
+		case errors.Is(err, errSetupDone):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, errUserExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while creating the admin"})
+		}
+	}
+}
@@ -1,152 +1,259 @@
-package controllers
-
-import (
-	"context"
-	"fmt"
-	"log"
-	"net/http"
-	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
-	"github.com/roh4nyh/iit_bombay/database"
-	helper "github.com/roh4nyh/iit_bombay/helpers"
-	"github.com/roh4nyh/iit_bombay/models"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
-)
-
-const (
-	UserCollectionName = "users"
-)
-
-var userValidate = validator.New()
-var UserCollection *mongo.Collection = database.OpenCollection(DatabaseName, UserCollectionName)
-
-func HashPassword(password string) string {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 15)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return string(bytes)
-}
-
-func VerifyPassword(userPassword, foundUserPassword string) (bool, string) {
-	err := bcrypt.CompareHashAndPassword([]byte(foundUserPassword), []byte(userPassword))
-	check := true
-	msg := ""
-
-	if err != nil {
-		msg = fmt.Sprintln("username or password is incorrect")
-		check = false
-	}
-
-	return check, msg
-}
-
-func UserSignUp() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
-
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		validationErr := userValidate.Struct(user)
-		if validationErr != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
-			return
-		}
-
-		if *user.Role != models.ROLE_LIBRARIAN && *user.Role != models.ROLE_MEMBER {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role provided. Must be either 'LIBRARIAN' or 'MEMBER'."})
-			return
-		}
-
-		count, err := UserCollection.CountDocuments(ctx, bson.M{"username": user.Username})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking for username"})
-			return
-		}
-
-		if count > 0 {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "this user already exists"})
-			return
-		}
-
-		password := HashPassword(*user.Password)
-		user.Password = &password
-
-		user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-		user.ID = primitive.NewObjectID()
-		user.UserID = user.ID.Hex()
-		*user.IsActive = false
-
-		token, _ := helper.GenerateUserToken(*user.Username, user.UserID, *user.Role, *user.IsActive)
-		user.Token = &token
-
-		resultInsertionNumber, insertErr := UserCollection.InsertOne(ctx, user)
-		if insertErr != nil {
-			msg := fmt.Sprintln("User item was not created")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-
-		c.JSON(http.StatusCreated, resultInsertionNumber)
-	}
-}
-
-func UserLogIn() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-		defer cancel()
-
-		var user models.User
-		var foundUser models.User
-
-		if err := c.BindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		err := UserCollection.FindOne(ctx, bson.M{"username": user.Username}).Decode(&foundUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "username or password is incorrect"})
-			return
-		}
-
-		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
-		if !passwordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			return
-		}
-
-		if foundUser.Username == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
-			return
-		}
-
-		token, err := helper.GenerateUserToken(*foundUser.Username, foundUser.ID.Hex(), *foundUser.Role, *foundUser.IsActive)
-		if err != nil || token == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		helper.UpdateUserToken(token, foundUser.UserID)
-
-		err = UserCollection.FindOne(ctx, bson.M{"_id": foundUser.ID}).Decode(&foundUser)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.JSON(http.StatusOK, foundUser)
-	}
-}
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var userValidate = validator.New()
+
+var (
+	errUserExists         = errors.New("this user already exists")
+	errInvitationRequired = errors.New("an invitation code is needed to sign up with a role other than MEMBER")
+	errInvalidInvitation  = errors.New("the invitation code is invalid, used or expired")
+)
+
+func HashPassword(password string) string {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 15)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return string(bytes)
+}
+
+// dummyPasswordHash is compared against when a login names no user with a
+// password. It has the cost of HashPassword and hashes a random secret that
+// was thrown away.
+const dummyPasswordHash = "$2a$15$SnBMwyYMh2K/Wegbnd2l5.bVwdem3IlMBy1TmNfUG2hZEyh.zMr.u"
+
+func VerifyPassword(userPassword, foundUserPassword string) (bool, string) {
+	err := bcrypt.CompareHashAndPassword([]byte(foundUserPassword), []byte(userPassword))
+	check := true
+	msg := ""
+
+	if err != nil {
+		msg = fmt.Sprintln("username or password is incorrect")
+		check = false
+	}
+
+	return check, msg
+}
+
+// verifyUserPassword checks password against the one of user, failing for
+// users who never set one because they log in through OIDC.
+func verifyUserPassword(user *models.User, password string) bool {
+	if user.Password == nil {
+		return false
+	}
+
+	ok, _ := VerifyPassword(password, *user.Password)
+	return ok
+}
+
+// signUp creates user with the role and account status decided by the
+// caller, refusing usernames that are taken. It must run inside a transaction.
+func signUp(ctx context.Context, store repository.Store, user *models.User, role, status string, now time.Time) error {
+	count, err := store.Users().CountByUsername(ctx, *user.Username)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return errUserExists
+	}
+
+	isActive := false
+	user.Role = &role
+	user.AccountStatus = status
+	user.IsActive = &isActive
+	user.CreatedAt, _ = time.Parse(time.RFC3339, now.Format(time.RFC3339))
+	user.UpdatedAt = user.CreatedAt
+	user.UserID = user.ID.Hex()
+
+	return store.Users().Insert(ctx, user)
+}
+
+// UserSignUp registers members, who wait for a librarian's approval before
+// they can log in. Staff accounts need an invitation code, which decides the
+// role and approves the account right away.
+func UserSignUp(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		var signup struct {
+			models.User
+			InvitationCode string `json:"invitation_code"`
+		}
+		if err := c.BindJSON(&signup); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user := signup.User
+
+		validationErr := userValidate.StructExcept(user, "Role")
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if signup.InvitationCode == "" && user.Role != nil && *user.Role != models.ROLE_MEMBER {
+			c.JSON(http.StatusForbidden, gin.H{"error": errInvitationRequired.Error()})
+			return
+		}
+
+		password := HashPassword(*user.Password)
+		user.Password = &password
+		user.ID = primitive.NewObjectID()
+
+		err := store.WithTransaction(ctx, func(ctx context.Context) error {
+			now := time.Now()
+
+			if signup.InvitationCode == "" {
+				return signUp(ctx, store, &user, models.ROLE_MEMBER, models.STATUS_PENDING, now)
+			}
+
+			invitation, err := store.Invitations().Redeem(ctx, helper.HashSecret(signup.InvitationCode), user.ID, now)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errInvalidInvitation
+			}
+			if err != nil {
+				return err
+			}
+
+			return signUp(ctx, store, &user, *invitation.Role, models.STATUS_APPROVED, now)
+		})
+		switch {
+		case err == nil:
+			c.JSON(http.StatusCreated, gin.H{"InsertedID": user.ID, "role": user.Role, "account_status": user.AccountStatus})
+		case errors.Is(err, errUserExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, errInvalidInvitation):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			msg := fmt.Sprintln("User item was not created")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		}
+	}
+}
+
+func UserLogIn(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		defer cancel()
+
+		var user models.User
+
+		if err := c.BindJSON(&user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if user.Username == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username and password are required"})
+			return
+		}
+
+		// guesses are slowed down and then locked out per username and address
+		now := time.Now()
+		userKey, ipKey := loginKeys(c, *user.Username)
+
+		attempts, until, err := reserveLogin(ctx, store, now, userKey, ipKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking login attempts"})
+			return
+		}
+
+		if until.After(now) {
+			tooManyLogins(c, until, now)
+			return
+		}
+
+		foundUser, err := store.Users().FindByUsername(ctx, *user.Username)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "username or password is incorrect"})
+			return
+		}
+
+		// users without a password take as long to turn down as the others,
+		// so the answer time does not tell which usernames exist
+		hash := dummyPasswordHash
+		if foundUser != nil && foundUser.Password != nil {
+			hash = *foundUser.Password
+		}
+
+		passwordIsValid, msg := VerifyPassword(*user.Password, hash)
+		if foundUser == nil || foundUser.Password == nil {
+			passwordIsValid, msg = false, "username or password is incorrect"
+		}
+
+		if !passwordIsValid {
+			if err := lockOutLogin(ctx, store, c, attempts, userKey, ipKey, now); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while recording login attempt"})
+				return
+			}
+
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		if err := forgiveLogin(ctx, store, attempts); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while recording login attempt"})
+			return
+		}
+
+		if foundUser.Username == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
+			return
+		}
+
+		switch foundUser.AccountStatus {
+		case models.STATUS_PENDING:
+			c.JSON(http.StatusForbidden, gin.H{"error": "your account is waiting for a librarian's approval"})
+			return
+		case models.STATUS_REJECTED:
+			c.JSON(http.StatusForbidden, gin.H{"error": "your signup was rejected"})
+			return
+		}
+
+		// the password alone is not enough for users with a second factor
+		if needsMFA(foundUser) {
+			challenge, err := startMFAChallenge(ctx, store, foundUser, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while starting MFA"})
+				return
+			}
+
+			c.JSON(http.StatusOK, challenge)
+			return
+		}
+
+		// only a complete login forgets earlier failures, with MFA that is the second step
+		if err := store.LoginAttempts().Clear(ctx, userKey); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while recording login attempt"})
+			return
+		}
+
+		response, err := startSession(ctx, store, c, foundUser, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -1,48 +1,62 @@
-package database
-
-import (
-	"context"
-	"fmt"
-	"log"
-	"os"
-	"time"
-
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-)
-
-func DBInstance() *mongo.Client {
-
-	// err := godotenv.Load(".env")
-	// if err != nil {
-	// 	log.Printf("error loading .env file: %v", err)
-	// }
-
-	MONGO_URI := os.Getenv("MONGO_URI")
-	if MONGO_URI == "" {
-		log.Fatal("MONGO_URI environment variable is not set")
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(MONGO_URI))
-	if err != nil {
-		log.Fatalf("error connecting to MongoDB: %v", err)
-	}
-
-	err = client.Ping(ctx, nil)
-	if err != nil {
-		log.Fatalf("error pinging MongoDB: %v", err)
-	}
-
-	fmt.Println("Connected to MongoDB!")
-	return client
-}
-
-func OpenCollection(databaseName, collectionName string) *mongo.Collection {
-	client := DBInstance()
-
-	collection := client.Database(databaseName).Collection(collectionName)
-	return collection
-}
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func DBInstance() *mongo.Client {
+
+	// err := godotenv.Load(".env")
+	// if err != nil {
+	// 	log.Printf("error loading .env file: %v", err)
+	// }
+
+	MONGO_URI := os.Getenv("MONGO_URI")
+	if MONGO_URI == "" {
+		log.Fatal("MONGO_URI environment variable is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(MONGO_URI))
+	if err != nil {
+		log.Fatalf("error connecting to MongoDB: %v", err)
+	}
+
+	err = client.Ping(ctx, nil)
+	if err != nil {
+		log.Fatalf("error pinging MongoDB: %v", err)
+	}
+
+	fmt.Println("Connected to MongoDB!")
+	return client
+}
+
+var (
+	clientOnce sync.Once
+	client     *mongo.Client
+)
+
+// Client returns the process wide MongoDB client, connecting on first use.
+// Every collection shares it so that a session can span several collections.
+func Client() *mongo.Client {
+	clientOnce.Do(func() {
+		client = DBInstance()
+	})
+
+	return client
+}
+
+func OpenCollection(databaseName, collectionName string) *mongo.Collection {
+	collection := Client().Database(databaseName).Collection(collectionName)
+	return collection
+}
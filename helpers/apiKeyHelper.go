@@ -0,0 +1,31 @@
+package helpers
+
+import (
+	"strings"
+	"time"
+)
+
+// API keys start with API_KEY_PREFIX, which tells them apart from access
+// tokens in the Authorization header.
+const API_KEY_PREFIX = "iitb_"
+
+// API_KEY_TOUCH_INTERVAL is how often the last use of an API key is written,
+// so that busy keys do not cost a write on every request.
+const API_KEY_TOUCH_INTERVAL = time.Minute
+
+// GenerateAPIKey returns a new API key, the prefix listed to identify it and
+// the hash under which it is stored.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	secret, _, err := GenerateSecret()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	key = API_KEY_PREFIX + secret
+	return key, key[:len(API_KEY_PREFIX)+8], HashSecret(key), nil
+}
+
+// IsAPIKey reports whether token is an API key rather than an access token.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, API_KEY_PREFIX)
+}
@@ -0,0 +1,119 @@
+package helpers
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const auditEntriesKey = "audit_entries"
+
+// auditRedacted are the fields never copied into an audit entry, even in
+// their hashed form.
+var auditRedacted = []string{"password", "token", "refresh_token", "code"}
+
+// NewAuditEntry starts an audit entry for the request in c. The logged in
+// user, if there is one, is recorded as the actor.
+func NewAuditEntry(c *gin.Context, action, target string) *models.AuditEntry {
+	entry := models.AuditEntry{
+		Action:    action,
+		ActorRole: c.GetString("role"),
+		Target:    target,
+		RequestID: c.GetString("request_id"),
+		IP:        c.ClientIP(),
+		Method:    c.Request.Method,
+		Route:     c.FullPath(),
+		CreatedAt: time.Now(),
+	}
+
+	if actorId, err := primitive.ObjectIDFromHex(c.GetString("uid")); err == nil {
+		entry.ActorID = &actorId
+	}
+
+	if keyId, err := primitive.ObjectIDFromHex(c.GetString("api_key_id")); err == nil {
+		entry.APIKeyID = &keyId
+	}
+
+	return &entry
+}
+
+// QueueAudit keeps entry until middleware.Audit writes it, once the handler
+// has answered.
+func QueueAudit(c *gin.Context, entry *models.AuditEntry) {
+	c.Set(auditEntriesKey, append(QueuedAudits(c), entry))
+}
+
+// QueuedAudits returns the entries queued for the request in c.
+func QueuedAudits(c *gin.Context) []*models.AuditEntry {
+	entries, _ := c.Get(auditEntriesKey)
+	queued, _ := entries.([]*models.AuditEntry)
+	return queued
+}
+
+// AuditDiff returns the fields of before and after, as they are shown in the
+// API, that differ between the two. A nil state stands for a document that
+// does not exist, so a creation keeps every field of after and a deletion
+// every field of before.
+func AuditDiff(before, after interface{}) (map[string]interface{}, map[string]interface{}) {
+	old, updated := auditSnapshot(before), auditSnapshot(after)
+	if old == nil || updated == nil {
+		return old, updated
+	}
+
+	for field, value := range old {
+		if reflect.DeepEqual(value, updated[field]) {
+			delete(old, field)
+			delete(updated, field)
+		}
+	}
+
+	for field, value := range updated {
+		if _, ok := old[field]; !ok && value == nil {
+			delete(updated, field)
+		}
+	}
+
+	return old, updated
+}
+
+// AuditUpdated returns before with the fields of set, the $set of an update,
+// applied. The fields updated are expected to be named alike in BSON and JSON.
+func AuditUpdated(before interface{}, set map[string]interface{}) map[string]interface{} {
+	after := auditSnapshot(before)
+	if after == nil {
+		after = map[string]interface{}{}
+	}
+
+	for field, value := range auditSnapshot(set) {
+		after[field] = value
+	}
+
+	return after
+}
+
+// auditSnapshot converts state to its JSON fields, leaving out the redacted ones.
+func auditSnapshot(state interface{}) map[string]interface{} {
+	if state == nil || reflect.ValueOf(state).Kind() == reflect.Pointer && reflect.ValueOf(state).IsNil() {
+		return nil
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+
+	for _, field := range auditRedacted {
+		delete(fields, field)
+	}
+
+	return fields
+}
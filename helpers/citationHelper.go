@@ -0,0 +1,377 @@
+package helpers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"golang.org/x/text/unicode/norm"
+)
+
+// citationNames returns the names of the contributors of a book with role.
+// The main author stands in for the authors when no contributor is one.
+func citationNames(book models.Book, role string) []string {
+	names := []string{}
+	for _, contributor := range book.Contributors {
+		if contributor.Name == nil {
+			continue
+		}
+		if contributor.Role == role || contributor.Role == "" && role == models.CONTRIBUTOR_AUTHOR {
+			names = append(names, *contributor.Name)
+		}
+	}
+
+	if len(names) == 0 && role == models.CONTRIBUTOR_AUTHOR && book.Author != nil {
+		names = append(names, *book.Author)
+	}
+
+	return names
+}
+
+// splitName splits a personal name into family and given names, for both
+// "Kernighan, Brian W." and "Brian W. Kernighan". A name of one word has only
+// a family name.
+func splitName(name string) (family, given string) {
+	if family, given, ok := strings.Cut(name, ","); ok {
+		return strings.TrimSpace(family), strings.TrimSpace(given)
+	}
+
+	name = strings.TrimSpace(name)
+	if i := strings.LastIndex(name, " "); i > 0 {
+		return name[i+1:], strings.TrimSpace(name[:i])
+	}
+
+	return name, ""
+}
+
+// citationKeys gives each book a key like "kernighan1988" to cite it by,
+// made unique within books with a letter after the year. Keys are ASCII for
+// plain BibTeX: accents are dropped, and books whose author is written in
+// another script are cited by ISBN.
+func citationKeys(books []models.Book) []string {
+	keys := make([]string, len(books))
+	counts := map[string]int{}
+	for i, book := range books {
+		key := ""
+		if authors := citationNames(book, models.CONTRIBUTOR_AUTHOR); len(authors) > 0 {
+			family, _ := splitName(authors[0])
+			key = strings.Map(func(r rune) rune {
+				r = unicode.ToLower(r)
+				if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+					return r
+				}
+				if unicode.IsLetter(r) {
+					return utf8.RuneError
+				}
+				return -1
+			}, norm.NFD.String(family))
+
+			if strings.ContainsRune(key, utf8.RuneError) {
+				key = ""
+			}
+		}
+
+		if key == "" {
+			key = "isbn" + deref(book.ISBN)
+		} else if book.PublicationYear != 0 {
+			key += strconv.Itoa(book.PublicationYear)
+		}
+
+		counts[key]++
+		keys[i] = key
+	}
+
+	// every book sharing a key gets a letter, in the order they come
+	seen := map[string]int{}
+	for i, key := range keys {
+		if counts[key] > 1 && seen[key] < 26 {
+			keys[i] = key + string(rune('a'+seen[key]))
+			seen[key]++
+		}
+	}
+
+	return keys
+}
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+// WriteBibTeX writes books as BibTeX @book entries. Fields only biblatex
+// knows, like translator and pagetotal, are ignored by plain BibTeX.
+func WriteBibTeX(w io.Writer, books []models.Book) error {
+	keys := citationKeys(books)
+	for i, book := range books {
+		var entry strings.Builder
+		field := func(name, value string) {
+			if value != "" {
+				fmt.Fprintf(&entry, "  %-10s = {%s},\n", name, bibtexEscaper.Replace(value))
+			}
+		}
+
+		fmt.Fprintf(&entry, "@book{%s,\n", keys[i])
+		field("author", strings.Join(citationNames(book, models.CONTRIBUTOR_AUTHOR), " and "))
+		field("editor", strings.Join(citationNames(book, models.CONTRIBUTOR_EDITOR), " and "))
+		field("translator", strings.Join(citationNames(book, models.CONTRIBUTOR_TRANSLATOR), " and "))
+		field("title", deref(book.Title))
+		field("edition", deref(book.Edition))
+		if book.Series != nil {
+			field("series", deref(book.Series.Title))
+			field("number", book.Series.Volume)
+		}
+		field("publisher", deref(book.Publisher))
+		if book.PublicationYear != 0 {
+			field("year", strconv.Itoa(book.PublicationYear))
+		}
+		field("isbn", deref(book.ISBN))
+		field("language", deref(book.Language))
+		if book.Pages != 0 {
+			field("pagetotal", strconv.Itoa(book.Pages))
+		}
+		field("keywords", strings.Join(book.Subjects, ", "))
+		field("abstract", deref(book.Description))
+		entry.WriteString("}\n\n")
+
+		if _, err := io.WriteString(w, entry.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteRIS writes books as RIS records of type BOOK.
+func WriteRIS(w io.Writer, books []models.Book) error {
+	for _, book := range books {
+		var record strings.Builder
+		tag := func(name string, values ...string) {
+			for _, value := range values {
+				// a tag holds a single line
+				if value = strings.Join(strings.Fields(value), " "); value != "" {
+					fmt.Fprintf(&record, "%s  - %s\r\n", name, value)
+				}
+			}
+		}
+
+		tag("TY", "BOOK")
+		tag("AU", citationNames(book, models.CONTRIBUTOR_AUTHOR)...)
+		tag("A2", citationNames(book, models.CONTRIBUTOR_EDITOR)...)
+		tag("A4", citationNames(book, models.CONTRIBUTOR_TRANSLATOR)...)
+		tag("TI", deref(book.Title))
+		tag("ET", deref(book.Edition))
+		if book.Series != nil {
+			tag("T2", deref(book.Series.Title))
+			tag("VL", book.Series.Volume)
+		}
+		tag("PB", deref(book.Publisher))
+		if book.PublicationYear != 0 {
+			tag("PY", strconv.Itoa(book.PublicationYear))
+		}
+		tag("SN", deref(book.ISBN))
+		tag("LA", deref(book.Language))
+		if book.Pages != 0 {
+			tag("SP", strconv.Itoa(book.Pages))
+		}
+		if book.CallNumber != nil {
+			tag("CN", book.CallNumber.Number)
+		}
+		tag("KW", book.Subjects...)
+		tag("AB", deref(book.Description))
+		record.WriteString("ER  - \r\n\r\n")
+
+		if _, err := io.WriteString(w, record.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cslName is a name in CSL-JSON, split into family and given names or kept
+// whole as a literal.
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+type cslItem struct {
+	ID               string    `json:"id"`
+	Type             string    `json:"type"`
+	Title            string    `json:"title,omitempty"`
+	Author           []cslName `json:"author,omitempty"`
+	Editor           []cslName `json:"editor,omitempty"`
+	Translator       []cslName `json:"translator,omitempty"`
+	Illustrator      []cslName `json:"illustrator,omitempty"`
+	Edition          string    `json:"edition,omitempty"`
+	CollectionTitle  string    `json:"collection-title,omitempty"`
+	CollectionNumber string    `json:"collection-number,omitempty"`
+	Publisher        string    `json:"publisher,omitempty"`
+	Issued           *cslDate  `json:"issued,omitempty"`
+	ISBN             string    `json:"ISBN,omitempty"`
+	Language         string    `json:"language,omitempty"`
+	NumberOfPages    string    `json:"number-of-pages,omitempty"`
+	CallNumber       string    `json:"call-number,omitempty"`
+	Keyword          string    `json:"keyword,omitempty"`
+	Abstract         string    `json:"abstract,omitempty"`
+}
+
+func cslNames(names []string) []cslName {
+	var csl []cslName
+	for _, name := range names {
+		family, given := splitName(name)
+		if given == "" {
+			csl = append(csl, cslName{Literal: family})
+			continue
+		}
+		csl = append(csl, cslName{Family: family, Given: given})
+	}
+
+	return csl
+}
+
+// WriteCSLJSON writes books as a CSL-JSON array, the input of citation
+// processors like citeproc and of reference managers like Zotero.
+func WriteCSLJSON(w io.Writer, books []models.Book) error {
+	keys := citationKeys(books)
+
+	items := make([]cslItem, len(books))
+	for i, book := range books {
+		item := cslItem{
+			ID:          keys[i],
+			Type:        "book",
+			Title:       deref(book.Title),
+			Author:      cslNames(citationNames(book, models.CONTRIBUTOR_AUTHOR)),
+			Editor:      cslNames(citationNames(book, models.CONTRIBUTOR_EDITOR)),
+			Translator:  cslNames(citationNames(book, models.CONTRIBUTOR_TRANSLATOR)),
+			Illustrator: cslNames(citationNames(book, models.CONTRIBUTOR_ILLUSTRATOR)),
+			Edition:     deref(book.Edition),
+			Publisher:   deref(book.Publisher),
+			ISBN:        deref(book.ISBN),
+			Language:    deref(book.Language),
+			Keyword:     strings.Join(book.Subjects, ", "),
+			Abstract:    deref(book.Description),
+		}
+
+		if book.Series != nil {
+			item.CollectionTitle, item.CollectionNumber = deref(book.Series.Title), book.Series.Volume
+		}
+		if book.PublicationYear != 0 {
+			item.Issued = &cslDate{DateParts: [][]int{{book.PublicationYear}}}
+		}
+		if book.Pages != 0 {
+			item.NumberOfPages = strconv.Itoa(book.Pages)
+		}
+		if book.CallNumber != nil {
+			item.CallNumber = book.CallNumber.Number
+		}
+
+		items[i] = item
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(items)
+}
+
+// dublinCore is a book in the simple Dublin Core of OAI-PMH (oai_dc).
+type dublinCore struct {
+	XMLName     xml.Name `xml:"oai_dc:dc"`
+	OAIDC       string   `xml:"xmlns:oai_dc,attr"`
+	DC          string   `xml:"xmlns:dc,attr"`
+	Title       []string `xml:"dc:title"`
+	Creator     []string `xml:"dc:creator"`
+	Contributor []string `xml:"dc:contributor"`
+	Subject     []string `xml:"dc:subject"`
+	Description []string `xml:"dc:description"`
+	Publisher   []string `xml:"dc:publisher"`
+	Date        []string `xml:"dc:date"`
+	Type        string   `xml:"dc:type"`
+	Format      []string `xml:"dc:format"`
+	Identifier  []string `xml:"dc:identifier"`
+	Language    []string `xml:"dc:language"`
+	Relation    []string `xml:"dc:relation"`
+}
+
+type dublinCoreCollection struct {
+	XMLName xml.Name     `xml:"collection"`
+	Records []dublinCore `xml:"oai_dc:dc"`
+}
+
+// WriteDublinCore writes books as a collection of oai_dc Dublin Core records.
+func WriteDublinCore(w io.Writer, books []models.Book) error {
+	optional := func(value string) []string {
+		if value == "" {
+			return nil
+		}
+		return []string{value}
+	}
+
+	collection := dublinCoreCollection{Records: make([]dublinCore, len(books))}
+	for i, book := range books {
+		record := dublinCore{
+			OAIDC:       "http://www.openarchives.org/OAI/2.0/oai_dc/",
+			DC:          "http://purl.org/dc/elements/1.1/",
+			Title:       optional(deref(book.Title)),
+			Creator:     citationNames(book, models.CONTRIBUTOR_AUTHOR),
+			Subject:     book.Subjects,
+			Description: optional(deref(book.Description)),
+			Publisher:   optional(deref(book.Publisher)),
+			Type:        "Text",
+			Identifier:  optional("urn:isbn:" + deref(book.ISBN)),
+			Language:    optional(deref(book.Language)),
+		}
+
+		for _, role := range []string{models.CONTRIBUTOR_EDITOR, models.CONTRIBUTOR_TRANSLATOR, models.CONTRIBUTOR_ILLUSTRATOR} {
+			record.Contributor = append(record.Contributor, citationNames(book, role)...)
+		}
+
+		if book.PublicationYear != 0 {
+			record.Date = []string{strconv.Itoa(book.PublicationYear)}
+		}
+		if book.Pages != 0 {
+			record.Format = []string{fmt.Sprintf("%d pages", book.Pages)}
+		}
+		if book.Series != nil && book.Series.Title != nil {
+			series := *book.Series.Title
+			if book.Series.Volume != "" {
+				series += " ; " + book.Series.Volume
+			}
+			record.Relation = []string{series}
+		}
+
+		collection.Records[i] = record
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(collection); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,309 @@
+package helpers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/roh4nyh/iit_bombay/models"
+)
+
+// Formats a catalog import can come in.
+const (
+	IMPORT_CSV     = "csv"
+	IMPORT_JSONL   = "jsonl"
+	IMPORT_MARC    = "marc" // binary MARC 21, in UTF-8
+	IMPORT_MARCXML = "marcxml"
+)
+
+// IMPORT_MAX_ROWS and IMPORT_MAX_BYTES cap the size of a single import.
+var (
+	IMPORT_MAX_ROWS  = envInt("IMPORT_MAX_ROWS", 10000)
+	IMPORT_MAX_BYTES = int64(envInt("IMPORT_MAX_MB", 20)) << 20
+)
+
+var (
+	ErrImportFormat   = errors.New("the import must be CSV, JSON Lines, MARC 21 or MARCXML, pass format=csv, jsonl, marc or marcxml")
+	ErrImportTooLarge = errors.New("the import has too many rows")
+)
+
+// ImportRow is one book read from an import. Row is its line in the file, or
+// the number of its record in a MARC file. Err tells why it could not be read.
+type ImportRow struct {
+	Row  int
+	Book models.Book
+	Err  error
+}
+
+// ImportFormat tells the format of an import from its content type, or from
+// the name of the uploaded file. It returns "" if neither gives it away.
+func ImportFormat(contentType, filename string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return IMPORT_CSV
+	case "application/jsonl", "application/x-ndjson", "application/x-jsonlines", "application/json-lines":
+		return IMPORT_JSONL
+	case "application/marc":
+		return IMPORT_MARC
+	case "application/marcxml+xml", "application/xml", "text/xml":
+		return IMPORT_MARCXML
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return IMPORT_CSV
+	case ".jsonl", ".ndjson":
+		return IMPORT_JSONL
+	case ".mrc", ".marc":
+		return IMPORT_MARC
+	case ".xml":
+		return IMPORT_MARCXML
+	}
+
+	return ""
+}
+
+// ReadBookImport reads the rows of an import. Rows that cannot be read are
+// returned with their error, an unreadable file fails as a whole.
+func ReadBookImport(r io.Reader, format string) ([]ImportRow, error) {
+	switch format {
+	case IMPORT_CSV:
+		return readCSVImport(r)
+	case IMPORT_JSONL:
+		return readJSONLImport(r)
+	case IMPORT_MARC:
+		return readMARCImport(r)
+	case IMPORT_MARCXML:
+		return readMARCXMLImport(r)
+	}
+
+	return nil, ErrImportFormat
+}
+
+// importColumns fill in the fields of a book from the columns of a CSV import.
+// Contributors and subjects are separated by semicolons, and a contributor's
+// role may follow the name in parentheses: "Jane Doe (EDITOR)".
+var importColumns = map[string]func(book *models.Book, value string) error{
+	"isbn":   func(book *models.Book, value string) error { book.ISBN = &value; return nil },
+	"title":  func(book *models.Book, value string) error { book.Title = &value; return nil },
+	"author": func(book *models.Book, value string) error { book.Author = &value; return nil },
+	"contributors": func(book *models.Book, value string) error {
+		for _, entry := range splitList(value) {
+			name, role := entry, ""
+			if open := strings.LastIndex(entry, " ("); open > 0 && strings.HasSuffix(entry, ")") {
+				name, role = strings.TrimSpace(entry[:open]), strings.ToUpper(entry[open+2:len(entry)-1])
+			}
+			book.Contributors = append(book.Contributors, models.Contributor{Name: &name, Role: role})
+		}
+		return nil
+	},
+	"publisher":        func(book *models.Book, value string) error { book.Publisher = &value; return nil },
+	"publication_year": func(book *models.Book, value string) error { return parseInt(&book.PublicationYear, value) },
+	"edition":          func(book *models.Book, value string) error { book.Edition = &value; return nil },
+	"language":         func(book *models.Book, value string) error { book.Language = &value; return nil },
+	"pages":            func(book *models.Book, value string) error { return parseInt(&book.Pages, value) },
+	"subjects":         func(book *models.Book, value string) error { book.Subjects = splitList(value); return nil },
+	"call_number_scheme": func(book *models.Book, value string) error {
+		if book.CallNumber == nil {
+			book.CallNumber = &models.CallNumber{}
+		}
+		book.CallNumber.Scheme = strings.ToUpper(value)
+		return nil
+	},
+	"call_number": func(book *models.Book, value string) error {
+		if book.CallNumber == nil {
+			book.CallNumber = &models.CallNumber{}
+		}
+		book.CallNumber.Number = value
+		return nil
+	},
+	"series": func(book *models.Book, value string) error {
+		if book.Series == nil {
+			book.Series = &models.Series{}
+		}
+		book.Series.Title = &value
+		return nil
+	},
+	"series_volume": func(book *models.Book, value string) error {
+		if book.Series == nil {
+			book.Series = &models.Series{}
+		}
+		book.Series.Volume = value
+		return nil
+	},
+	"description": func(book *models.Book, value string) error { book.Description = &value; return nil },
+	"cover_url":   func(book *models.Book, value string) error { book.CoverURL = &value; return nil },
+	"qty":         func(book *models.Book, value string) error { return parseInt(&book.Qty, value) },
+}
+
+// readCSVImport reads a CSV file whose header row names the columns, in any
+// order. isbn and title are required, empty cells are left out.
+func readCSVImport(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	for i, name := range header {
+		// spreadsheets like to start the file with a byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+		if _, ok := importColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown CSV column %q", header[i])
+		}
+		columns[i] = name
+	}
+
+	for _, required := range []string{"isbn", "title"} {
+		if !slices.Contains(columns, required) {
+			return nil, fmt.Errorf("the CSV file needs an %s column", required)
+		}
+	}
+
+	rows := []ImportRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return nil, err
+		}
+
+		if len(rows) == IMPORT_MAX_ROWS {
+			return nil, fmt.Errorf("%w, at most %d are allowed", ErrImportTooLarge, IMPORT_MAX_ROWS)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := ImportRow{Row: line, Err: err}
+		if parseErr != nil {
+			row.Row = parseErr.StartLine
+		}
+
+		if err == nil && len(record) != len(columns) {
+			row.Err = fmt.Errorf("the row has %d cells, the header %d", len(record), len(columns))
+		}
+
+		for i := 0; row.Err == nil && i < len(record); i++ {
+			if value := strings.TrimSpace(record[i]); value != "" {
+				if err := importColumns[columns[i]](&row.Book, value); err != nil {
+					row.Err = fmt.Errorf("%s: %w", columns[i], err)
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+// readJSONLImport reads one book per line, written like the body of POST
+// /librarian/books. Blank lines are skipped.
+func readJSONLImport(r io.Reader) ([]ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	rows := []ImportRow{}
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if len(rows) == IMPORT_MAX_ROWS {
+			return nil, fmt.Errorf("%w, at most %d are allowed", ErrImportTooLarge, IMPORT_MAX_ROWS)
+		}
+
+		row := ImportRow{Row: line}
+		row.Err = json.Unmarshal([]byte(text), &row.Book)
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+// readMARCImport reads the bibliographic records of a binary MARC 21 file,
+// see MARCBook for the fields that are read.
+func readMARCImport(r io.Reader) ([]ImportRow, error) {
+	records, errs, err := ReadMARC(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []ImportRow{}
+	for i, record := range records {
+		row := ImportRow{Row: i + 1, Err: errs[i]}
+		if row.Err == nil {
+			row.Book, row.Err = marcImportBook(record)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// readMARCXMLImport reads the bibliographic records of a MARCXML file.
+func readMARCXMLImport(r io.Reader) ([]ImportRow, error) {
+	records, err := ReadMARCXML(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []ImportRow{}
+	for i, record := range records {
+		row := ImportRow{Row: i + 1}
+		row.Book, row.Err = marcImportBook(record)
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// marcImportBook reads the book of a record, refusing records that do not
+// describe books, like authority or holdings records.
+func marcImportBook(record MARCRecord) (models.Book, error) {
+	if len(record.Leader) < 24 || !strings.ContainsRune("at", rune(record.Leader[6])) {
+		return models.Book{}, errors.New("the MARC record is not a bibliographic record of a book")
+	}
+
+	return MARCBook(record), nil
+}
+
+func splitList(value string) []string {
+	values := []string{}
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+
+	return values
+}
+
+func parseInt(into *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number", value)
+	}
+
+	*into = n
+	return nil
+}
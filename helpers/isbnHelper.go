@@ -0,0 +1,98 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidISBN = errors.New("isbn must be a valid ISBN-10 or ISBN-13")
+
+// NormalizeISBN checks an ISBN-10 or ISBN-13 and returns its canonical form,
+// the 13 digits of the ISBN-13 without hyphens or spaces. An "ISBN" label in
+// front is ignored, so "ISBN 0-13-110362-8" and "978-0-13-110362-7" both
+// give "9780131103627".
+func NormalizeISBN(raw string) (string, error) {
+	isbn := strings.ToUpper(strings.TrimSpace(raw))
+	for _, label := range []string{"ISBN-13:", "ISBN-10:", "ISBN-13", "ISBN-10", "ISBN:", "ISBN"} {
+		if strings.HasPrefix(isbn, label) {
+			isbn = isbn[len(label):]
+			break
+		}
+	}
+
+	isbn = strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, isbn)
+
+	switch len(isbn) {
+	case 10:
+		if !digits(isbn[:9]) || isbn10CheckDigit(isbn[:9]) != isbn[9] {
+			return "", ErrInvalidISBN
+		}
+
+		isbn13 := "978" + isbn[:9]
+		return isbn13 + string(isbn13CheckDigit(isbn13)), nil
+	case 13:
+		if !digits(isbn) || !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") || isbn13CheckDigit(isbn[:12]) != isbn[12] {
+			return "", ErrInvalidISBN
+		}
+
+		return isbn, nil
+	}
+
+	return "", ErrInvalidISBN
+}
+
+// ISBN10 returns the ISBN-10 of a canonical ISBN, or "" for the 979 range
+// that has none.
+func ISBN10(isbn13 string) string {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") {
+		return ""
+	}
+
+	return isbn13[3:12] + string(isbn10CheckDigit(isbn13[3:12]))
+}
+
+// isbn10CheckDigit computes the mod 11 check digit of the first nine digits
+// of an ISBN-10, X standing for 10.
+func isbn10CheckDigit(first9 string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(first9[i]-'0')
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+
+	return byte('0' + check)
+}
+
+// isbn13CheckDigit computes the EAN-13 check digit of the first twelve digits
+// of an ISBN-13.
+func isbn13CheckDigit(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(first12[i]-'0')
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return s != ""
+}
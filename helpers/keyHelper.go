@@ -0,0 +1,286 @@
+package helpers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+)
+
+const SIGNING_ALGORITHM = "RS256"
+
+// A new signing key is created every JWT_KEY_ROTATION_DAYS and published in
+// the JWKS JWT_KEY_PUBLISH_HOURS before it starts signing, so that services
+// caching the key set know it by the time its first token arrives.
+var (
+	KEY_ROTATION_PERIOD time.Duration = time.Duration(envInt("JWT_KEY_ROTATION_DAYS", 30)) * 24 * time.Hour
+	KEY_PUBLISH_AHEAD   time.Duration = time.Duration(envInt("JWT_KEY_PUBLISH_HOURS", 24)) * time.Hour
+)
+
+// SIGNING_KEY_SECRET encrypts the private signing keys kept in the database,
+// so that a copy of the database alone cannot mint tokens. Without it they
+// are kept as plain PEM, which is only fit for local use.
+var SIGNING_KEY_SECRET = os.Getenv("SIGNING_KEY_SECRET")
+
+// ENCRYPTED_KEY_TYPE is the PEM type of a private key sealed with
+// SIGNING_KEY_SECRET: the AES-GCM nonce followed by the sealed PKCS #8 key.
+const ENCRYPTED_KEY_TYPE = "ENCRYPTED SIGNING KEY"
+
+var errNoSigningKey = errors.New("no token signing key is loaded")
+
+// ringKey is a parsed SigningKey. retiresAt is when the next key took over
+// signing, zero while the key is the newest.
+type ringKey struct {
+	kid         string
+	private     *rsa.PrivateKey
+	public      *rsa.PublicKey
+	activatesAt time.Time
+	retiresAt   time.Time
+}
+
+// KEY_RING holds the keys that sign and verify access tokens. It is filled
+// from the signingKeys collection by LoadSigningKeys.
+var KEY_RING = &keyRing{}
+
+type keyRing struct {
+	mu   sync.RWMutex
+	keys []ringKey // in the order they activate
+}
+
+// LoadSigningKeys replaces the keys of KEY_RING. keys must be in the order
+// they activate.
+func LoadSigningKeys(keys []models.SigningKey) error {
+	parsed := make([]ringKey, 0, len(keys))
+
+	for i, key := range keys {
+		private, err := parsePrivateKey(key.PrivateKey, key.Kid)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", key.Kid, err)
+		}
+
+		ring := ringKey{kid: key.Kid, private: private, public: &private.PublicKey, activatesAt: key.ActivatesAt}
+		if i+1 < len(keys) {
+			ring.retiresAt = keys[i+1].ActivatesAt
+		}
+
+		parsed = append(parsed, ring)
+	}
+
+	KEY_RING.mu.Lock()
+	KEY_RING.keys = parsed
+	KEY_RING.mu.Unlock()
+
+	return nil
+}
+
+// signingKey returns the newest key that is active at now.
+func (r *keyRing) signingKey(now time.Time) (*ringKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		if !r.keys[i].activatesAt.After(now) {
+			return &r.keys[i], nil
+		}
+	}
+
+	return nil, errNoSigningKey
+}
+
+// verificationKey returns the public key named kid, unless every token it
+// signed has expired by now.
+func (r *keyRing) verificationKey(kid string, now time.Time) (*rsa.PublicKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.kid != kid {
+			continue
+		}
+
+		if KeyExpired(key.retiresAt, now) {
+			break
+		}
+
+		return key.public, nil
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// KeyExpired reports whether a key that stopped signing at retiresAt can be
+// dropped at now, because every token it signed has expired.
+func KeyExpired(retiresAt, now time.Time) bool {
+	return !retiresAt.IsZero() && now.After(retiresAt.Add(ACCESS_TOKEN_TTL+time.Minute))
+}
+
+// GenerateSigningKey creates a 2048 bit RSA key that starts signing at
+// activatesAt. Its kid is the RFC 7638 thumbprint of the public key.
+func GenerateSigningKey(now, activatesAt time.Time) (*models.SigningKey, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk := rsaJWK(&private.PublicKey)
+	thumbprint := sha256.Sum256([]byte(fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)))
+	kid := base64.RawURLEncoding.EncodeToString(thumbprint[:])
+
+	sealed, err := sealPrivateKey(private, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		Kid:         kid,
+		Algorithm:   SIGNING_ALGORITHM,
+		PrivateKey:  sealed,
+		PublicKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		CreatedAt:   now,
+		ActivatesAt: activatesAt,
+	}, nil
+}
+
+// signingKeyCipher derives the AES-256-GCM cipher of SIGNING_KEY_SECRET.
+func signingKeyCipher() (cipher.AEAD, error) {
+	secret := sha256.Sum256([]byte(SIGNING_KEY_SECRET))
+
+	block, err := aes.NewCipher(secret[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealPrivateKey PEM encodes private, encrypted with SIGNING_KEY_SECRET if it
+// is set. The kid is bound to the ciphertext, so a sealed key cannot be passed
+// off under another kid.
+func sealPrivateKey(private *rsa.PrivateKey, kid string) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+
+	if SIGNING_KEY_SECRET == "" {
+		log.Printf("SIGNING_KEY_SECRET is not set, storing signing key %s unencrypted", kid)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+	}
+
+	aead, err := signingKeyCipher()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, der, []byte(kid))
+	return string(pem.EncodeToMemory(&pem.Block{Type: ENCRYPTED_KEY_TYPE, Bytes: sealed})), nil
+}
+
+// parsePrivateKey reads a private key written by sealPrivateKey, encrypted or
+// not.
+func parsePrivateKey(encoded, kid string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("the private key is not PEM encoded")
+	}
+
+	der := block.Bytes
+	if block.Type == ENCRYPTED_KEY_TYPE {
+		if SIGNING_KEY_SECRET == "" {
+			return nil, errors.New("the private key is encrypted and SIGNING_KEY_SECRET is not set")
+		}
+
+		aead, err := signingKeyCipher()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(der) < aead.NonceSize() {
+			return nil, errors.New("the encrypted private key is too short")
+		}
+
+		nonce, sealed := der[:aead.NonceSize()], der[aead.NonceSize():]
+		if der, err = aead.Open(nil, nonce, sealed, []byte(kid)); err != nil {
+			return nil, errors.New("the private key does not decrypt with SIGNING_KEY_SECRET")
+		}
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the private key is not an RSA key")
+	}
+
+	return private, nil
+}
+
+// JSONWebKey is the public half of a signing key as RFC 7517 describes it.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func rsaJWK(public *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: SIGNING_ALGORITHM,
+		N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+	}
+}
+
+// JWKS returns the keys that verify tokens at now, including the next
+// signing key once it is published.
+func JWKS(now time.Time) JSONWebKeySet {
+	KEY_RING.mu.RLock()
+	defer KEY_RING.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range KEY_RING.keys {
+		if KeyExpired(key.retiresAt, now) {
+			continue
+		}
+
+		jwk := rsaJWK(key.public)
+		jwk.Kid = key.kid
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
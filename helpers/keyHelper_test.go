@@ -0,0 +1,39 @@
+package helpers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigningKeyIsSealedWithTheSecret(t *testing.T) {
+	defer func(secret string) { SIGNING_KEY_SECRET = secret }(SIGNING_KEY_SECRET)
+	SIGNING_KEY_SECRET = "a secret of the deployment"
+
+	now := time.Now()
+	key, err := GenerateSigningKey(now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(key.PrivateKey, ENCRYPTED_KEY_TYPE) {
+		t.Fatalf("private key stored as %.40q", key.PrivateKey)
+	}
+
+	if _, err := parsePrivateKey(key.PrivateKey, key.Kid); err != nil {
+		t.Errorf("parse with the secret: %v", err)
+	}
+	if _, err := parsePrivateKey(key.PrivateKey, "another-kid"); err == nil {
+		t.Errorf("parse under another kid succeeded")
+	}
+
+	SIGNING_KEY_SECRET = "another secret"
+	if _, err := parsePrivateKey(key.PrivateKey, key.Kid); err == nil {
+		t.Errorf("parse with another secret succeeded")
+	}
+
+	SIGNING_KEY_SECRET = ""
+	if _, err := parsePrivateKey(key.PrivateKey, key.Kid); err == nil {
+		t.Errorf("parse without a secret succeeded")
+	}
+}
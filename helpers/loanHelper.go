@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoanPolicy holds the circulation rules applied when a member borrows a book.
+// Fine amounts are in paise.
+type LoanPolicy struct {
+	LoanPeriod          time.Duration
+	FinePerDay          int64
+	MaxFine             int64 // cap on the fine charged for a single loan
+	MaxOutstandingFines int64 // members owing more than this cannot borrow
+	HoldPickupWindow    time.Duration
+	MaxRenewals         int
+}
+
+var LOAN_POLICY LoanPolicy = LoadLoanPolicy()
+
+// LoadLoanPolicy reads the loan policy from the environment, falling back to
+// a 14 day loan period and a fine of ₹5 per day capped at ₹200, with borrowing
+// blocked once a member owes more than ₹100. Copies set aside for a hold wait
+// 3 days for pickup, and a loan can be renewed twice.
+func LoadLoanPolicy() LoanPolicy {
+	return LoanPolicy{
+		LoanPeriod:          time.Duration(envInt("LOAN_PERIOD_DAYS", 14)) * 24 * time.Hour,
+		FinePerDay:          int64(envCount("FINE_PER_DAY", 500)),
+		MaxFine:             int64(envCount("FINE_CAP", 20000)),
+		MaxOutstandingFines: int64(envCount("MAX_OUTSTANDING_FINES", 10000)),
+		HoldPickupWindow:    time.Duration(envInt("HOLD_PICKUP_DAYS", 3)) * 24 * time.Hour,
+		MaxRenewals:         envCount("MAX_RENEWALS", 2),
+	}
+}
+
+// DueDate returns the date by which a book borrowed at borrowedAt must be returned.
+func (p LoanPolicy) DueDate(borrowedAt time.Time) time.Time {
+	return borrowedAt.Add(p.LoanPeriod)
+}
+
+// LateFine returns how many days late a book due at dueAt and returned at
+// returnedAt is, and the fine owed for it. Any part of a day counts as a full day.
+func (p LoanPolicy) LateFine(dueAt, returnedAt time.Time) (daysLate int, amount int64) {
+	if dueAt.IsZero() || !returnedAt.After(dueAt) {
+		return 0, 0
+	}
+
+	daysLate = int(math.Ceil(returnedAt.Sub(dueAt).Hours() / 24))
+	amount = min(int64(daysLate)*p.FinePerDay, p.MaxFine)
+
+	return daysLate, amount
+}
+
+// envInt reads a positive number from the environment.
+func envInt(key string, fallback int) int {
+	return envNumber(key, fallback, 1)
+}
+
+// envCount reads a number from the environment that may also be 0, for
+// settings where 0 turns something off, like MAX_RENEWALS or FINE_PER_DAY.
+func envCount(key string, fallback int) int {
+	return envNumber(key, fallback, 0)
+}
+
+func envNumber(key string, fallback, least int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < least {
+		log.Printf("invalid %s=%q, using default %d", key, value, fallback)
+		return fallback
+	}
+
+	return n
+}
+
+func envString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
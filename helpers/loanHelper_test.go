@@ -0,0 +1,21 @@
+package helpers
+
+import "testing"
+
+func TestLoadLoanPolicyAllowsZero(t *testing.T) {
+	t.Setenv("MAX_OUTSTANDING_FINES", "0")
+	t.Setenv("MAX_RENEWALS", "0")
+	t.Setenv("LOAN_PERIOD_DAYS", "0")
+
+	policy := LoadLoanPolicy()
+	if policy.MaxOutstandingFines != 0 {
+		t.Errorf("MaxOutstandingFines = %d, want 0", policy.MaxOutstandingFines)
+	}
+	if policy.MaxRenewals != 0 {
+		t.Errorf("MaxRenewals = %d, want 0", policy.MaxRenewals)
+	}
+	// a loan period of 0 has no meaning, so it keeps the default
+	if days := policy.LoanPeriod.Hours() / 24; days != 14 {
+		t.Errorf("LoanPeriod = %v days, want 14", days)
+	}
+}
@@ -0,0 +1,60 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+)
+
+// LoginPolicy limits how fast passwords can be guessed. Every failed login
+// makes the next attempt for the same username or client address wait twice
+// as long, and too many failures within Window lock the key for Lockout.
+type LoginPolicy struct {
+	MaxFailures   int // per username
+	MaxIPFailures int // per client address, which many users may share
+	Window        time.Duration
+	Lockout       time.Duration
+	MaxDelay      time.Duration
+}
+
+var LOGIN_POLICY LoginPolicy = LoadLoginPolicy()
+
+// LoadLoginPolicy reads the login policy from the environment, falling back
+// to locking a username for 15 minutes after 5 failures and a client address
+// after 20, counting failures no more than 15 minutes apart. Delays between
+// attempts stop growing at 30 seconds.
+func LoadLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		MaxFailures:   envInt("LOGIN_MAX_FAILURES", 5),
+		MaxIPFailures: envInt("LOGIN_MAX_IP_FAILURES", 20),
+		Window:        time.Duration(envInt("LOGIN_FAILURE_WINDOW_MINUTES", 15)) * time.Minute,
+		Lockout:       time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+		MaxDelay:      time.Duration(envCount("LOGIN_MAX_DELAY_SECONDS", 30)) * time.Second,
+	}
+}
+
+// Delay returns how long to wait after the given number of failed logins:
+// a second after the first, doubling with each one up to MaxDelay.
+func (p LoginPolicy) Delay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+
+	delay := time.Second << min(failures-1, 30)
+	return min(delay, p.MaxDelay)
+}
+
+// BlockedUntil returns when the key of attempt may try to log in again, which
+// is in the past if it may already.
+func (p LoginPolicy) BlockedUntil(attempt *models.LoginAttempt) time.Time {
+	if attempt == nil {
+		return time.Time{}
+	}
+
+	until := attempt.LastFailureAt.Add(p.Delay(attempt.Failures))
+	if attempt.LockedUntil != nil && attempt.LockedUntil.After(until) {
+		return *attempt.LockedUntil
+	}
+
+	return until
+}
@@ -0,0 +1,660 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/roh4nyh/iit_bombay/models"
+)
+
+// Separators of a binary MARC 21 (ISO 2709) record.
+const (
+	marcSubfieldDelimiter = 0x1F
+	marcFieldTerminator   = 0x1E
+	marcRecordTerminator  = 0x1D
+)
+
+// Limits of the binary format, from the widths of the leader and directory.
+const (
+	marcMaxFieldLength  = 9999
+	marcMaxRecordLength = 99999
+)
+
+var ErrMARCEncoding = errors.New("only Unicode (UTF-8) MARC records are supported, MARC-8 records must be converted first")
+
+// MARCRecord is a MARC 21 record, laid out like MARCXML so the same value is
+// read and written in both formats.
+type MARCRecord struct {
+	XMLName       xml.Name           `xml:"record"`
+	Leader        string             `xml:"leader"`
+	ControlFields []MARCControlField `xml:"controlfield"`
+	DataFields    []MARCDataField    `xml:"datafield"`
+}
+
+type MARCControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type MARCDataField struct {
+	Tag       string         `xml:"tag,attr"`
+	Ind1      string         `xml:"ind1,attr"`
+	Ind2      string         `xml:"ind2,attr"`
+	Subfields []MARCSubfield `xml:"subfield"`
+}
+
+type MARCSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcCollection struct {
+	XMLName xml.Name     `xml:"http://www.loc.gov/MARC21/slim collection"`
+	Records []MARCRecord `xml:"record"`
+}
+
+// control returns the value of the first control field with tag.
+func (r *MARCRecord) control(tag string) string {
+	for _, field := range r.ControlFields {
+		if field.Tag == tag {
+			return field.Value
+		}
+	}
+
+	return ""
+}
+
+// fields returns the data fields with any of the tags, in record order.
+func (r *MARCRecord) fields(tags ...string) []MARCDataField {
+	var found []MARCDataField
+	for _, field := range r.DataFields {
+		if slices.Contains(tags, field.Tag) {
+			found = append(found, field)
+		}
+	}
+
+	return found
+}
+
+// first returns the first subfield with code, without ISBD punctuation.
+func (f MARCDataField) first(code string) string {
+	for _, subfield := range f.Subfields {
+		if subfield.Code == code {
+			return trimISBD(subfield.Value)
+		}
+	}
+
+	return ""
+}
+
+// all returns the subfields with any of the codes, without ISBD punctuation.
+func (f MARCDataField) all(codes string) []string {
+	var values []string
+	for _, subfield := range f.Subfields {
+		if strings.Contains(codes, subfield.Code) {
+			if value := trimISBD(subfield.Value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return values
+}
+
+func (r *MARCRecord) addControl(tag, value string) {
+	r.ControlFields = append(r.ControlFields, MARCControlField{Tag: tag, Value: value})
+}
+
+// addData adds a data field of subfield code and value pairs, leaving out
+// empty values. Nothing is added if all are empty.
+func (r *MARCRecord) addData(tag, ind1, ind2 string, pairs ...string) {
+	field := MARCDataField{Tag: tag, Ind1: ind1, Ind2: ind2}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			field.Subfields = append(field.Subfields, MARCSubfield{Code: pairs[i], Value: pairs[i+1]})
+		}
+	}
+
+	if len(field.Subfields) > 0 {
+		r.DataFields = append(r.DataFields, field)
+	}
+}
+
+// trimISBD drops the punctuation cataloguers end MARC subfields with, as in
+// "The Go programming language /" or "Addison-Wesley,".
+func trimISBD(value string) string {
+	value = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(value), "/:;,="))
+
+	// a final period goes, unless it ends an abbreviation like "ed." or "Inc."
+	if strings.HasSuffix(value, ".") {
+		word := value[strings.LastIndex(value, " ")+1:]
+		if len(word) > 5 || digits(strings.TrimSuffix(word, ".")) {
+			value = strings.TrimSpace(strings.TrimRight(value[:len(value)-1], "/:;,="))
+		}
+	}
+
+	return value
+}
+
+// ReadMARC reads the records of a binary MARC 21 file. A record that cannot
+// be parsed is returned with its error, a record past IMPORT_MAX_ROWS fails
+// the file.
+func ReadMARC(r io.Reader) ([]MARCRecord, []error, error) {
+	reader := bufio.NewReader(r)
+
+	var records []MARCRecord
+	var errs []error
+	for {
+		raw, err := reader.ReadBytes(marcRecordTerminator)
+		if err != nil && err != io.EOF {
+			return nil, nil, err
+		}
+
+		// records are often separated by line breaks
+		if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 {
+			if len(records) == IMPORT_MAX_ROWS {
+				return nil, nil, fmt.Errorf("%w, at most %d are allowed", ErrImportTooLarge, IMPORT_MAX_ROWS)
+			}
+
+			record, decodeErr := decodeMARC(trimmed)
+			records, errs = append(records, record), append(errs, decodeErr)
+		}
+
+		if err == io.EOF {
+			return records, errs, nil
+		}
+	}
+}
+
+// decodeMARC parses one ISO 2709 record: a 24 byte leader, a directory of
+// 12 byte entries giving the tag, length and offset of each field, and the
+// fields.
+func decodeMARC(raw []byte) (MARCRecord, error) {
+	if raw[len(raw)-1] == marcRecordTerminator {
+		raw = raw[:len(raw)-1]
+	}
+
+	if len(raw) < 25 {
+		return MARCRecord{}, errors.New("the MARC record is truncated")
+	}
+
+	if !utf8.Valid(raw) {
+		return MARCRecord{}, ErrMARCEncoding
+	}
+
+	record := MARCRecord{Leader: string(raw[:24])}
+
+	// numbers are plain digits, Atoi would also take a sign
+	baseField := strings.TrimSpace(record.Leader[12:17])
+	base, err := strconv.Atoi(baseField)
+	if err != nil || !digits(baseField) || base < 25 || base > len(raw) || raw[base-1] != marcFieldTerminator {
+		return record, errors.New("the MARC record has an invalid base address of data")
+	}
+
+	directory := raw[24 : base-1]
+	if len(directory)%12 != 0 {
+		return record, errors.New("the MARC record has an invalid directory")
+	}
+
+	for i := 0; i < len(directory); i += 12 {
+		entry := string(directory[i : i+12])
+		tag := entry[:3]
+
+		length, lengthErr := strconv.Atoi(entry[3:7])
+		start, startErr := strconv.Atoi(entry[7:12])
+		if lengthErr != nil || startErr != nil || !digits(entry[3:12]) || length < 1 || start < 0 || base+start+length > len(raw) {
+			return record, fmt.Errorf("the MARC record has an invalid directory entry for field %s", tag)
+		}
+
+		data := string(bytes.TrimSuffix(raw[base+start:base+start+length], []byte{marcFieldTerminator}))
+
+		if strings.HasPrefix(tag, "00") {
+			record.addControl(tag, data)
+			continue
+		}
+
+		chunks := strings.Split(data, string(rune(marcSubfieldDelimiter)))
+		indicators := chunks[0] + "  "
+
+		field := MARCDataField{Tag: tag, Ind1: indicators[:1], Ind2: indicators[1:2]}
+		for _, chunk := range chunks[1:] {
+			if chunk != "" {
+				field.Subfields = append(field.Subfields, MARCSubfield{Code: chunk[:1], Value: chunk[1:]})
+			}
+		}
+
+		record.DataFields = append(record.DataFields, field)
+	}
+
+	return record, nil
+}
+
+// ReadMARCXML reads the records of a MARCXML file, a collection of records or
+// a single record.
+func ReadMARCXML(r io.Reader) ([]MARCRecord, error) {
+	decoder := xml.NewDecoder(r)
+
+	var records []MARCRecord
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("the MARCXML file is not well-formed: %w", err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "record" {
+			continue
+		}
+
+		if len(records) == IMPORT_MAX_ROWS {
+			return nil, fmt.Errorf("%w, at most %d are allowed", ErrImportTooLarge, IMPORT_MAX_ROWS)
+		}
+
+		var record MARCRecord
+		if err := decoder.DecodeElement(&record, &start); err != nil {
+			return nil, fmt.Errorf("the MARCXML file is not well-formed: %w", err)
+		}
+
+		records = append(records, record)
+	}
+}
+
+// WriteMARC writes books as binary MARC 21 records.
+func WriteMARC(w io.Writer, books []models.Book) error {
+	for _, book := range books {
+		raw, err := encodeMARC(BookMARC(book))
+		if err != nil {
+			return fmt.Errorf("book %s: %w", deref(book.ISBN), err)
+		}
+
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteMARCXML writes books as a MARCXML collection.
+func WriteMARCXML(w io.Writer, books []models.Book) error {
+	collection := marcCollection{Records: make([]MARCRecord, len(books))}
+	for i, book := range books {
+		collection.Records[i] = BookMARC(book)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(collection); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// encodeMARC lays out a record in ISO 2709, filling in the record length and
+// base address of the leader.
+func encodeMARC(record MARCRecord) ([]byte, error) {
+	var directory, data bytes.Buffer
+
+	add := func(tag string, field []byte) error {
+		field = append(field, marcFieldTerminator)
+		if len(field) > marcMaxFieldLength {
+			return fmt.Errorf("field %s is longer than MARC allows", tag)
+		}
+
+		fmt.Fprintf(&directory, "%3s%04d%05d", tag, len(field), data.Len())
+		data.Write(field)
+		return nil
+	}
+
+	for _, field := range record.ControlFields {
+		if err := add(field.Tag, []byte(field.Value)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, field := range record.DataFields {
+		raw := []byte((field.Ind1 + " ")[:1] + (field.Ind2 + " ")[:1])
+		for _, subfield := range field.Subfields {
+			raw = append(raw, marcSubfieldDelimiter)
+			raw = append(raw, subfield.Code...)
+			raw = append(raw, subfield.Value...)
+		}
+
+		if err := add(field.Tag, raw); err != nil {
+			return nil, err
+		}
+	}
+	directory.WriteByte(marcFieldTerminator)
+
+	base := 24 + directory.Len()
+	length := base + data.Len() + 1
+	if length > marcMaxRecordLength {
+		return nil, errors.New("the record is longer than MARC allows")
+	}
+
+	leader := []byte(fmt.Sprintf("%-24s", record.Leader))[:24]
+	copy(leader[0:5], fmt.Sprintf("%05d", length))
+	copy(leader[10:12], "22")
+	copy(leader[12:17], fmt.Sprintf("%05d", base))
+	copy(leader[20:24], "4500")
+
+	raw := make([]byte, 0, length)
+	raw = append(raw, leader...)
+	raw = append(raw, directory.Bytes()...)
+	raw = append(raw, data.Bytes()...)
+	return append(raw, marcRecordTerminator), nil
+}
+
+// marcRelators are the relator terms and codes of the contributor roles, in
+// subfields $e and $4 of the name fields.
+var marcRelators = map[string][2]string{
+	models.CONTRIBUTOR_AUTHOR:      {"author", "aut"},
+	models.CONTRIBUTOR_EDITOR:      {"editor", "edt"},
+	models.CONTRIBUTOR_TRANSLATOR:  {"translator", "trl"},
+	models.CONTRIBUTOR_ILLUSTRATOR: {"illustrator", "ill"},
+}
+
+// marcLanguages are the MARC language codes of the languages most often
+// catalogued, by their two letter code. Others are exported as "und".
+var marcLanguages = map[string]string{
+	"ar": "ara", "as": "asm", "bn": "ben", "de": "ger", "el": "gre",
+	"en": "eng", "es": "spa", "fa": "per", "fr": "fre", "gu": "guj",
+	"hi": "hin", "it": "ita", "ja": "jpn", "kn": "kan", "ko": "kor",
+	"la": "lat", "ml": "mal", "mr": "mar", "ne": "nep", "nl": "dut",
+	"or": "ori", "pa": "pan", "pt": "por", "ru": "rus", "sa": "san",
+	"ta": "tam", "te": "tel", "ur": "urd", "zh": "chi",
+}
+
+// BookMARC describes a book as a MARC 21 bibliographic record.
+func BookMARC(book models.Book) MARCRecord {
+	record := MARCRecord{Leader: "     nam a22     7i 4500"}
+
+	record.addControl("001", book.ID.Hex())
+	record.addControl("005", book.UpdatedAt.UTC().Format("20060102150405.0"))
+
+	date, year := "n", "uuuu"
+	if book.PublicationYear != 0 {
+		date, year = "s", fmt.Sprintf("%04d", book.PublicationYear)
+	}
+
+	language := "und"
+	if book.Language != nil {
+		tag := strings.ToLower(strings.SplitN(*book.Language, "-", 2)[0])
+		if code, ok := marcLanguages[tag]; ok {
+			language = code
+		} else if len(tag) == 3 {
+			language = tag
+		}
+	}
+
+	// 008: date entered, publication date, place, the book specific positions
+	// and the language
+	record.addControl("008", book.CreatedAt.UTC().Format("060102")+date+year+"    xx "+"           000 0 "+language+" d")
+
+	if book.ISBN != nil {
+		record.addData("020", " ", " ", "a", *book.ISBN)
+	}
+	if book.ISBN10 != nil {
+		record.addData("020", " ", " ", "a", *book.ISBN10)
+	}
+
+	if book.CallNumber != nil {
+		switch book.CallNumber.Scheme {
+		case models.CALL_NUMBER_LCC:
+			record.addData("050", " ", "4", "a", book.CallNumber.Number)
+		case models.CALL_NUMBER_DDC:
+			record.addData("082", "0", "4", "a", book.CallNumber.Number)
+		}
+	}
+
+	// the main author is the 100 field, every other contributor a 700
+	author := deref(book.Author)
+	others := []models.Contributor{}
+	for _, contributor := range book.Contributors {
+		if contributor.Name == nil {
+			continue
+		}
+		if author != "" && *contributor.Name == author && contributor.Role == models.CONTRIBUTOR_AUTHOR {
+			author = ""
+			continue
+		}
+		others = append(others, contributor)
+	}
+
+	titleIndicator := "0"
+	if book.Author != nil {
+		titleIndicator = "1"
+		record.addData("100", "1", " ", "a", *book.Author, "e", "author", "4", "aut")
+	}
+
+	record.addData("245", titleIndicator, "0", "a", deref(book.Title))
+
+	if book.Edition != nil {
+		record.addData("250", " ", " ", "a", *book.Edition)
+	}
+
+	if book.Publisher != nil || book.PublicationYear != 0 {
+		published := ""
+		if book.PublicationYear != 0 {
+			published = strconv.Itoa(book.PublicationYear)
+		}
+		record.addData("264", " ", "1", "b", deref(book.Publisher), "c", published)
+	}
+
+	if book.Pages != 0 {
+		record.addData("300", " ", " ", "a", fmt.Sprintf("%d pages", book.Pages))
+	}
+
+	if book.Series != nil {
+		record.addData("490", "0", " ", "a", deref(book.Series.Title), "v", book.Series.Volume)
+	}
+
+	if book.Description != nil {
+		record.addData("520", " ", " ", "a", truncateUTF8(*book.Description, marcMaxFieldLength-100))
+	}
+
+	for _, subject := range book.Subjects {
+		record.addData("650", " ", "4", "a", subject)
+	}
+
+	for _, contributor := range others {
+		role := contributor.Role
+		if role == "" {
+			role = models.CONTRIBUTOR_AUTHOR
+		}
+		relator := marcRelators[role]
+		record.addData("700", "1", " ", "a", *contributor.Name, "e", relator[0], "4", relator[1])
+	}
+
+	if book.CoverURL != nil {
+		record.addData("856", "4", "2", "3", "Cover image", "u", *book.CoverURL)
+	}
+
+	return record
+}
+
+var (
+	marcYear  = regexp.MustCompile(`\b[12]\d{3}\b`)
+	marcPages = regexp.MustCompile(`(\d+)\s*(?:p\b|p\.|pages?\b)`)
+)
+
+// MARCBook reads the details of a book from a MARC 21 bibliographic record.
+// What the record does not have is left out, for the import to validate.
+func MARCBook(record MARCRecord) models.Book {
+	var book models.Book
+	set := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+
+	// the first ISBN is the book's, qualifiers like "(pbk.)" follow it
+	for _, field := range record.fields("020") {
+		if parts := strings.Fields(field.first("a")); len(parts) > 0 {
+			book.ISBN = &parts[0]
+			break
+		}
+	}
+
+	for _, field := range record.fields("245") {
+		book.Title = set(strings.Join(field.all("ab"), ": "))
+	}
+
+	for _, field := range record.fields("100", "700") {
+		name := field.first("a")
+		if name == "" {
+			continue
+		}
+
+		role, ok := marcRole(field)
+		if !ok {
+			continue
+		}
+
+		book.Contributors = append(book.Contributors, models.Contributor{Name: &name, Role: role})
+		if field.Tag == "100" {
+			book.Author = set(name)
+		}
+	}
+
+	for _, field := range record.fields("250") {
+		book.Edition = set(field.first("a"))
+	}
+
+	// 264 with the publication indicator wins over 260
+	for _, field := range record.fields("260", "264") {
+		if field.Tag == "264" && field.Ind2 != "1" {
+			continue
+		}
+		if publisher := field.first("b"); publisher != "" {
+			book.Publisher = &publisher
+		}
+		if year := marcYear.FindString(field.first("c")); year != "" {
+			book.PublicationYear, _ = strconv.Atoi(year)
+		}
+	}
+
+	fixed := record.control("008")
+	if book.PublicationYear == 0 && len(fixed) >= 11 && digits(fixed[7:11]) {
+		book.PublicationYear, _ = strconv.Atoi(fixed[7:11])
+	}
+
+	if len(fixed) >= 38 {
+		code := strings.ToLower(fixed[35:38])
+		for tag, marc := range marcLanguages {
+			if marc == code {
+				book.Language = set(tag)
+			}
+		}
+	}
+
+	for _, field := range record.fields("300") {
+		// "xiv, 380 p." or "380 pages", not "1 v. (unpaged)"
+		if pages := marcPages.FindStringSubmatch(field.first("a")); pages != nil {
+			book.Pages, _ = strconv.Atoi(pages[1])
+		}
+	}
+
+	// DDC is preferred, the segmentation marks in it are dropped
+	for _, field := range record.fields("082") {
+		if number := strings.ReplaceAll(field.first("a"), "/", ""); number != "" && book.CallNumber == nil {
+			book.CallNumber = &models.CallNumber{Scheme: models.CALL_NUMBER_DDC, Number: number}
+		}
+	}
+	for _, field := range record.fields("050") {
+		if number := strings.Join(field.all("ab"), " "); number != "" && book.CallNumber == nil {
+			book.CallNumber = &models.CallNumber{Scheme: models.CALL_NUMBER_LCC, Number: number}
+		}
+	}
+
+	for _, field := range record.fields("490", "830") {
+		if title := field.first("a"); title != "" && book.Series == nil {
+			book.Series = &models.Series{Title: &title, Volume: field.first("v")}
+		}
+	}
+
+	// the summary is prose, its punctuation stays
+	for _, field := range record.fields("520") {
+		for _, subfield := range field.Subfields {
+			if subfield.Code == "a" && book.Description == nil {
+				book.Description = set(strings.TrimSpace(subfield.Value))
+			}
+		}
+	}
+
+	for _, field := range record.fields("650", "651", "655") {
+		subject := strings.Join(field.all("axyz"), " -- ")
+		if subject != "" && !slices.Contains(book.Subjects, subject) {
+			book.Subjects = append(book.Subjects, subject)
+		}
+	}
+
+	for _, field := range record.fields("856") {
+		if strings.Contains(strings.ToLower(field.first("3")), "cover") {
+			book.CoverURL = set(field.first("u"))
+		}
+	}
+
+	return book
+}
+
+// marcRole tells the contributor role of a name field from its relator term
+// or code. A name without either is an author, ok is false for relators with
+// no role of their own, like a writer of an introduction.
+func marcRole(field MARCDataField) (role string, ok bool) {
+	relators := append(field.all("4"), field.all("e")...)
+	if len(relators) == 0 {
+		return models.CONTRIBUTOR_AUTHOR, true
+	}
+
+	for _, relator := range relators {
+		relator = strings.ToLower(strings.TrimSuffix(relator, "."))
+		for role, names := range marcRelators {
+			if relator == names[0] || relator == names[1] {
+				return role, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n]
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"testing"
+)
+
+func TestDecodeMARCRejectsMalformedNumbers(t *testing.T) {
+	var record MARCRecord
+	record.Leader = "00000nam a2200000 a 4500"
+	record.addControl("001", "b1")
+	record.addData("245", "1", "0", "a", "A title")
+
+	raw, err := encodeMARC(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeMARC(raw); err != nil {
+		t.Fatalf("decode the record as written: %v", err)
+	}
+
+	// the first directory entry starts after the leader: tag, length, start
+	for _, test := range []struct {
+		name   string
+		at     int
+		number string
+	}{
+		{"negative start", 31, "-9999"},
+		{"signed start", 31, "+0000"},
+		{"signed length", 27, "+003"},
+		{"negative base address", 12, "-0030"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			corrupt := append([]byte(nil), raw...)
+			copy(corrupt[test.at:], test.number)
+
+			if _, err := decodeMARC(corrupt); err == nil {
+				t.Errorf("decoded a record with %q at %d", test.number, test.at)
+			}
+		})
+	}
+}
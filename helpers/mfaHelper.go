@@ -0,0 +1,131 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// TOTP codes follow RFC 6238 with the parameters every authenticator app
+// supports: HMAC-SHA1, 6 digits and a 30 second step.
+const (
+	TOTP_DIGITS = 6
+	TOTP_PERIOD = 30 * time.Second
+)
+
+// MFA_REQUIRED_ROLES lists the roles that have to log in with a second
+// factor, e.g. MFA_REQUIRED_ROLES=LIBRARIAN,ADMIN. Others may enroll if they
+// want to.
+var MFA_REQUIRED_ROLES []string = envList("MFA_REQUIRED_ROLES")
+
+var MFA_ISSUER string = envString("MFA_ISSUER", "IIT Bombay Library")
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFARequired reports whether users of role must use a second factor.
+func MFARequired(role string) bool {
+	return slices.Contains(MFA_REQUIRED_ROLES, role)
+}
+
+// GenerateTOTPSecret returns a random 160 bit secret, base32 encoded as
+// authenticator apps expect it.
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI that authenticator apps
+// read, usually from a QR code, to add the account.
+func TOTPProvisioningURI(account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", MFA_ISSUER)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	query.Set("period", fmt.Sprint(int(TOTP_PERIOD.Seconds())))
+
+	label := url.PathEscape(MFA_ISSUER + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPStep returns the number of the time step t falls in.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTP_PERIOD.Seconds())
+}
+
+// TOTPCode returns the code of secret for the given time step.
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%1000000), nil
+}
+
+// MatchTOTP checks code against the steps just before, at and after t, so
+// that clocks a little apart still agree. Steps up to and including
+// lastStep were used already and never match again. It returns the step
+// that matched.
+func MatchTOTP(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	now := TOTPStep(t)
+
+	for step := now - 1; step <= now+1; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// GenerateRecoveryCodes returns n single-use codes for logging in without the
+// authenticator, formatted like "k7fq2-m3xpa", along with their hashes.
+func GenerateRecoveryCodes(n int) (codes, hashes []string, err error) {
+	for range n {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(raw))[:10]
+		code = code[:5] + "-" + code[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, HashSecret(code))
+	}
+
+	return codes, hashes, nil
+}
@@ -0,0 +1,82 @@
+package helpers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message is something to tell a user outside the API, such as a password
+// reset token.
+type Message struct {
+	To      string    `json:"to"` // username of the recipient
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+// Notifier delivers messages to users. A mail or SMS gateway can be plugged
+// in by assigning NOTIFIER before the router is built.
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+var NOTIFIER Notifier = LoadNotifier()
+
+// LoadNotifier picks a stand-in notifier for local use: NOTIFIER=file appends
+// every message as a JSON line to NOTIFY_FILE (notifications.jsonl by
+// default). Anything else only logs that a message was due, since the body
+// can hold a secret like a reset token.
+func LoadNotifier() Notifier {
+	if os.Getenv("NOTIFIER") != "file" {
+		return LogNotifier{}
+	}
+
+	path := os.Getenv("NOTIFY_FILE")
+	if path == "" {
+		path = "notifications.jsonl"
+	}
+
+	return &FileNotifier{Path: path}
+}
+
+// LogNotifier writes the recipient and subject of messages to the standard
+// logger, and never the body: logs are read by more people than the user.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, message Message) error {
+	log.Printf("notification for %s: %s (not delivered, set NOTIFIER to send it)", message.To, message.Subject)
+	return nil
+}
+
+// FileNotifier appends messages to the file at Path, one JSON object a line.
+type FileNotifier struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, message Message) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
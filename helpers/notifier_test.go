@@ -0,0 +1,28 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogNotifierLeavesOutTheBody(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	message := Message{To: "member@example.com", Subject: "Password reset", Body: "Use the token s3cret"}
+	if err := (LogNotifier{}).Notify(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("the log holds the body: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "member@example.com") {
+		t.Errorf("the log does not name the recipient: %s", buf.String())
+	}
+}
@@ -0,0 +1,374 @@
+package helpers
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCRoleMapping gives Role to users whose role claim holds Value.
+type OIDCRoleMapping struct {
+	Value string
+	Role  string
+}
+
+// OIDCConfig is the client registration with the campus OpenID provider.
+// Login through it is offered only once OIDC_ISSUER, OIDC_CLIENT_ID and
+// OIDC_REDIRECT_URL are set.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for a public client, PKCE protects the code
+	RedirectURL  string // the callback route as the provider reaches it
+	Scopes       []string
+	RoleClaim    string
+	RoleMap      []OIDCRoleMapping // first match wins
+	// LinkByEmail lets a first OIDC login take over the local account named
+	// after its verified email. Only turn it on if the provider can be
+	// trusted to verify emails, as the account's password is then bypassed.
+	LinkByEmail bool
+}
+
+var OIDC_CONFIG = LoadOIDCConfig()
+
+// LoadOIDCConfig reads the OIDC_* environment variables. OIDC_ROLE_MAP lists
+// claim values and the roles they give, e.g.
+// OIDC_ROLE_MAP=library-admins=ADMIN,library-staff=LIBRARIAN, and
+// OIDC_LINK_BY_EMAIL=true turns on LinkByEmail.
+func LoadOIDCConfig() OIDCConfig {
+	config := OIDCConfig{
+		Issuer:       strings.TrimSuffix(envString("OIDC_ISSUER", ""), "/"),
+		ClientID:     envString("OIDC_CLIENT_ID", ""),
+		ClientSecret: envString("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  envString("OIDC_REDIRECT_URL", ""),
+		Scopes:       envList("OIDC_SCOPES"),
+		RoleClaim:    envString("OIDC_ROLE_CLAIM", "groups"),
+		LinkByEmail:  envString("OIDC_LINK_BY_EMAIL", "") == "true",
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	for _, entry := range envList("OIDC_ROLE_MAP") {
+		value, role, ok := strings.Cut(entry, "=")
+		if !ok || value == "" || role == "" {
+			continue
+		}
+		config.RoleMap = append(config.RoleMap, OIDCRoleMapping{Value: value, Role: role})
+	}
+
+	return config
+}
+
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != "" && c.RedirectURL != ""
+}
+
+// MapRole returns the role the first mapping matching one of values gives,
+// and false if none matches.
+func (c OIDCConfig) MapRole(values []string) (string, bool) {
+	for _, mapping := range c.RoleMap {
+		for _, value := range values {
+			if value == mapping.Value {
+				return mapping.Role, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+var (
+	ErrOIDCProvider = errors.New("the OpenID provider could not be reached or refused the request")
+	ErrOIDCIDToken  = errors.New("the ID token of the OpenID provider is invalid")
+)
+
+// OIDC_JWKS_REFRESH limits how often the keys of the provider are fetched
+// again when a token names a key that is not known yet.
+const OIDC_JWKS_REFRESH = time.Minute
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider caches the discovery document and signing keys of the
+// provider of OIDC_CONFIG.
+type oidcProvider struct {
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+var OIDC_PROVIDER = &oidcProvider{}
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := getJSON(ctx, OIDC_CONFIG.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != OIDC_CONFIG.Issuer {
+		return nil, fmt.Errorf("%w: it calls itself %q", ErrOIDCProvider, discovery.Issuer)
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// publicKey returns the provider's key named kid, fetching the key set again
+// if kid is new to it.
+func (p *oidcProvider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetched) < OIDC_JWKS_REFRESH {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set JSONWebKeySet
+	if err := getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	p.keys = map[string]*rsa.PublicKey{}
+	p.keysFetched = time.Now()
+
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+
+		p.keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func getJSON(ctx context.Context, target string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := oidcClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOIDCProvider, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: GET %s answered %s", ErrOIDCProvider, target, res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(into)
+}
+
+// GeneratePKCE returns an RFC 7636 code verifier and its S256 challenge.
+func GeneratePKCE() (verifier, challenge string, err error) {
+	verifier, _, err = GenerateSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// OIDCAuthorizationURL returns where to send the browser to log in with the
+// provider.
+func OIDCAuthorizationURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	discovery, err := OIDC_PROVIDER.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", OIDC_CONFIG.ClientID)
+	query.Set("redirect_uri", OIDC_CONFIG.RedirectURL)
+	query.Set("scope", strings.Join(OIDC_CONFIG.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// ExchangeOIDCCode trades the authorization code for the tokens of the
+// provider and returns the ID token.
+func ExchangeOIDCCode(ctx context.Context, code, verifier string) (string, error) {
+	discovery, err := OIDC_PROVIDER.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", OIDC_CONFIG.RedirectURL)
+	form.Set("client_id", OIDC_CONFIG.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if OIDC_CONFIG.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(OIDC_CONFIG.ClientID), url.QueryEscape(OIDC_CONFIG.ClientSecret))
+	}
+
+	res, err := oidcClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrOIDCProvider, err)
+	}
+	defer res.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrOIDCProvider, err)
+	}
+
+	if res.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: %s %s", ErrOIDCProvider, tokens.Error, tokens.ErrorDescription)
+	}
+
+	return tokens.IDToken, nil
+}
+
+// OIDCIdentity is what a verified ID token says about the user.
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Roles             []string // values of the role claim
+}
+
+// VerifyOIDCIDToken checks the signature, issuer, audience, lifetime and
+// nonce of an ID token and returns the identity it carries.
+func VerifyOIDCIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{SIGNING_ALGORITHM}),
+		jwt.WithIssuer(OIDC_CONFIG.Issuer),
+		jwt.WithAudience(OIDC_CONFIG.ClientID),
+		jwt.WithLeeway(CLOCK_SKEW),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return OIDC_PROVIDER.publicKey(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOIDCIDToken, err)
+	}
+
+	if claimString(claims, "nonce") != nonce {
+		return nil, fmt.Errorf("%w: the nonce does not match the login", ErrOIDCIDToken)
+	}
+
+	identity := OIDCIdentity{
+		Issuer:            OIDC_CONFIG.Issuer,
+		Subject:           claimString(claims, "sub"),
+		Email:             claimString(claims, "email"),
+		PreferredUsername: claimString(claims, "preferred_username"),
+		Roles:             claimStrings(claims, OIDC_CONFIG.RoleClaim),
+	}
+
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: it names no subject", ErrOIDCIDToken)
+	}
+
+	return &identity, nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// claimStrings reads a claim holding a string or a list of strings.
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
@@ -1,117 +1,120 @@
-package helpers
-
-import (
-	"context"
-	"errors"
-	"fmt"
-	"log"
-	"os"
-	"time"
-
-	jwt "github.com/dgrijalva/jwt-go"
-	"github.com/roh4nyh/iit_bombay/database"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-)
-
-type SignedUserDetails struct {
-	UserName string `json:"username"`
-	Role     string `json:"role"`
-	IsActive bool   `json:"is_active"`
-	Uid      string `json:"uid"`
-	jwt.StandardClaims
-}
-
-const (
-	userDatabaseName   = "Cluster0"
-	userCollectionName = "users"
-)
-
-var UserCollection *mongo.Collection = database.OpenCollection(userDatabaseName, userCollectionName)
-var USER_SECRET_KEY string = os.Getenv("USER_SECRET_KEY")
-
-func GenerateUserToken(username, uid, role string, isActive bool) (signedToken string, err error) {
-	claims := &SignedUserDetails{
-		UserName: username,
-		Uid:      uid,
-		Role:     role,
-		IsActive: isActive,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
-		},
-	}
-
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(USER_SECRET_KEY))
-	if err != nil {
-		msg := fmt.Sprintf("Error signing Token: %v", err)
-		return "", errors.New(msg)
-	}
-
-	return token, nil
-}
-
-func UpdateUserToken(signedToken, userId string) {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-	defer cancel()
-
-	var updateObj primitive.D
-	updateObj = append(updateObj, bson.E{Key: "token", Value: signedToken})
-
-	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: updatedAt})
-
-	upsert := true
-	// userId, _ := primitive.ObjectIDFromHex(userIdStr)
-
-	filter := bson.M{"user_id": userId}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-
-	_, err := UserCollection.UpdateOne(
-		ctx,
-		filter,
-		bson.D{
-			{Key: "$set", Value: updateObj},
-		},
-		&opt,
-	)
-
-	if err != nil {
-		log.Panic(err)
-		return
-	}
-
-	// return
-}
-
-func ValidateUserToken(signedToken string) (claims *SignedUserDetails, msg string) {
-	token, err := jwt.ParseWithClaims(
-		signedToken,
-		&SignedUserDetails{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(USER_SECRET_KEY), nil
-		},
-	)
-
-	if err != nil {
-		msg = err.Error()
-		return
-	}
-
-	claims, ok := token.Claims.(*SignedUserDetails)
-	if !ok {
-		msg = fmt.Sprintf("the token is invalid")
-		msg = err.Error()
-		return
-	}
-
-	if claims.ExpiresAt < time.Now().Local().Unix() {
-		msg = fmt.Sprintf("the token has expired")
-		msg = err.Error()
-		return
-	}
-	return claims, msg
-}
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SignedUserDetails struct {
+	UserName string `json:"username"`
+	Role     string `json:"role"`
+	IsActive bool   `json:"is_active"`
+	Uid      string `json:"uid"`
+	Sid      string `json:"sid"` // The session the token was issued for
+	jwt.RegisteredClaims
+}
+
+// Access tokens are short lived and renewed with the refresh token of their
+// session, which lasts REFRESH_TOKEN_DAYS past its last use. Password reset
+// tokens can be used once within PASSWORD_RESET_MINUTES.
+var (
+	ACCESS_TOKEN_TTL   time.Duration = time.Duration(envInt("ACCESS_TOKEN_MINUTES", 15)) * time.Minute
+	REFRESH_TOKEN_TTL  time.Duration = time.Duration(envInt("REFRESH_TOKEN_DAYS", 30)) * 24 * time.Hour
+	PASSWORD_RESET_TTL time.Duration = time.Duration(envInt("PASSWORD_RESET_MINUTES", 30)) * time.Minute
+)
+
+// Access tokens name this API as their issuer and audience, so services
+// sharing the key set cannot take each other's tokens. Clocks of issuer and
+// verifier may be JWT_CLOCK_SKEW_SECONDS apart.
+var (
+	TOKEN_ISSUER   string        = envString("JWT_ISSUER", "iit_bombay")
+	TOKEN_AUDIENCE string        = envString("JWT_AUDIENCE", "iit_bombay")
+	CLOCK_SKEW     time.Duration = time.Duration(envCount("JWT_CLOCK_SKEW_SECONDS", 30)) * time.Second
+)
+
+// ErrInvalidToken wraps every reason an access token is refused, such as
+// jwt.ErrTokenExpired or jwt.ErrTokenInvalidAudience.
+var ErrInvalidToken = errors.New("invalid access token")
+
+var tokenParser = jwt.NewParser(
+	jwt.WithValidMethods([]string{SIGNING_ALGORITHM}),
+	jwt.WithIssuer(TOKEN_ISSUER),
+	jwt.WithAudience(TOKEN_AUDIENCE),
+	jwt.WithLeeway(CLOCK_SKEW),
+	jwt.WithExpirationRequired(),
+	jwt.WithIssuedAt(),
+)
+
+func GenerateUserToken(username, uid, role string, isActive bool, sid string) (signedToken string, err error) {
+	now := time.Now()
+	claims := &SignedUserDetails{
+		UserName: username,
+		Uid:      uid,
+		Role:     role,
+		IsActive: isActive,
+		Sid:      sid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    TOKEN_ISSUER,
+			Subject:   uid,
+			Audience:  jwt.ClaimStrings{TOKEN_AUDIENCE},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ACCESS_TOKEN_TTL)),
+		},
+	}
+
+	key, err := KEY_RING.signingKey(now)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.kid
+
+	signedToken, err = token.SignedString(key.private)
+	if err != nil {
+		return "", fmt.Errorf("Error signing Token: %w", err)
+	}
+
+	return signedToken, nil
+}
+
+// ValidateUserToken returns the claims of signedToken, or an error wrapping
+// ErrInvalidToken and the jwt error saying what is wrong with it.
+func ValidateUserToken(signedToken string) (*SignedUserDetails, error) {
+	claims := &SignedUserDetails{}
+
+	_, err := tokenParser.ParseWithClaims(signedToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return KEY_RING.verificationKey(kid, time.Now())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	return claims, nil
+}
+
+// GenerateSecret returns a random token, such as a refresh token or an
+// invitation code, and the hash under which it is stored.
+func GenerateSecret() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err = rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashSecret(token), nil
+}
+
+func HashSecret(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,55 +1,73 @@
-package main
-
-import (
-	"fmt"
-	"net/http"
-	"os"
-
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
-
-	"github.com/roh4nyh/iit_bombay/middleware"
-	"github.com/roh4nyh/iit_bombay/routes"
-)
-
-func main() {
-	// err := godotenv.Load(".env")
-	// if err != nil {
-	// 	log.Printf("error loading .env file: %v", err)
-	// }
-
-	PORT := os.Getenv("PORT")
-	if PORT == "" {
-		PORT = "8080"
-	}
-
-	gin.SetMode(gin.ReleaseMode)
-
-	app := gin.New()
-	app.Use(gin.Logger())
-
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"*"}
-	config.AllowCredentials = true
-	config.AllowHeaders = []string{"Authorization", "Content-Type"}
-	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	app.Use(cors.New(config))
-
-	app.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"success": "iit bombay server is up and running..."})
-	})
-
-	routes.AuthRoutes(app)
-
-	routes.LibrarianRoutes(app)
-
-	routes.MemberRoutes(app)
-
-	app.GET("/api/v1/whoami", middleware.Authenticate(), func(c *gin.Context) {
-		username := c.GetString("username")
-		role := c.GetString("role")
-		c.JSON(http.StatusOK, gin.H{"username": username, "role": role})
-	})
-
-	app.Run(fmt.Sprintf(":%s", PORT))
-}
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/roh4nyh/iit_bombay/controllers"
+	"github.com/roh4nyh/iit_bombay/database"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"github.com/roh4nyh/iit_bombay/routes"
+)
+
+func main() {
+	// err := godotenv.Load(".env")
+	// if err != nil {
+	// 	log.Printf("error loading .env file: %v", err)
+	// }
+
+	PORT := os.Getenv("PORT")
+	if PORT == "" {
+		PORT = "8080"
+	}
+
+	// STORAGE_BACKEND=memory runs the API without MongoDB, all data is lost on exit
+	var store repository.Store
+	if os.Getenv("STORAGE_BACKEND") == "memory" {
+		log.Println("using in-memory storage backend")
+		store = repository.NewMemoryStore()
+	} else {
+		db := database.Client().Database(repository.DatabaseName)
+		if err := repository.EnsureIndexes(context.Background(), db); err != nil {
+			log.Fatalf("error creating indexes: %v", err)
+		}
+
+		store = repository.NewMongoStore(db)
+	}
+
+	// every permission check needs the built-in roles
+	if err := controllers.EnsureRoles(store); err != nil {
+		log.Fatalf("error creating the built-in roles: %v", err)
+	}
+
+	// access tokens are signed with keys that rotate on a schedule
+	if err := controllers.RotateSigningKeys(store); err != nil {
+		log.Fatalf("error loading the token signing keys: %v", err)
+	}
+	controllers.ScheduleKeyRotation(store)
+
+	// the first admin is created with a setup token
+	if err := controllers.PrepareSetup(store); err != nil {
+		log.Fatalf("error preparing admin setup: %v", err)
+	}
+
+	// ISBNs are stored in one canonical form so that any form finds the book
+	if err := controllers.NormalizeISBNs(store); err != nil {
+		log.Printf("error normalizing book isbns: %v", err)
+	}
+
+	// books catalogued before copies were tracked get copy records once
+	if err := controllers.BackfillCopies(store); err != nil {
+		log.Printf("error backfilling book copies: %v", err)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+
+	app := routes.NewRouter(store)
+
+	app.Run(fmt.Sprintf(":%s", PORT))
+}
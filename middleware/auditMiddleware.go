@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID tags every request with an id, the one the client sent in
+// X-Request-ID if it looks sane or a random one, and echoes it in the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestId := c.GetHeader(REQUEST_ID_HEADER)
+		if !requestIdPattern.MatchString(requestId) {
+			raw := make([]byte, 16)
+			rand.Read(raw)
+			requestId = hex.EncodeToString(raw)
+		}
+
+		c.Set("request_id", requestId)
+		c.Header(REQUEST_ID_HEADER, requestId)
+
+		c.Next()
+	}
+}
+
+// Audit writes the audit entries a handler queued with helper.QueueAudit once
+// it has answered. A request that changed something without queueing an
+// entry is recorded under its method and route, so every mutation leaves a trace.
+func Audit(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		entries := helper.QueuedAudits(c)
+		if len(entries) == 0 {
+			if !mutating(c.Request.Method) || c.FullPath() == "" || c.Writer.Status() >= http.StatusBadRequest {
+				return
+			}
+
+			entries = append(entries, helper.NewAuditEntry(c, c.Request.Method+" "+c.FullPath(), routeTarget(c)))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		for _, entry := range entries {
+			entry.Status = c.Writer.Status()
+			if err := store.Audit().Insert(ctx, entry); err != nil {
+				log.Printf("audit: writing %s of %s: %v", entry.Action, entry.Target, err)
+			}
+		}
+	}
+}
+
+func mutating(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+// routeTarget names what a request acted on after its path parameters, e.g.
+// "isbn:9780262033848".
+func routeTarget(c *gin.Context) string {
+	params := make([]string, 0, len(c.Params))
+	for _, param := range c.Params {
+		params = append(params, param.Key+":"+param.Value)
+	}
+
+	return strings.Join(params, ",")
+}
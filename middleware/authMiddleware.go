@@ -1,67 +1,207 @@
-package middleware
-
-import (
-	"log"
-	"net/http"
-
-	"github.com/gin-gonic/gin"
-	helper "github.com/roh4nyh/iit_bombay/helpers"
-	"github.com/roh4nyh/iit_bombay/models"
-)
-
-func Authenticate() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Use the Authorization header instead of token
-		clientToken := c.Request.Header.Get("Authorization")
-		if clientToken == "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "No Authorization header found"})
-			c.Abort()
-			return
-		}
-
-		// Remove "Bearer " from the token string
-		token := clientToken[len("Bearer "):]
-
-		claims, err := helper.ValidateUserToken(token)
-		if err != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
-			c.Abort()
-			return
-		}
-
-		c.Set("username", claims.UserName)
-		c.Set("role", claims.Role)
-		c.Set("uid", claims.Uid)
-		c.Set("is_active", claims.IsActive)
-
-		log.Printf("%+v", claims)
-
-		c.Next()
-	}
-}
-
-func AuthenticateLibrarian() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		role := c.GetString("role")
-		if role != models.ROLE_LIBRARIAN {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "UnAuthenticated to access this resource"})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
-
-func AuthenticateMember() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		role := c.GetString("role")
-		if role != models.ROLE_MEMBER {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "UnAuthenticated to access this resource"})
-			c.Abort()
-			return
-		}
-
-		c.Next()
-	}
-}
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	helper "github.com/roh4nyh/iit_bombay/helpers"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Authenticate accepts access tokens whose session is still active, so that
+// logging out or revoking sessions takes effect before the token expires, and
+// API keys that are neither expired nor revoked.
+func Authenticate(store repository.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Use the Authorization header instead of token
+		clientToken := c.Request.Header.Get("Authorization")
+		if clientToken == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No Authorization header found"})
+			c.Abort()
+			return
+		}
+
+		// Remove "Bearer " from the token string
+		token, ok := strings.CutPrefix(clientToken, "Bearer ")
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the Authorization header must hold a Bearer token"})
+			c.Abort()
+			return
+		}
+
+		if helper.IsAPIKey(token) {
+			authenticateAPIKey(store, c, token)
+			return
+		}
+
+		claims, err := helper.ValidateUserToken(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": tokenErrorMessage(err)})
+			c.Abort()
+			return
+		}
+
+		if !sessionActive(store, claims) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "the session has expired or been revoked"})
+			c.Abort()
+			return
+		}
+
+		permissions, loadErr := rolePermissions(store, claims.Role)
+		if loadErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while loading permissions"})
+			c.Abort()
+			return
+		}
+
+		c.Set("username", claims.UserName)
+		c.Set("role", claims.Role)
+		c.Set("uid", claims.Uid)
+		c.Set("is_active", claims.IsActive)
+		c.Set("permissions", permissions)
+
+		log.Printf("%+v", claims)
+
+		c.Next()
+	}
+}
+
+// authenticateAPIKey lets a request made with key act as the user who created
+// the key, holding the permissions granted by both the key and the user's role.
+func authenticateAPIKey(store repository.Store, c *gin.Context, key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	apiKey, err := store.APIKeys().FindByHash(ctx, helper.HashSecret(key))
+	if errors.Is(err, repository.ErrNotFound) || (err == nil && !apiKey.Active(now)) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "the API key is unknown, expired or revoked"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking the API key"})
+		c.Abort()
+		return
+	}
+
+	user, err := store.Users().FindByID(ctx, apiKey.CreatedBy)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "the user who created the API key no longer exists"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while checking the API key"})
+		c.Abort()
+		return
+	}
+
+	rolePerms, err := rolePermissions(store, *user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while loading permissions"})
+		c.Abort()
+		return
+	}
+
+	permissions := []string{}
+	for _, permission := range apiKey.Permissions {
+		if slices.Contains(rolePerms, permission) {
+			permissions = append(permissions, permission)
+		}
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= helper.API_KEY_TOUCH_INTERVAL {
+		if err := store.APIKeys().Touch(ctx, apiKey.ID, now); err != nil {
+			log.Printf("api key %s: recording its use: %v", apiKey.Prefix, err)
+		}
+	}
+
+	c.Set("username", *user.Username)
+	c.Set("role", *user.Role)
+	c.Set("uid", user.ID.Hex())
+	c.Set("is_active", user.IsActive != nil && *user.IsActive)
+	c.Set("permissions", permissions)
+	c.Set("api_key_id", apiKey.ID.Hex())
+
+	c.Next()
+}
+
+// tokenErrorMessage says why an access token was refused without echoing
+// parser internals back to the client.
+func tokenErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "the token has expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "the token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer), errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "the token was not issued for this API"
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "the token is malformed"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return "the token signature is invalid"
+	default:
+		return "the token is invalid"
+	}
+}
+
+func sessionActive(store repository.Store, claims *helper.SignedUserDetails) bool {
+	sessionId, err := primitive.ObjectIDFromHex(claims.Sid)
+	if err != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, err := store.Sessions().FindByID(ctx, sessionId)
+	if err != nil {
+		return false
+	}
+
+	return session.UserID.Hex() == claims.Uid && session.Active(time.Now())
+}
+
+// rolePermissions returns the permissions of the named role, none if the role
+// no longer exists.
+func rolePermissions(store repository.Store, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	role, err := store.Roles().FindByName(ctx, name)
+	if errors.Is(err, repository.ErrNotFound) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return role.Permissions, nil
+}
+
+// RequirePermission lets the request through only if the role of the user
+// grants every one of permissions. It must run after Authenticate.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if !helper.HasPermission(c, permission) {
+				c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("missing permission %s", permission)})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
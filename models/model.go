@@ -1,51 +1,436 @@
-package models
-
-import (
-	"time"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
-)
-
-const (
-	ROLE_LIBRARIAN      = "LIBRARIAN"
-	ROLE_MEMBER         = "MEMBER"
-	STATUS_AVAILABLE    = "AVAILABLE"
-	STATUS_OUT_OF_STOCK = "OUT_OF_STOCK"
-	STATUS_BORROWED     = "BORROWED"
-	STATUS_RETURNED     = "RETURNED"
-)
-
-type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Username  *string            `bson:"username" json:"username" validate:"required"`
-	Password  *string            `bson:"password" json:"password" validate:"required,min=4"`
-	Role      *string            `bson:"role" json:"role" validate:"required,eq=LIBRARIAN|eq=MEMBER"`
-	IsActive  *bool              `bson:"is_active" json:"is_active"` // Marks if user is active or deleted
-	Token     *string            `bson:"token,omitempty" json:"token,omitempty"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
-	UserID    string             `bson:"user_id,omitempty" json:"user_id,omitempty"`
-}
-
-type Book struct {
-	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	ISBN   *string            `bson:"isbn" json:"isbn" validate:"required"`
-	Title  *string            `bson:"title" json:"title" validate:"required"`
-	Author *string            `bson:"author" json:"author" validate:"required"`
-	Status *string            `bson:"status" json:"status" validate:"required,eq=AVAILABLE|eq=OUT_OF_STOCK"`
-	Qty    int                `bson:"qty" json:"qty" validate:"required"`
-	// BorrowedBy *primitive.ObjectID `bson:"borrowed_by,omitempty" json:"borrowed_by,omitempty"` // User ID of the member borrowing the book
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
-	BookID    string    `bson:"book_id,omitempty" json:"book_id,omitempty"`
-}
-
-type BorrowHistory struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"` // The member who borrowed the book
-	BookID     primitive.ObjectID `bson:"book_id" json:"book_id"` // The book being borrowed
-	BorrowedAt time.Time          `bson:"borrowed_at" json:"borrowed_at"`
-	ReturnedAt time.Time          `bson:"returned_at,omitempty" json:"returned_at,omitempty"` // Nullable if not yet returned
-	Status     string             `bson:"status,omitempty" json:"status,omitempty" validate:"eq=RETURNED|eq=BORROWED"`
-	BorrowID   string             `bson:"borrow_id,omitempty" json:"borrow_id,omitempty"`
-}
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ROLE_ADMIN          = "ADMIN"
+	ROLE_LIBRARIAN      = "LIBRARIAN"
+	ROLE_MEMBER         = "MEMBER"
+	STATUS_AVAILABLE    = "AVAILABLE"
+	STATUS_OUT_OF_STOCK = "OUT_OF_STOCK"
+	STATUS_BORROWED     = "BORROWED"
+	STATUS_RETURNED     = "RETURNED"
+	STATUS_OVERDUE      = "OVERDUE"
+	STATUS_UNPAID       = "UNPAID"
+	STATUS_PAID         = "PAID"
+	STATUS_WAIVED       = "WAIVED"
+	STATUS_WAITING      = "WAITING"
+	STATUS_READY        = "READY"
+	STATUS_FULFILLED    = "FULFILLED"
+	STATUS_CANCELLED    = "CANCELLED"
+	STATUS_EXPIRED      = "EXPIRED"
+	STATUS_ON_HOLD      = "ON_HOLD"
+	STATUS_IN_REPAIR    = "IN_REPAIR"
+	STATUS_LOST         = "LOST"
+	STATUS_PENDING      = "PENDING"
+	STATUS_APPROVED     = "APPROVED"
+	STATUS_REJECTED     = "REJECTED"
+	CONDITION_NEW       = "NEW"
+	CONDITION_GOOD      = "GOOD"
+	CONDITION_WORN      = "WORN"
+	CONDITION_DAMAGED   = "DAMAGED"
+)
+
+// Contributor roles say what part someone had in a book.
+const (
+	CONTRIBUTOR_AUTHOR      = "AUTHOR"
+	CONTRIBUTOR_EDITOR      = "EDITOR"
+	CONTRIBUTOR_TRANSLATOR  = "TRANSLATOR"
+	CONTRIBUTOR_ILLUSTRATOR = "ILLUSTRATOR"
+)
+
+// Call number schemes, the Dewey Decimal and the Library of Congress
+// Classification.
+const (
+	CALL_NUMBER_DDC = "DDC"
+	CALL_NUMBER_LCC = "LCC"
+)
+
+// Permissions name single actions a role can allow. Routes require them
+// through middleware.RequirePermission.
+const (
+	PERM_BOOKS_READ       = "books:read"
+	PERM_BOOKS_WRITE      = "books:write"
+	PERM_COPIES_READ      = "copies:read"
+	PERM_COPIES_WRITE     = "copies:write"
+	PERM_HOLDS_PLACE      = "holds:place"  // place, list and cancel one's own holds
+	PERM_HOLDS_READ       = "holds:read"   // see the hold queue of any book
+	PERM_HOLDS_CANCEL     = "holds:cancel" // cancel any member's hold
+	PERM_LOANS_BORROW     = "loans:borrow" // borrow, renew and return books, see one's own loans and fines
+	PERM_LOANS_READ       = "loans:read"   // see any member's loans
+	PERM_LOANS_OVERRIDE   = "loans:override"
+	PERM_FINES_READ       = "fines:read"
+	PERM_FINES_COLLECT    = "fines:collect"
+	PERM_FINES_WAIVE      = "fines:waive"
+	PERM_USERS_READ       = "users:read"
+	PERM_USERS_WRITE      = "users:write"
+	PERM_USERS_DEACTIVATE = "users:deactivate"
+	PERM_USERS_DELETE     = "users:delete"
+	PERM_SESSIONS_REVOKE  = "sessions:revoke"
+	PERM_ROLES_MANAGE     = "roles:manage"
+	PERM_ACCOUNT_DELETE   = "account:delete" // deactivate one's own account
+	PERM_AUDIT_READ       = "audit:read"
+	PERM_API_KEYS_MANAGE  = "apikeys:manage" // create, list and revoke API keys
+)
+
+// PERMISSIONS lists every permission a role may hold.
+var PERMISSIONS = []string{
+	PERM_BOOKS_READ, PERM_BOOKS_WRITE, PERM_COPIES_READ, PERM_COPIES_WRITE,
+	PERM_HOLDS_PLACE, PERM_HOLDS_READ, PERM_HOLDS_CANCEL,
+	PERM_LOANS_BORROW, PERM_LOANS_READ, PERM_LOANS_OVERRIDE,
+	PERM_FINES_READ, PERM_FINES_COLLECT, PERM_FINES_WAIVE,
+	PERM_USERS_READ, PERM_USERS_WRITE, PERM_USERS_DEACTIVATE, PERM_USERS_DELETE,
+	PERM_SESSIONS_REVOKE, PERM_ROLES_MANAGE, PERM_ACCOUNT_DELETE, PERM_AUDIT_READ,
+	PERM_API_KEYS_MANAGE,
+}
+
+// SELF_PERMISSIONS only ever act on the account of whoever holds them, so
+// holding them gives no say over other users.
+var SELF_PERMISSIONS = []string{PERM_HOLDS_PLACE, PERM_LOANS_BORROW, PERM_ACCOUNT_DELETE}
+
+type User struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Username *string            `bson:"username" json:"username" validate:"required"`
+	Password *string            `bson:"password" json:"password" validate:"required,min=4"`
+	Role     *string            `bson:"role" json:"role" validate:"required"` // Name of one of the roles collection
+	IsActive *bool              `bson:"is_active" json:"is_active"`           // Marks if user is active or deleted
+	// AccountStatus is PENDING for members who signed up themselves until a
+	// librarian approves or rejects them. Only APPROVED accounts can log in;
+	// accounts created before approvals existed have no status and count as approved.
+	AccountStatus string    `bson:"account_status,omitempty" json:"account_status,omitempty"`
+	Token         *string   `bson:"token,omitempty" json:"token,omitempty"` // Access token, only filled in by login and refresh
+	CreatedAt     time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time `bson:"updated_at" json:"updated_at"`
+	UserID        string    `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	MFA           *MFA      `bson:"mfa,omitempty" json:"-"`
+	OIDC          *OIDCLink `bson:"oidc,omitempty" json:"oidc,omitempty"`
+}
+
+// OIDCLink ties a user to their account at the OpenID provider. Users who
+// only ever logged in through it have no password.
+type OIDCLink struct {
+	Issuer  string `bson:"issuer" json:"issuer"`
+	Subject string `bson:"subject" json:"subject"`
+}
+
+// MFAEnabled reports whether the user has to give a TOTP code to log in.
+func (u *User) MFAEnabled() bool {
+	return u.MFA != nil && u.MFA.Enabled
+}
+
+// MFA is the TOTP second factor of a user. The secret is kept from the start
+// of the enrollment, but only asked for once a code confirmed it (Enabled).
+type MFA struct {
+	Secret         string     `bson:"secret"`
+	Enabled        bool       `bson:"enabled"`
+	LastStep       int64      `bson:"last_step,omitempty"`       // Newest TOTP step used, older codes are refused
+	RecoveryHashes []string   `bson:"recovery_hashes,omitempty"` // Hashes of the unused recovery codes
+	EnabledAt      *time.Time `bson:"enabled_at,omitempty"`
+}
+
+type Book struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ISBN   *string            `bson:"isbn" json:"isbn" validate:"required"`       // Canonical ISBN-13, any ISBN-10 or ISBN-13 form is accepted
+	ISBN10 *string            `bson:"isbn_10,omitempty" json:"isbn_10,omitempty"` // Derived from ISBN, none in the 979 range
+	Title  *string            `bson:"title" json:"title" validate:"required"`
+	Author *string            `bson:"author" json:"author" validate:"required"`                               // Main author as shown and searched, taken from Contributors if left out when adding
+	Status *string            `bson:"status" json:"status" validate:"omitempty,eq=AVAILABLE|eq=OUT_OF_STOCK"` // Derived from the copies on the shelf
+	Qty    int                `bson:"qty" json:"qty"`                                                         // Number of copies available to borrow
+	// Bibliographic details, all optional
+	Contributors    []Contributor `bson:"contributors,omitempty" json:"contributors,omitempty" validate:"omitempty,max=50,dive"`
+	Publisher       *string       `bson:"publisher,omitempty" json:"publisher,omitempty" validate:"omitempty,min=1,max=200"`
+	PublicationYear int           `bson:"publication_year,omitempty" json:"publication_year,omitempty" validate:"omitempty,min=1000,max=9999"`
+	Edition         *string       `bson:"edition,omitempty" json:"edition,omitempty" validate:"omitempty,min=1,max=100"`        // e.g. "2nd ed."
+	Language        *string       `bson:"language,omitempty" json:"language,omitempty" validate:"omitempty,bcp47_language_tag"` // e.g. "en", "hi", "mr"
+	Pages           int           `bson:"pages,omitempty" json:"pages,omitempty" validate:"omitempty,min=1,max=100000"`
+	Subjects        []string      `bson:"subjects,omitempty" json:"subjects,omitempty" validate:"omitempty,max=50,dive,required,max=200"` // Subjects and genres
+	CallNumber      *CallNumber   `bson:"call_number,omitempty" json:"call_number,omitempty" validate:"omitempty"`
+	Series          *Series       `bson:"series,omitempty" json:"series,omitempty" validate:"omitempty"`
+	Description     *string       `bson:"description,omitempty" json:"description,omitempty" validate:"omitempty,max=5000"`
+	CoverURL        *string       `bson:"cover_url,omitempty" json:"cover_url,omitempty" validate:"omitempty,http_url"`
+	// BorrowedBy *primitive.ObjectID `bson:"borrowed_by,omitempty" json:"borrowed_by,omitempty"` // User ID of the member borrowing the book
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+	BookID    string    `bson:"book_id,omitempty" json:"book_id,omitempty"`
+}
+
+// Contributor is someone who had a part in a book. Role is one of the
+// CONTRIBUTOR_* roles, AUTHOR if left out.
+type Contributor struct {
+	Name *string `bson:"name" json:"name" validate:"required,min=1,max=200"`
+	Role string  `bson:"role" json:"role" validate:"omitempty,oneof=AUTHOR EDITOR TRANSLATOR ILLUSTRATOR"`
+}
+
+// CallNumber shelves a book under a classification scheme, e.g. DDC 005.133
+// or LCC QA76.73.G63.
+type CallNumber struct {
+	Scheme string `bson:"scheme" json:"scheme" validate:"required,oneof=DDC LCC"`
+	Number string `bson:"number" json:"number" validate:"required,max=100"`
+}
+
+type Series struct {
+	Title  *string `bson:"title" json:"title" validate:"required,min=1,max=200"`
+	Volume string  `bson:"volume,omitempty" json:"volume,omitempty" validate:"omitempty,max=20"` // e.g. "3" or "III"
+}
+
+type BorrowHistory struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID  `bson:"user_id" json:"user_id"`                     // The member who borrowed the book
+	BookID       primitive.ObjectID  `bson:"book_id" json:"book_id"`                     // The book being borrowed
+	CopyID       *primitive.ObjectID `bson:"copy_id,omitempty" json:"copy_id,omitempty"` // The physical copy handed out
+	Barcode      string              `bson:"barcode,omitempty" json:"barcode,omitempty"`
+	BorrowedAt   time.Time           `bson:"borrowed_at" json:"borrowed_at"`
+	DueAt        time.Time           `bson:"due_at" json:"due_at"`                               // Computed from the loan period at borrow time
+	ReturnedAt   time.Time           `bson:"returned_at,omitempty" json:"returned_at,omitempty"` // Nullable if not yet returned
+	Status       string              `bson:"status,omitempty" json:"status,omitempty" validate:"eq=RETURNED|eq=BORROWED|eq=OVERDUE"`
+	RenewalCount int                 `bson:"renewal_count" json:"renewal_count"`
+	BorrowID     string              `bson:"borrow_id,omitempty" json:"borrow_id,omitempty"`
+}
+
+// Fine is charged when a loan is returned after its due date. Amounts are in
+// paise so that no floating point rounding creeps into the ledger.
+type Fine struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	LoanID       primitive.ObjectID  `bson:"loan_id" json:"loan_id"`
+	BookID       primitive.ObjectID  `bson:"book_id" json:"book_id"`
+	DaysLate     int                 `bson:"days_late" json:"days_late"`
+	Amount       int64               `bson:"amount" json:"amount"`
+	Paid         int64               `bson:"paid" json:"paid"`
+	Status       string              `bson:"status" json:"status" validate:"eq=UNPAID|eq=PAID|eq=WAIVED"`
+	Payments     []FinePayment       `bson:"payments" json:"payments"`
+	WaivedBy     *primitive.ObjectID `bson:"waived_by,omitempty" json:"waived_by,omitempty"`
+	WaiverReason string              `bson:"waiver_reason,omitempty" json:"waiver_reason,omitempty"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+type FinePayment struct {
+	Amount     int64              `bson:"amount" json:"amount" validate:"required,gt=0"`
+	Method     string             `bson:"method" json:"method"`
+	ReceivedBy primitive.ObjectID `bson:"received_by" json:"received_by"` // The librarian who took the payment
+	PaidAt     time.Time          `bson:"paid_at" json:"paid_at"`
+}
+
+// Outstanding is what the member still owes on the fine.
+func (f Fine) Outstanding() int64 {
+	if f.Status != STATUS_UNPAID {
+		return 0
+	}
+
+	return f.Amount - f.Paid
+}
+
+// Hold places a member in the FIFO queue for a book. Once a returned copy is
+// set aside for the member the hold is READY until ExpiresAt.
+type Hold struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	BookID    primitive.ObjectID  `bson:"book_id" json:"book_id"`
+	Status    string              `bson:"status" json:"status" validate:"eq=WAITING|eq=READY|eq=FULFILLED|eq=CANCELLED|eq=EXPIRED"`
+	CopyID    *primitive.ObjectID `bson:"copy_id,omitempty" json:"copy_id,omitempty"` // The copy set aside while READY
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	ReadyAt   time.Time           `bson:"ready_at,omitempty" json:"ready_at,omitempty"`
+	ExpiresAt time.Time           `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // End of the pickup window
+	UpdatedAt time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// Copy is one physical item of a book, identified by the barcode on its spine.
+// A book's Qty and Status are derived from the copies that are AVAILABLE.
+type Copy struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	BookID        primitive.ObjectID `bson:"book_id" json:"book_id"`
+	Barcode       *string            `bson:"barcode" json:"barcode" validate:"required"`
+	Condition     *string            `bson:"condition" json:"condition" validate:"omitempty,eq=NEW|eq=GOOD|eq=WORN|eq=DAMAGED"`
+	ShelfLocation *string            `bson:"shelf_location" json:"shelf_location"`
+	AcquiredAt    time.Time          `bson:"acquired_at" json:"acquired_at"`
+	Status        string             `bson:"status" json:"status" validate:"omitempty,eq=AVAILABLE|eq=BORROWED|eq=ON_HOLD|eq=IN_REPAIR|eq=LOST"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Session is one login of a user. The refresh token handed out for it is only
+// stored as a hash, and is replaced by a new one on every refresh.
+type Session struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
+	RefreshHash  string             `bson:"refresh_hash" json:"-"`
+	PreviousHash string             `bson:"previous_hash,omitempty" json:"-"` // The refresh token rotated out last, kept to detect reuse
+	UserAgent    string             `bson:"user_agent" json:"user_agent"`
+	IP           string             `bson:"ip" json:"ip"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	RefreshedAt  time.Time          `bson:"refreshed_at,omitempty" json:"refreshed_at,omitempty"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	RevokedAt    *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the session can still be used at now.
+func (s Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Role bundles the permissions granted to the users holding it. Built-in roles
+// are created on start and cannot be deleted.
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        *string            `bson:"name" json:"name" validate:"required,uppercase,excludesall= "`
+	Description string             `bson:"description" json:"description"`
+	Permissions []string           `bson:"permissions" json:"permissions" validate:"required,dive,required"`
+	BuiltIn     bool               `bson:"built_in" json:"built_in"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// Invitation lets whoever signs up with its code create an account with Role,
+// once and before ExpiresAt. Only a hash of the code is stored.
+type Invitation struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	CodeHash  string              `bson:"code_hash" json:"-"`
+	Role      *string             `bson:"role" json:"role" validate:"required"`
+	CreatedBy primitive.ObjectID  `bson:"created_by" json:"created_by"`
+	CreatedAt time.Time           `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time           `bson:"expires_at" json:"expires_at"`
+	UsedBy    *primitive.ObjectID `bson:"used_by,omitempty" json:"used_by,omitempty"`
+	UsedAt    *time.Time          `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
+
+// PasswordReset is a single-use token letting a user who forgot their
+// password set a new one before ExpiresAt. Only a hash of the token is stored.
+type PasswordReset struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
+
+// LoginAttempt counts the failed logins of one username ("user:<name>") or
+// client address ("ip:<addr>"). Logins for the key are refused until
+// LockedUntil once too many fail.
+type LoginAttempt struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Key           string             `bson:"key" json:"key"`
+	Failures      int                `bson:"failures" json:"failures"`
+	LastFailureAt time.Time          `bson:"last_failure_at" json:"last_failure_at"`
+	LockedUntil   *time.Time         `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+}
+
+// Audit actions. Requests that change something without a dedicated action
+// are recorded under their method and route, e.g. "POST /users/login".
+const (
+	AUDIT_LOGIN_LOCKOUT     = "login.lockout"
+	AUDIT_LOGIN_UNLOCK      = "login.unlock"
+	AUDIT_BOOK_CREATE       = "book.create"
+	AUDIT_BOOK_UPDATE       = "book.update"
+	AUDIT_BOOK_DELETE       = "book.delete"
+	AUDIT_BOOK_IMPORT       = "book.import"
+	AUDIT_COPY_CREATE       = "copy.create"
+	AUDIT_COPY_UPDATE       = "copy.update"
+	AUDIT_COPY_DELETE       = "copy.delete"
+	AUDIT_HOLD_CANCEL       = "hold.cancel"
+	AUDIT_USER_CREATE       = "user.create"
+	AUDIT_USER_UPDATE       = "user.update"
+	AUDIT_USER_DEACTIVATE   = "user.deactivate"
+	AUDIT_USER_DELETE       = "user.delete"
+	AUDIT_USER_APPROVE      = "user.approve"
+	AUDIT_USER_REJECT       = "user.reject"
+	AUDIT_SESSIONS_REVOKE   = "sessions.revoke"
+	AUDIT_INVITATION_CREATE = "invitation.create"
+	AUDIT_INVITATION_DELETE = "invitation.delete"
+	AUDIT_FINE_PAYMENT      = "fine.payment"
+	AUDIT_FINE_WAIVE        = "fine.waive"
+	AUDIT_ROLE_CREATE       = "role.create"
+	AUDIT_ROLE_UPDATE       = "role.update"
+	AUDIT_ROLE_DELETE       = "role.delete"
+	AUDIT_API_KEY_CREATE    = "apikey.create"
+	AUDIT_API_KEY_REVOKE    = "apikey.revoke"
+	AUDIT_LOGIN_OIDC        = "login.oidc"
+	AUDIT_USER_PROVISION    = "user.provision"
+	AUDIT_USER_LINK_OIDC    = "user.link_oidc"
+)
+
+// AuditEntry records a change or a security relevant event: what happened,
+// who caused it, if anyone, and what it happened to. Before and After hold
+// the fields of the target that changed, Before is empty for creations and
+// After for deletions. Entries are only ever added, never changed.
+type AuditEntry struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Action    string                 `bson:"action" json:"action"`
+	ActorID   *primitive.ObjectID    `bson:"actor_id,omitempty" json:"actor_id,omitempty"`
+	ActorRole string                 `bson:"actor_role,omitempty" json:"actor_role,omitempty"`
+	APIKeyID  *primitive.ObjectID    `bson:"api_key_id,omitempty" json:"api_key_id,omitempty"` // Set when the actor used an API key
+	Target    string                 `bson:"target" json:"target"`                             // "<kind>:<id>", e.g. "book:9780262033848"
+	Before    map[string]interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After     map[string]interface{} `bson:"after,omitempty" json:"after,omitempty"`
+	RequestID string                 `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	IP        string                 `bson:"ip,omitempty" json:"ip,omitempty"`
+	Method    string                 `bson:"method,omitempty" json:"method,omitempty"`
+	Route     string                 `bson:"route,omitempty" json:"route,omitempty"`
+	Status    int                    `bson:"status,omitempty" json:"status,omitempty"` // HTTP status of the response
+	Detail    string                 `bson:"detail,omitempty" json:"detail,omitempty"`
+	CreatedAt time.Time              `bson:"created_at" json:"created_at"`
+}
+
+// MFAChallenge is the second step of a login with MFA. Its token stands in
+// for the password that was already checked until the TOTP code is given.
+type MFAChallenge struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	Attempts  int                `bson:"attempts" json:"attempts"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
+
+// SigningKey is an RS256 key pair for access tokens, named by Kid in the
+// token header. The newest key whose ActivatesAt has passed signs; older ones
+// only verify the tokens they signed until those expire.
+type SigningKey struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kid         string             `bson:"kid" json:"kid"`
+	Algorithm   string             `bson:"algorithm" json:"algorithm"`
+	PrivateKey  string             `bson:"private_key" json:"-"` // PKCS #8, PEM encoded, sealed with SIGNING_KEY_SECRET if set
+	PublicKey   string             `bson:"public_key" json:"public_key"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	ActivatesAt time.Time          `bson:"activates_at" json:"activates_at"`
+}
+
+// APIKey lets a service call the API on behalf of the user who created it,
+// limited to Permissions. Only a hash of the key is stored; Prefix is the
+// start of the key, to tell keys apart.
+type APIKey struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        *string            `bson:"name" json:"name" validate:"required"`
+	Prefix      string             `bson:"prefix" json:"prefix"`
+	KeyHash     string             `bson:"key_hash" json:"-"`
+	Permissions []string           `bson:"permissions" json:"permissions" validate:"required,min=1,dive,required"`
+	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the key can be used at now.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// OIDCLogin is an OpenID Connect login between the redirect to the provider
+// and its callback. The state sent along finds it again; only its hash is
+// stored.
+type OIDCLogin struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	StateHash    string             `bson:"state_hash" json:"-"`
+	Nonce        string             `bson:"nonce" json:"-"`
+	CodeVerifier string             `bson:"code_verifier" json:"-"` // PKCE
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt       *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
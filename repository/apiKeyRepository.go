@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type APIKeyRepository interface {
+	// List returns every API key, revoked or not, oldest first.
+	List(ctx context.Context) ([]models.APIKey, error)
+	FindByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	Insert(ctx context.Context, key *models.APIKey) error
+	// Revoke marks the key as revoked at at and returns it, or ErrNotFound
+	// if there is no such key that is not revoked yet.
+	Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (*models.APIKey, error)
+	// Touch records that the key was used at at.
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+type mongoAPIKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	return findAll[models.APIKey](ctx, r.collection, bson.M{})
+}
+
+func (r *mongoAPIKeyRepository) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	return findOne[models.APIKey](ctx, r.collection, bson.M{"key_hash": hash})
+}
+
+func (r *mongoAPIKeyRepository) Insert(ctx context.Context, key *models.APIKey) error {
+	if key.ID.IsZero() {
+		key.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, key)
+	return err
+}
+
+func (r *mongoAPIKeyRepository) Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (*models.APIKey, error) {
+	var key models.APIKey
+	filter := bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"revoked_at": at}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (r *mongoAPIKeyRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+type memoryAPIKeyRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.apiKeys.find(nil), nil
+}
+
+func (r *memoryAPIKeyRepository) FindByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, key, err := r.s.apiKeys.findOne(func(key models.APIKey) bool { return key.KeyHash == hash })
+	return key, err
+}
+
+func (r *memoryAPIKeyRepository) Insert(ctx context.Context, key *models.APIKey) error {
+	defer r.s.lock(ctx)()
+
+	if key.ID.IsZero() {
+		key.ID = primitive.NewObjectID()
+	}
+
+	r.s.apiKeys.put(key.ID, *key)
+	return nil
+}
+
+func (r *memoryAPIKeyRepository) Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) (*models.APIKey, error) {
+	defer r.s.lock(ctx)()
+
+	key, err := r.s.apiKeys.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.RevokedAt != nil {
+		return nil, ErrNotFound
+	}
+
+	key.RevokedAt = &at
+	r.s.apiKeys.put(id, *key)
+	return key, nil
+}
+
+func (r *memoryAPIKeyRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	defer r.s.lock(ctx)()
+
+	key, err := r.s.apiKeys.get(id)
+	if err != nil {
+		return nil
+	}
+
+	key.LastUsedAt = &at
+	r.s.apiKeys.put(id, *key)
+	return nil
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuditFilter narrows ListPage down; zero fields match every entry. The
+// entries matched were created in [From, To).
+type AuditFilter struct {
+	ActorID primitive.ObjectID
+	Target  string
+	Action  string
+	From    time.Time
+	To      time.Time
+}
+
+// AuditRepository is append only: entries can be added and read, but there
+// is no way to change or remove one.
+type AuditRepository interface {
+	Insert(ctx context.Context, entry *models.AuditEntry) error
+	// ListPage returns one page of the matching entries, oldest first.
+	ListPage(ctx context.Context, filter AuditFilter, page Page) (*Paged[models.AuditEntry], error)
+}
+
+func (f AuditFilter) query() bson.M {
+	query := bson.M{}
+	if !f.ActorID.IsZero() {
+		query["actor_id"] = f.ActorID
+	}
+
+	if f.Target != "" {
+		query["target"] = f.Target
+	}
+
+	if f.Action != "" {
+		query["action"] = f.Action
+	}
+
+	createdAt := bson.M{}
+	if !f.From.IsZero() {
+		createdAt["$gte"] = f.From
+	}
+
+	if !f.To.IsZero() {
+		createdAt["$lt"] = f.To
+	}
+
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	return query
+}
+
+func (f AuditFilter) match(entry models.AuditEntry) bool {
+	if !f.ActorID.IsZero() && (entry.ActorID == nil || *entry.ActorID != f.ActorID) {
+		return false
+	}
+
+	if f.Target != "" && entry.Target != f.Target {
+		return false
+	}
+
+	if f.Action != "" && entry.Action != f.Action {
+		return false
+	}
+
+	if !f.From.IsZero() && entry.CreatedAt.Before(f.From) {
+		return false
+	}
+
+	return f.To.IsZero() || entry.CreatedAt.Before(f.To)
+}
+
+type mongoAuditRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoAuditRepository) Insert(ctx context.Context, entry *models.AuditEntry) error {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (r *mongoAuditRepository) ListPage(ctx context.Context, filter AuditFilter, page Page) (*Paged[models.AuditEntry], error) {
+	return findPage(ctx, r.collection, filter.query(), page, auditEntryID)
+}
+
+type memoryAuditRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryAuditRepository) Insert(ctx context.Context, entry *models.AuditEntry) error {
+	defer r.s.lock(ctx)()
+
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+
+	r.s.audit.put(entry.ID, *entry)
+	return nil
+}
+
+func (r *memoryAuditRepository) ListPage(ctx context.Context, filter AuditFilter, page Page) (*Paged[models.AuditEntry], error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return slicePage(r.s.audit.find(filter.match), page, auditEntryID, nil, 1)
+}
+
+func auditEntryID(entry models.AuditEntry) primitive.ObjectID { return entry.ID }
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type BookRepository interface {
+	List(ctx context.Context) ([]models.Book, error)
+	// Search returns one page of a catalog search and counts the matches by facet.
+	Search(ctx context.Context, query BookQuery, page Page) (*BookSearchResult, error)
+	FindByISBN(ctx context.Context, isbn string) (*models.Book, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Book, error)
+	CountByISBN(ctx context.Context, isbn string) (int64, error)
+	Insert(ctx context.Context, book *models.Book) error
+	Update(ctx context.Context, isbn string, set bson.M) error
+	Delete(ctx context.Context, isbn string) error
+	// SetStock records how many copies of the book are on the shelf and marks
+	// it OUT_OF_STOCK when there are none.
+	SetStock(ctx context.Context, id primitive.ObjectID, qty int, at time.Time) error
+}
+
+type mongoBookRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoBookRepository) List(ctx context.Context) ([]models.Book, error) {
+	return findAll[models.Book](ctx, r.collection, bson.M{})
+}
+
+func (r *mongoBookRepository) FindByISBN(ctx context.Context, isbn string) (*models.Book, error) {
+	return findOne[models.Book](ctx, r.collection, bson.M{"isbn": isbn})
+}
+
+func (r *mongoBookRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Book, error) {
+	return findOne[models.Book](ctx, r.collection, bson.M{"_id": id})
+}
+
+func (r *mongoBookRepository) CountByISBN(ctx context.Context, isbn string) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"isbn": isbn})
+}
+
+func (r *mongoBookRepository) Insert(ctx context.Context, book *models.Book) error {
+	if book.ID.IsZero() {
+		book.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, book)
+	return err
+}
+
+func (r *mongoBookRepository) Update(ctx context.Context, isbn string, set bson.M) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"isbn": bson.M{"$eq": isbn}}, bson.M{"$set": set})
+	return err
+}
+
+func (r *mongoBookRepository) Delete(ctx context.Context, isbn string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"isbn": bson.M{"$eq": isbn}})
+	return err
+}
+
+func (r *mongoBookRepository) SetStock(ctx context.Context, id primitive.ObjectID, qty int, at time.Time) error {
+	update := bson.M{"$set": bson.M{"qty": qty, "status": stockStatus(qty), "updated_at": at}}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+type memoryBookRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryBookRepository) List(ctx context.Context) ([]models.Book, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.books.find(nil), nil
+}
+
+func (r *memoryBookRepository) FindByISBN(ctx context.Context, isbn string) (*models.Book, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, book, err := r.s.books.findOne(matchISBN(isbn))
+	return book, err
+}
+
+func (r *memoryBookRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Book, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.books.get(id)
+}
+
+func (r *memoryBookRepository) CountByISBN(ctx context.Context, isbn string) (int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return int64(len(r.s.books.find(matchISBN(isbn)))), nil
+}
+
+func (r *memoryBookRepository) Insert(ctx context.Context, book *models.Book) error {
+	defer r.s.lock(ctx)()
+
+	if book.ID.IsZero() {
+		book.ID = primitive.NewObjectID()
+	}
+
+	r.s.books.put(book.ID, *book)
+	return nil
+}
+
+func (r *memoryBookRepository) Update(ctx context.Context, isbn string, set bson.M) error {
+	defer r.s.lock(ctx)()
+
+	id, book, err := r.s.books.findOne(matchISBN(isbn))
+	if err != nil {
+		return nil
+	}
+
+	updated, err := applySet(*book, set)
+	if err != nil {
+		return err
+	}
+
+	r.s.books.put(id, updated)
+	return nil
+}
+
+func (r *memoryBookRepository) Delete(ctx context.Context, isbn string) error {
+	defer r.s.lock(ctx)()
+
+	if id, _, err := r.s.books.findOne(matchISBN(isbn)); err == nil {
+		r.s.books.delete(id)
+	}
+
+	return nil
+}
+
+func (r *memoryBookRepository) SetStock(ctx context.Context, id primitive.ObjectID, qty int, at time.Time) error {
+	defer r.s.lock(ctx)()
+
+	book, err := r.s.books.get(id)
+	if err != nil {
+		return nil
+	}
+
+	status := stockStatus(qty)
+	book.Qty = qty
+	book.Status = &status
+	book.UpdatedAt = at
+
+	r.s.books.put(id, *book)
+	return nil
+}
+
+func stockStatus(qty int) string {
+	if qty > 0 {
+		return models.STATUS_AVAILABLE
+	}
+
+	return models.STATUS_OUT_OF_STOCK
+}
+
+func matchISBN(isbn string) func(models.Book) bool {
+	return func(book models.Book) bool {
+		return book.ISBN != nil && *book.ISBN == isbn
+	}
+}
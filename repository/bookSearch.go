@@ -0,0 +1,406 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrInvalidSort = errors.New("sort must be one of relevance, title, author, created_at or qty, optionally prefixed with -")
+
+// BookQuery describes a catalog search. Text is matched against the title,
+// authors, subjects, series and description through the books text index;
+// zero fields match every book.
+type BookQuery struct {
+	Text      string
+	Author    string // Exact author name, ignoring case
+	Status    string
+	Subject   string // Exact subject, ignoring case
+	Language  string
+	Publisher string // Exact publisher name, ignoring case
+	// Sort is relevance, title, author, created_at or qty, prefixed with - for
+	// descending order. Relevance is the default when Text is set, insertion
+	// order otherwise.
+	Sort string
+}
+
+type FacetCount struct {
+	Value string `bson:"_id" json:"value"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// BookFacets count the books matching a search by author and by status. Each
+// facet leaves out its own filter so that the other values stay visible.
+type BookFacets struct {
+	Authors  []FacetCount `bson:"authors" json:"authors"`
+	Statuses []FacetCount `bson:"statuses" json:"statuses"`
+}
+
+type BookSearchResult struct {
+	Paged[models.Book]
+	Facets BookFacets
+}
+
+// scoredBook carries the text score computed for a book by a search.
+type scoredBook struct {
+	models.Book `bson:",inline"`
+	Score       float64 `bson:"score"`
+}
+
+// sortKey is the value of the sort field for the book.
+func (b scoredBook) sortKey(field string) interface{} {
+	switch field {
+	case "score":
+		return b.Score
+	case "title":
+		return b.Title
+	case "author":
+		return b.Author
+	case "created_at":
+		return b.CreatedAt
+	case "qty":
+		return b.Qty
+	}
+
+	return nil
+}
+
+// sortSpec splits the Sort option into the document field and direction.
+func (q BookQuery) sortSpec() (field string, order int, err error) {
+	field, order = strings.TrimPrefix(q.Sort, "-"), 1
+	if strings.HasPrefix(q.Sort, "-") {
+		order = -1
+	}
+
+	switch field {
+	case "":
+		if q.Text != "" {
+			return "score", -1, nil
+		}
+		return "_id", 1, nil
+	case "relevance":
+		// best matches first whichever way it is asked for
+		return "score", -1, nil
+	case "title", "author", "created_at", "qty":
+		return field, order, nil
+	}
+
+	return "", 0, ErrInvalidSort
+}
+
+func (q BookQuery) authorFilter() bson.M {
+	if q.Author == "" {
+		return bson.M{}
+	}
+
+	return bson.M{"author": exactIgnoringCase(q.Author)}
+}
+
+// metadataFilter matches the bibliographic fields, which have no facet.
+func (q BookQuery) metadataFilter() bson.M {
+	filter := bson.M{}
+	if q.Subject != "" {
+		filter["subjects"] = exactIgnoringCase(q.Subject)
+	}
+
+	if q.Language != "" {
+		filter["language"] = q.Language
+	}
+
+	if q.Publisher != "" {
+		filter["publisher"] = exactIgnoringCase(q.Publisher)
+	}
+
+	return filter
+}
+
+func exactIgnoringCase(value string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(value) + "$", Options: "i"}
+}
+
+func (q BookQuery) statusFilter() bson.M {
+	if q.Status == "" {
+		return bson.M{}
+	}
+
+	return bson.M{"status": q.Status}
+}
+
+// Search reads the page with its own query, which can walk the books indexes
+// and only sorts as many books as the page holds, and counts the total and the
+// facets with a second one.
+func (r *mongoBookRepository) Search(ctx context.Context, query BookQuery, page Page) (*BookSearchResult, error) {
+	field, order, err := query.sortSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+
+	// $text has to stay at the top of the first $match
+	matched := query.metadataFilter()
+	if query.Text != "" {
+		matched["$text"] = bson.M{"$search": query.Text}
+	}
+
+	filters := bson.A{query.authorFilter(), query.statusFilter()}
+
+	books, err := r.searchPage(ctx, query, matched, filters, field, order, after, page.limit())
+	if err != nil {
+		return nil, err
+	}
+
+	total, facets, err := r.searchFacets(ctx, query, matched, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	scored := pageOf(books, page.limit(), total, func(book scoredBook) string {
+		return encodeCursor(book.sortKey(field), book.ID)
+	})
+
+	return searchResult(scored, facets), nil
+}
+
+// searchPage reads up to limit+1 books after the cursor in sort order.
+func (r *mongoBookRepository) searchPage(ctx context.Context, query BookQuery, matched bson.M, filters bson.A, field string, order int, after *pageCursor, limit int) ([]scoredBook, error) {
+	// the text score only exists once computed, so the cursor on it is
+	// matched afterwards
+	if after != nil && field != "score" {
+		filters = append(filters, afterCursor(field, order, after))
+	}
+
+	match := bson.M{"$and": filters}
+	for key, value := range matched {
+		match[key] = value
+	}
+
+	pipeline := mongo.Pipeline{bson.D{{Key: "$match", Value: match}}}
+	if query.Text != "" {
+		pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}})
+	}
+
+	if after != nil && field == "score" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: afterCursor(field, order, after)}})
+	}
+
+	sort := bson.D{{Key: "_id", Value: 1}}
+	if field != "_id" {
+		sort = append(bson.D{{Key: field, Value: order}}, sort...)
+	}
+
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: sort}},
+		bson.D{{Key: "$limit", Value: limit + 1}},
+	)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []scoredBook{}
+	err = cursor.All(ctx, &books)
+	return books, err
+}
+
+// searchFacets counts the books matching the whole search, and the facets.
+func (r *mongoBookRepository) searchFacets(ctx context.Context, query BookQuery, matched bson.M, filters bson.A) (int64, BookFacets, error) {
+	pipeline := mongo.Pipeline{
+		bson.D{{Key: "$match", Value: matched}},
+		bson.D{{Key: "$facet", Value: bson.M{
+			"total":    bson.A{bson.M{"$match": bson.M{"$and": filters}}, bson.M{"$count": "count"}},
+			"authors":  facetStages("author", query.statusFilter()),
+			"statuses": facetStages("status", query.authorFilter()),
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, BookFacets{}, err
+	}
+
+	// $facet always yields exactly one document
+	var results []struct {
+		BookFacets `bson:",inline"`
+		Total      []struct {
+			Count int64 `bson:"count"`
+		} `bson:"total"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return 0, BookFacets{}, err
+	}
+
+	var total int64
+	if len(results[0].Total) > 0 {
+		total = results[0].Total[0].Count
+	}
+
+	return total, results[0].BookFacets, nil
+}
+
+// searchResult drops the text scores from a page of books.
+func searchResult(scored *Paged[scoredBook], facets BookFacets) *BookSearchResult {
+	result := BookSearchResult{
+		Paged:  Paged[models.Book]{Items: []models.Book{}, NextCursor: scored.NextCursor, Total: scored.Total},
+		Facets: facets,
+	}
+
+	for _, book := range scored.Items {
+		result.Items = append(result.Items, book.Book)
+	}
+
+	if result.Facets.Authors == nil {
+		result.Facets.Authors = []FacetCount{}
+	}
+
+	if result.Facets.Statuses == nil {
+		result.Facets.Statuses = []FacetCount{}
+	}
+
+	return &result
+}
+
+// facetStages counts the books passing filter by the values of field, most
+// common first.
+func facetStages(field string, filter bson.M) bson.A {
+	return bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$group": bson.M{"_id": "$" + field, "count": bson.M{"$sum": 1}}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+}
+
+// Search approximates the MongoDB text search: a book matches when any word of
+// the query appears in its title, authors, subjects, series or description,
+// and title hits weigh more.
+func (r *memoryBookRepository) Search(ctx context.Context, query BookQuery, page Page) (*BookSearchResult, error) {
+	field, order, err := query.sortSpec()
+	if err != nil {
+		return nil, err
+	}
+
+	r.s.mu.RLock()
+	books := r.s.books.find(nil)
+	r.s.mu.RUnlock()
+
+	terms := strings.Fields(strings.ToLower(query.Text))
+
+	matched := []scoredBook{}
+	for _, book := range books {
+		if !query.matchMetadata(book) {
+			continue
+		}
+
+		text := strings.ToLower(strings.Join(bookText(book), " "))
+
+		score := 0
+		for _, term := range terms {
+			score += 2*strings.Count(strings.ToLower(deref(book.Title)), term) + strings.Count(text, term)
+		}
+
+		if len(terms) > 0 && score == 0 {
+			continue
+		}
+
+		matched = append(matched, scoredBook{Book: book, Score: float64(score)})
+	}
+
+	byAuthor := func(book scoredBook) bool {
+		return query.Author == "" || strings.EqualFold(deref(book.Author), query.Author)
+	}
+	byStatus := func(book scoredBook) bool {
+		return query.Status == "" || deref(book.Status) == query.Status
+	}
+
+	facets := BookFacets{
+		Authors:  countFacet(matched, byStatus, func(book scoredBook) string { return deref(book.Author) }),
+		Statuses: countFacet(matched, byAuthor, func(book scoredBook) string { return deref(book.Status) }),
+	}
+
+	filtered := []scoredBook{}
+	for _, book := range matched {
+		if byAuthor(book) && byStatus(book) {
+			filtered = append(filtered, book)
+		}
+	}
+
+	key := func(book scoredBook) interface{} { return book.sortKey(field) }
+	slices.SortStableFunc(filtered, func(a, b scoredBook) int {
+		return order * compareSortValues(key(a), key(b))
+	})
+
+	scored, err := slicePage(filtered, page, func(book scoredBook) primitive.ObjectID { return book.ID }, key, order)
+	if err != nil {
+		return nil, err
+	}
+
+	return searchResult(scored, facets), nil
+}
+
+func (q BookQuery) matchMetadata(book models.Book) bool {
+	if q.Subject != "" && !slices.ContainsFunc(book.Subjects, func(subject string) bool { return strings.EqualFold(subject, q.Subject) }) {
+		return false
+	}
+
+	if q.Language != "" && deref(book.Language) != q.Language {
+		return false
+	}
+
+	return q.Publisher == "" || strings.EqualFold(deref(book.Publisher), q.Publisher)
+}
+
+// bookText lists the fields besides the title that the text index covers.
+func bookText(book models.Book) []string {
+	text := []string{deref(book.Author), deref(book.Description)}
+	for _, contributor := range book.Contributors {
+		text = append(text, deref(contributor.Name))
+	}
+
+	text = append(text, book.Subjects...)
+	if book.Series != nil {
+		text = append(text, deref(book.Series.Title))
+	}
+
+	return text
+}
+
+func countFacet(books []scoredBook, match func(scoredBook) bool, value func(scoredBook) string) []FacetCount {
+	counts := map[string]int64{}
+	for _, book := range books {
+		if match(book) {
+			counts[value(book)]++
+		}
+	}
+
+	facets := []FacetCount{}
+	for value, count := range counts {
+		facets = append(facets, FacetCount{Value: value, Count: count})
+	}
+
+	slices.SortFunc(facets, func(a, b FacetCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Value, b.Value))
+	})
+
+	return facets
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+
+	return *s
+}
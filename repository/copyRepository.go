@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CopyFilter narrows List down; zero fields match every copy.
+type CopyFilter struct {
+	BookID   primitive.ObjectID
+	Statuses []string
+}
+
+type CopyRepository interface {
+	List(ctx context.Context, filter CopyFilter) ([]models.Copy, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Copy, error)
+	FindByBarcode(ctx context.Context, barcode string) (*models.Copy, error)
+	CountByBarcode(ctx context.Context, barcode string) (int64, error)
+	// CountAvailable counts the copies of the book that are on the shelf.
+	CountAvailable(ctx context.Context, bookID primitive.ObjectID) (int, error)
+	// Insert returns ErrDuplicate if the barcode is taken.
+	Insert(ctx context.Context, item *models.Copy) error
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	DeleteByBook(ctx context.Context, bookID primitive.ObjectID) error
+	// Take switches one AVAILABLE copy of the book to status and returns it,
+	// or ErrOutOfStock if there is none. A non-empty barcode asks for that
+	// particular copy.
+	Take(ctx context.Context, bookID primitive.ObjectID, barcode, status string, at time.Time) (*models.Copy, error)
+}
+
+func (f CopyFilter) query() bson.M {
+	query := bson.M{}
+	if !f.BookID.IsZero() {
+		query["book_id"] = f.BookID
+	}
+
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+
+	return query
+}
+
+func (f CopyFilter) match(item models.Copy) bool {
+	if !f.BookID.IsZero() && item.BookID != f.BookID {
+		return false
+	}
+
+	return len(f.Statuses) == 0 || slices.Contains(f.Statuses, item.Status)
+}
+
+type mongoCopyRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoCopyRepository) List(ctx context.Context, filter CopyFilter) ([]models.Copy, error) {
+	return findAll[models.Copy](ctx, r.collection, filter.query())
+}
+
+func (r *mongoCopyRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Copy, error) {
+	return findOne[models.Copy](ctx, r.collection, bson.M{"_id": id})
+}
+
+func (r *mongoCopyRepository) FindByBarcode(ctx context.Context, barcode string) (*models.Copy, error) {
+	return findOne[models.Copy](ctx, r.collection, bson.M{"barcode": barcode})
+}
+
+func (r *mongoCopyRepository) CountByBarcode(ctx context.Context, barcode string) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"barcode": barcode})
+}
+
+func (r *mongoCopyRepository) CountAvailable(ctx context.Context, bookID primitive.ObjectID) (int, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"book_id": bookID, "status": models.STATUS_AVAILABLE})
+	return int(count), err
+}
+
+func (r *mongoCopyRepository) Insert(ctx context.Context, item *models.Copy) error {
+	if item.ID.IsZero() {
+		item.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, item)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+
+	return err
+}
+
+func (r *mongoCopyRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": bson.M{"$eq": id}}, bson.M{"$set": set})
+	return err
+}
+
+func (r *mongoCopyRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": bson.M{"$eq": id}})
+	return err
+}
+
+func (r *mongoCopyRepository) DeleteByBook(ctx context.Context, bookID primitive.ObjectID) error {
+	_, err := r.collection.DeleteMany(ctx, bson.M{"book_id": bson.M{"$eq": bookID}})
+	return err
+}
+
+// Take flips the status with a conditional update so that two members racing
+// for the last copy cannot both get it.
+func (r *mongoCopyRepository) Take(ctx context.Context, bookID primitive.ObjectID, barcode, status string, at time.Time) (*models.Copy, error) {
+	var item models.Copy
+	filter := bson.M{"book_id": bookID, "status": models.STATUS_AVAILABLE}
+	if barcode != "" {
+		filter["barcode"] = barcode
+	}
+
+	update := bson.M{"$set": bson.M{"status": status, "updated_at": at}}
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "_id", Value: 1}}).SetReturnDocument(options.After)
+
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&item)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrOutOfStock
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+type memoryCopyRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryCopyRepository) List(ctx context.Context, filter CopyFilter) ([]models.Copy, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.copies.find(filter.match), nil
+}
+
+func (r *memoryCopyRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Copy, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.copies.get(id)
+}
+
+func (r *memoryCopyRepository) FindByBarcode(ctx context.Context, barcode string) (*models.Copy, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, item, err := r.s.copies.findOne(matchBarcode(barcode))
+	return item, err
+}
+
+func (r *memoryCopyRepository) CountByBarcode(ctx context.Context, barcode string) (int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return int64(len(r.s.copies.find(matchBarcode(barcode)))), nil
+}
+
+func (r *memoryCopyRepository) CountAvailable(ctx context.Context, bookID primitive.ObjectID) (int, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return len(r.s.copies.find(CopyFilter{BookID: bookID, Statuses: []string{models.STATUS_AVAILABLE}}.match)), nil
+}
+
+func (r *memoryCopyRepository) Insert(ctx context.Context, item *models.Copy) error {
+	defer r.s.lock(ctx)()
+
+	if item.Barcode != nil && len(r.s.copies.find(matchBarcode(*item.Barcode))) > 0 {
+		return ErrDuplicate
+	}
+
+	if item.ID.IsZero() {
+		item.ID = primitive.NewObjectID()
+	}
+
+	r.s.copies.put(item.ID, *item)
+	return nil
+}
+
+func (r *memoryCopyRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	defer r.s.lock(ctx)()
+
+	item, err := r.s.copies.get(id)
+	if err != nil {
+		return nil
+	}
+
+	updated, err := applySet(*item, set)
+	if err != nil {
+		return err
+	}
+
+	r.s.copies.put(id, updated)
+	return nil
+}
+
+func (r *memoryCopyRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+
+	r.s.copies.delete(id)
+	return nil
+}
+
+func (r *memoryCopyRepository) DeleteByBook(ctx context.Context, bookID primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+
+	for _, item := range r.s.copies.find(CopyFilter{BookID: bookID}.match) {
+		r.s.copies.delete(item.ID)
+	}
+
+	return nil
+}
+
+func (r *memoryCopyRepository) Take(ctx context.Context, bookID primitive.ObjectID, barcode, status string, at time.Time) (*models.Copy, error) {
+	defer r.s.lock(ctx)()
+
+	available := CopyFilter{BookID: bookID, Statuses: []string{models.STATUS_AVAILABLE}}.match
+	id, item, err := r.s.copies.findOne(func(item models.Copy) bool {
+		return available(item) && (barcode == "" || matchBarcode(barcode)(item))
+	})
+	if err != nil {
+		return nil, ErrOutOfStock
+	}
+
+	item.Status = status
+	item.UpdatedAt = at
+
+	r.s.copies.put(id, *item)
+	return item, nil
+}
+
+func matchBarcode(barcode string) func(models.Copy) bool {
+	return func(item models.Copy) bool {
+		return item.Barcode != nil && *item.Barcode == barcode
+	}
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryCopyInsertRejectsTakenBarcode(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	barcode, bookID := "9780131103627-001", primitive.NewObjectID()
+	if err := store.Copies().Insert(ctx, &models.Copy{BookID: bookID, Barcode: &barcode}); err != nil {
+		t.Fatalf("first insert: %v", err)
+	}
+	if err := store.Copies().Insert(ctx, &models.Copy{BookID: bookID, Barcode: &barcode}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("second insert: %v, want ErrDuplicate", err)
+	}
+}
+
+func TestMemoryCopyTakeGivesTheLastCopyOnce(t *testing.T) {
+	const takers = 20
+
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	barcode, bookID := "9780131103627-001", primitive.NewObjectID()
+	if err := store.Copies().Insert(ctx, &models.Copy{BookID: bookID, Barcode: &barcode, Status: models.STATUS_AVAILABLE}); err != nil {
+		t.Fatal(err)
+	}
+
+	// outside a transaction, so only Take itself keeps the copy from being
+	// handed out twice; the Mongo Take gets that from its conditional update
+	errs := make([]error, takers)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			_, errs[i] = store.Copies().Take(ctx, bookID, "", models.STATUS_BORROWED, time.Now())
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	taken := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			taken++
+		case errors.Is(err, ErrOutOfStock):
+		default:
+			t.Errorf("taker %d: unexpected error %v", i, err)
+		}
+	}
+	if taken != 1 {
+		t.Errorf("%d callers took the only copy, want 1", taken)
+	}
+}
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"slices"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FineFilter narrows List down; zero fields match every fine.
+type FineFilter struct {
+	UserID   primitive.ObjectID
+	Statuses []string
+}
+
+type FineRepository interface {
+	List(ctx context.Context, filter FineFilter) ([]models.Fine, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Fine, error)
+	Insert(ctx context.Context, fine *models.Fine) error
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	// OutstandingBalance sums what the member still owes across unpaid fines.
+	OutstandingBalance(ctx context.Context, userID primitive.ObjectID) (int64, error)
+}
+
+func (f FineFilter) query() bson.M {
+	query := bson.M{}
+	if !f.UserID.IsZero() {
+		query["user_id"] = f.UserID
+	}
+
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+
+	return query
+}
+
+func (f FineFilter) match(fine models.Fine) bool {
+	if !f.UserID.IsZero() && fine.UserID != f.UserID {
+		return false
+	}
+
+	return len(f.Statuses) == 0 || slices.Contains(f.Statuses, fine.Status)
+}
+
+type mongoFineRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoFineRepository) List(ctx context.Context, filter FineFilter) ([]models.Fine, error) {
+	return findAll[models.Fine](ctx, r.collection, filter.query())
+}
+
+func (r *mongoFineRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Fine, error) {
+	return findOne[models.Fine](ctx, r.collection, bson.M{"_id": id})
+}
+
+func (r *mongoFineRepository) Insert(ctx context.Context, fine *models.Fine) error {
+	if fine.ID.IsZero() {
+		fine.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, fine)
+	return err
+}
+
+func (r *mongoFineRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": bson.M{"$eq": id}}, bson.M{"$set": set})
+	return err
+}
+
+func (r *mongoFineRepository) OutstandingBalance(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID, "status": models.STATUS_UNPAID}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"balance": bson.M{"$sum": bson.M{"$subtract": bson.A{"$amount", "$paid"}}},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	var result []struct {
+		Balance int64 `bson:"balance"`
+	}
+	if err = cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	return result[0].Balance, nil
+}
+
+type memoryFineRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryFineRepository) List(ctx context.Context, filter FineFilter) ([]models.Fine, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.fines.find(filter.match), nil
+}
+
+func (r *memoryFineRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Fine, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.fines.get(id)
+}
+
+func (r *memoryFineRepository) Insert(ctx context.Context, fine *models.Fine) error {
+	defer r.s.lock(ctx)()
+
+	if fine.ID.IsZero() {
+		fine.ID = primitive.NewObjectID()
+	}
+
+	r.s.fines.put(fine.ID, *fine)
+	return nil
+}
+
+func (r *memoryFineRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	defer r.s.lock(ctx)()
+
+	fine, err := r.s.fines.get(id)
+	if err != nil {
+		return nil
+	}
+
+	updated, err := applySet(*fine, set)
+	if err != nil {
+		return err
+	}
+
+	r.s.fines.put(id, updated)
+	return nil
+}
+
+func (r *memoryFineRepository) OutstandingBalance(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	var balance int64
+	for _, fine := range r.s.fines.find(FineFilter{UserID: userID}.match) {
+		balance += fine.Outstanding()
+	}
+
+	return balance, nil
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HoldFilter narrows List down; zero fields match every hold.
+type HoldFilter struct {
+	UserID   primitive.ObjectID
+	BookID   primitive.ObjectID
+	Statuses []string
+}
+
+type HoldRepository interface {
+	// List returns the matching holds in queue order, oldest first.
+	List(ctx context.Context, filter HoldFilter) ([]models.Hold, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Hold, error)
+	Insert(ctx context.Context, hold *models.Hold) error
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	// ExpireReady marks every READY hold whose pickup window closed before now
+	// as EXPIRED and returns them as they were before expiring.
+	ExpireReady(ctx context.Context, now time.Time) ([]models.Hold, error)
+}
+
+// ActiveHoldStatuses are the statuses of holds still queued or waiting for pickup.
+var ActiveHoldStatuses = []string{models.STATUS_WAITING, models.STATUS_READY}
+
+func (f HoldFilter) query() bson.M {
+	query := bson.M{}
+	if !f.UserID.IsZero() {
+		query["user_id"] = f.UserID
+	}
+
+	if !f.BookID.IsZero() {
+		query["book_id"] = f.BookID
+	}
+
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+
+	return query
+}
+
+func (f HoldFilter) match(hold models.Hold) bool {
+	if !f.UserID.IsZero() && hold.UserID != f.UserID {
+		return false
+	}
+
+	if !f.BookID.IsZero() && hold.BookID != f.BookID {
+		return false
+	}
+
+	return len(f.Statuses) == 0 || slices.Contains(f.Statuses, hold.Status)
+}
+
+type mongoHoldRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoHoldRepository) List(ctx context.Context, filter HoldFilter) ([]models.Hold, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	return findAll[models.Hold](ctx, r.collection, filter.query(), opts)
+}
+
+func (r *mongoHoldRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Hold, error) {
+	return findOne[models.Hold](ctx, r.collection, bson.M{"_id": id})
+}
+
+func (r *mongoHoldRepository) Insert(ctx context.Context, hold *models.Hold) error {
+	if hold.ID.IsZero() {
+		hold.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, hold)
+	return err
+}
+
+func (r *mongoHoldRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": bson.M{"$eq": id}}, bson.M{"$set": set})
+	return err
+}
+
+func (r *mongoHoldRepository) ExpireReady(ctx context.Context, now time.Time) ([]models.Hold, error) {
+	filter := bson.M{"status": models.STATUS_READY, "expires_at": bson.M{"$lt": now}}
+
+	holds, err := findAll[models.Hold](ctx, r.collection, filter)
+	if err != nil || len(holds) == 0 {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(holds))
+	for _, hold := range holds {
+		ids = append(ids, hold.ID)
+	}
+
+	update := bson.M{"$set": bson.M{"status": models.STATUS_EXPIRED, "updated_at": now}}
+	_, err = r.collection.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}, "status": models.STATUS_READY}, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return holds, nil
+}
+
+type memoryHoldRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryHoldRepository) List(ctx context.Context, filter HoldFilter) ([]models.Hold, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	holds := r.s.holds.find(filter.match)
+	slices.SortStableFunc(holds, func(a, b models.Hold) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return holds, nil
+}
+
+func (r *memoryHoldRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Hold, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.holds.get(id)
+}
+
+func (r *memoryHoldRepository) Insert(ctx context.Context, hold *models.Hold) error {
+	defer r.s.lock(ctx)()
+
+	if hold.ID.IsZero() {
+		hold.ID = primitive.NewObjectID()
+	}
+
+	r.s.holds.put(hold.ID, *hold)
+	return nil
+}
+
+func (r *memoryHoldRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	defer r.s.lock(ctx)()
+
+	hold, err := r.s.holds.get(id)
+	if err != nil {
+		return nil
+	}
+
+	updated, err := applySet(*hold, set)
+	if err != nil {
+		return err
+	}
+
+	r.s.holds.put(id, updated)
+	return nil
+}
+
+func (r *memoryHoldRepository) ExpireReady(ctx context.Context, now time.Time) ([]models.Hold, error) {
+	defer r.s.lock(ctx)()
+
+	expired := []models.Hold{}
+	for _, hold := range r.s.holds.find(HoldFilter{Statuses: []string{models.STATUS_READY}}.match) {
+		if !hold.ExpiresAt.Before(now) {
+			continue
+		}
+
+		expired = append(expired, hold)
+
+		hold.Status = models.STATUS_EXPIRED
+		hold.UpdatedAt = now
+		r.s.holds.put(hold.ID, hold)
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type InvitationRepository interface {
+	// List returns every invitation, used or not, oldest first.
+	List(ctx context.Context) ([]models.Invitation, error)
+	Insert(ctx context.Context, invitation *models.Invitation) error
+	// Redeem marks the unused, unexpired invitation whose code hashes to hash
+	// as used by userId and returns it, or ErrNotFound if there is none.
+	Redeem(ctx context.Context, hash string, userId primitive.ObjectID, at time.Time) (*models.Invitation, error)
+	// Delete removes the invitation, returning ErrNotFound if there was none.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoInvitationRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoInvitationRepository) List(ctx context.Context) ([]models.Invitation, error) {
+	return findAll[models.Invitation](ctx, r.collection, bson.M{})
+}
+
+func (r *mongoInvitationRepository) Insert(ctx context.Context, invitation *models.Invitation) error {
+	if invitation.ID.IsZero() {
+		invitation.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, invitation)
+	return err
+}
+
+func (r *mongoInvitationRepository) Redeem(ctx context.Context, hash string, userId primitive.ObjectID, at time.Time) (*models.Invitation, error) {
+	var invitation models.Invitation
+	filter := bson.M{"code_hash": hash, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": at}}
+	update := bson.M{"$set": bson.M{"used_by": userId, "used_at": at}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&invitation)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+func (r *mongoInvitationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type memoryInvitationRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryInvitationRepository) List(ctx context.Context) ([]models.Invitation, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.invitations.find(nil), nil
+}
+
+func (r *memoryInvitationRepository) Insert(ctx context.Context, invitation *models.Invitation) error {
+	defer r.s.lock(ctx)()
+
+	if invitation.ID.IsZero() {
+		invitation.ID = primitive.NewObjectID()
+	}
+
+	r.s.invitations.put(invitation.ID, *invitation)
+	return nil
+}
+
+func (r *memoryInvitationRepository) Redeem(ctx context.Context, hash string, userId primitive.ObjectID, at time.Time) (*models.Invitation, error) {
+	defer r.s.lock(ctx)()
+
+	id, invitation, err := r.s.invitations.findOne(func(invitation models.Invitation) bool {
+		return invitation.CodeHash == hash && invitation.UsedAt == nil && at.Before(invitation.ExpiresAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.UsedBy = &userId
+	invitation.UsedAt = &at
+
+	r.s.invitations.put(id, *invitation)
+	return invitation, nil
+}
+
+func (r *memoryInvitationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+
+	if _, err := r.s.invitations.get(id); err != nil {
+		return err
+	}
+
+	r.s.invitations.delete(id)
+	return nil
+}
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoanFilter narrows List down; zero fields match every loan.
+type LoanFilter struct {
+	UserID   primitive.ObjectID
+	BookID   primitive.ObjectID
+	CopyID   primitive.ObjectID
+	Statuses []string
+}
+
+type LoanRepository interface {
+	List(ctx context.Context, filter LoanFilter) ([]models.BorrowHistory, error)
+	// ListPage returns one page of the matching loans, oldest first.
+	ListPage(ctx context.Context, filter LoanFilter, page Page) (*Paged[models.BorrowHistory], error)
+	Insert(ctx context.Context, loan *models.BorrowHistory) error
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	// MarkOverdue flags every borrowed loan due before now as OVERDUE.
+	MarkOverdue(ctx context.Context, now time.Time) error
+	// Close marks the first open loan matching filter as returned and returns
+	// it, or ErrNotFound if there is no such loan.
+	Close(ctx context.Context, filter LoanFilter, at time.Time) (*models.BorrowHistory, error)
+}
+
+// ActiveLoanStatuses are the statuses of loans that still hold a copy of the
+// book, whether or not they are late.
+var ActiveLoanStatuses = []string{models.STATUS_BORROWED, models.STATUS_OVERDUE}
+
+type mongoLoanRepository struct {
+	collection *mongo.Collection
+}
+
+func (f LoanFilter) query() bson.M {
+	query := bson.M{}
+	if !f.UserID.IsZero() {
+		query["user_id"] = f.UserID
+	}
+
+	if !f.BookID.IsZero() {
+		query["book_id"] = f.BookID
+	}
+
+	if !f.CopyID.IsZero() {
+		query["copy_id"] = f.CopyID
+	}
+
+	if len(f.Statuses) > 0 {
+		query["status"] = bson.M{"$in": f.Statuses}
+	}
+
+	return query
+}
+
+func (f LoanFilter) match(loan models.BorrowHistory) bool {
+	if !f.UserID.IsZero() && loan.UserID != f.UserID {
+		return false
+	}
+
+	if !f.BookID.IsZero() && loan.BookID != f.BookID {
+		return false
+	}
+
+	if !f.CopyID.IsZero() && (loan.CopyID == nil || *loan.CopyID != f.CopyID) {
+		return false
+	}
+
+	return len(f.Statuses) == 0 || slices.Contains(f.Statuses, loan.Status)
+}
+
+func (r *mongoLoanRepository) List(ctx context.Context, filter LoanFilter) ([]models.BorrowHistory, error) {
+	return findAll[models.BorrowHistory](ctx, r.collection, filter.query())
+}
+
+func (r *mongoLoanRepository) ListPage(ctx context.Context, filter LoanFilter, page Page) (*Paged[models.BorrowHistory], error) {
+	return findPage(ctx, r.collection, filter.query(), page, loanID)
+}
+
+func (r *mongoLoanRepository) Insert(ctx context.Context, loan *models.BorrowHistory) error {
+	if loan.ID.IsZero() {
+		loan.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, loan)
+	return err
+}
+
+func (r *mongoLoanRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": bson.M{"$eq": id}}, bson.M{"$set": set})
+	return err
+}
+
+func (r *mongoLoanRepository) MarkOverdue(ctx context.Context, now time.Time) error {
+	filter := bson.M{"status": models.STATUS_BORROWED, "due_at": bson.M{"$lt": now}}
+	update := bson.M{"$set": bson.M{"status": models.STATUS_OVERDUE}}
+
+	_, err := r.collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+func (r *mongoLoanRepository) Close(ctx context.Context, filter LoanFilter, at time.Time) (*models.BorrowHistory, error) {
+	var loan models.BorrowHistory
+	filter.Statuses = ActiveLoanStatuses
+	update := bson.M{"$set": bson.M{"status": models.STATUS_RETURNED, "returned_at": at}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collection.FindOneAndUpdate(ctx, filter.query(), update, opts).Decode(&loan)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &loan, nil
+}
+
+type memoryLoanRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryLoanRepository) List(ctx context.Context, filter LoanFilter) ([]models.BorrowHistory, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.loans.find(filter.match), nil
+}
+
+func (r *memoryLoanRepository) ListPage(ctx context.Context, filter LoanFilter, page Page) (*Paged[models.BorrowHistory], error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return slicePage(r.s.loans.find(filter.match), page, loanID, nil, 1)
+}
+
+func (r *memoryLoanRepository) Insert(ctx context.Context, loan *models.BorrowHistory) error {
+	defer r.s.lock(ctx)()
+
+	if loan.ID.IsZero() {
+		loan.ID = primitive.NewObjectID()
+	}
+
+	r.s.loans.put(loan.ID, *loan)
+	return nil
+}
+
+func (r *memoryLoanRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	defer r.s.lock(ctx)()
+
+	loan, err := r.s.loans.get(id)
+	if err != nil {
+		return nil
+	}
+
+	updated, err := applySet(*loan, set)
+	if err != nil {
+		return err
+	}
+
+	r.s.loans.put(id, updated)
+	return nil
+}
+
+func (r *memoryLoanRepository) MarkOverdue(ctx context.Context, now time.Time) error {
+	defer r.s.lock(ctx)()
+
+	for _, loan := range r.s.loans.find(nil) {
+		if loan.Status == models.STATUS_BORROWED && !loan.DueAt.IsZero() && loan.DueAt.Before(now) {
+			loan.Status = models.STATUS_OVERDUE
+			r.s.loans.put(loan.ID, loan)
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryLoanRepository) Close(ctx context.Context, filter LoanFilter, at time.Time) (*models.BorrowHistory, error) {
+	defer r.s.lock(ctx)()
+
+	filter.Statuses = ActiveLoanStatuses
+	id, loan, err := r.s.loans.findOne(filter.match)
+	if err != nil {
+		return nil, err
+	}
+
+	loan.Status = models.STATUS_RETURNED
+	loan.ReturnedAt = at
+
+	r.s.loans.put(id, *loan)
+	return loan, nil
+}
+
+func loanID(loan models.BorrowHistory) primitive.ObjectID { return loan.ID }
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type LoginAttemptRepository interface {
+	FindByKey(ctx context.Context, key string) (*models.LoginAttempt, error)
+	// RecordFailure counts a failed login for key, starting from one again if
+	// the last failure was before windowStart, and returns the record as it
+	// was before, nil if there was none, and after.
+	RecordFailure(ctx context.Context, key string, at, windowStart time.Time) (before, after *models.LoginAttempt, err error)
+	// Forgive takes back a failure RecordFailure counted, turning the record
+	// it left as after back into before. If more failures were counted since,
+	// only the one is taken back.
+	Forgive(ctx context.Context, key string, before, after *models.LoginAttempt) error
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Clear forgets every failure of key.
+	Clear(ctx context.Context, key string) error
+}
+
+type mongoLoginAttemptRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoLoginAttemptRepository) FindByKey(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	return findOne[models.LoginAttempt](ctx, r.collection, bson.M{"key": key})
+}
+
+func (r *mongoLoginAttemptRepository) RecordFailure(ctx context.Context, key string, at, windowStart time.Time) (*models.LoginAttempt, *models.LoginAttempt, error) {
+	var before models.LoginAttempt
+
+	// an update pipeline, so that counting starts over in the same atomic step
+	recent := bson.M{"$gte": bson.A{"$last_failure_at", windowStart}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"failures":        bson.M{"$cond": bson.A{recent, bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}}, 1}},
+		"last_failure_at": at,
+		"locked_until":    bson.M{"$cond": bson.A{recent, "$locked_until", "$$REMOVE"}},
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	err := r.collection.FindOneAndUpdate(ctx, bson.M{"key": key}, update, opts).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		return nil, failedAfter(nil, key, at, windowStart), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &before, failedAfter(&before, key, at, windowStart), nil
+}
+
+func (r *mongoLoginAttemptRepository) Forgive(ctx context.Context, key string, before, after *models.LoginAttempt) error {
+	// the time of the failure goes back too, or else it would keep moving the
+	// delay and the window along
+	untouched := bson.M{"key": key, "failures": after.Failures, "last_failure_at": after.LastFailureAt}
+
+	var restored int64
+	if before == nil {
+		result, err := r.collection.DeleteOne(ctx, untouched)
+		if err != nil {
+			return err
+		}
+		restored = result.DeletedCount
+	} else {
+		set := bson.M{"failures": before.Failures, "last_failure_at": before.LastFailureAt}
+		update := bson.M{"$set": set}
+		if before.LockedUntil != nil {
+			set["locked_until"] = *before.LockedUntil
+		} else {
+			update["$unset"] = bson.M{"locked_until": ""}
+		}
+
+		result, err := r.collection.UpdateOne(ctx, untouched, update)
+		if err != nil {
+			return err
+		}
+		restored = result.MatchedCount
+	}
+
+	if restored > 0 {
+		return nil
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"key": key, "failures": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"failures": -1}})
+	return err
+}
+
+func (r *mongoLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"key": key}, bson.M{"$set": bson.M{"locked_until": until}})
+	return err
+}
+
+func (r *mongoLoginAttemptRepository) Clear(ctx context.Context, key string) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"key": key})
+	return err
+}
+
+type memoryLoginAttemptRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryLoginAttemptRepository) FindByKey(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, attempt, err := r.s.loginAttempts.findOne(matchLoginKey(key))
+	return attempt, err
+}
+
+func (r *memoryLoginAttemptRepository) RecordFailure(ctx context.Context, key string, at, windowStart time.Time) (*models.LoginAttempt, *models.LoginAttempt, error) {
+	defer r.s.lock(ctx)()
+
+	id, before, err := r.s.loginAttempts.findOne(matchLoginKey(key))
+	if err != nil {
+		id, before = primitive.NewObjectID(), nil
+	}
+
+	after := failedAfter(before, key, at, windowStart)
+	after.ID = id
+
+	r.s.loginAttempts.put(id, *after)
+	return before, after, nil
+}
+
+func (r *memoryLoginAttemptRepository) Forgive(ctx context.Context, key string, before, after *models.LoginAttempt) error {
+	defer r.s.lock(ctx)()
+
+	id, attempt, err := r.s.loginAttempts.findOne(matchLoginKey(key))
+	if err != nil {
+		return nil
+	}
+
+	if attempt.Failures == after.Failures && attempt.LastFailureAt.Equal(after.LastFailureAt) {
+		if before == nil {
+			r.s.loginAttempts.delete(id)
+			return nil
+		}
+
+		restored := *before
+		restored.ID = id
+		r.s.loginAttempts.put(id, restored)
+		return nil
+	}
+
+	if attempt.Failures == 0 {
+		return nil
+	}
+
+	attempt.Failures--
+	r.s.loginAttempts.put(id, *attempt)
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	defer r.s.lock(ctx)()
+
+	id, attempt, err := r.s.loginAttempts.findOne(matchLoginKey(key))
+	if err != nil {
+		return nil
+	}
+
+	attempt.LockedUntil = &until
+	r.s.loginAttempts.put(id, *attempt)
+	return nil
+}
+
+func (r *memoryLoginAttemptRepository) Clear(ctx context.Context, key string) error {
+	defer r.s.lock(ctx)()
+
+	if id, _, err := r.s.loginAttempts.findOne(matchLoginKey(key)); err == nil {
+		r.s.loginAttempts.delete(id)
+	}
+
+	return nil
+}
+
+// failedAfter returns what RecordFailure turns before into.
+func failedAfter(before *models.LoginAttempt, key string, at, windowStart time.Time) *models.LoginAttempt {
+	after := models.LoginAttempt{Key: key}
+	if before != nil {
+		after.ID = before.ID
+		if !before.LastFailureAt.Before(windowStart) {
+			after = *before
+		}
+	}
+
+	after.Failures++
+	after.LastFailureAt = at
+
+	return &after
+}
+
+func matchLoginKey(key string) func(models.LoginAttempt) bool {
+	return func(attempt models.LoginAttempt) bool {
+		return attempt.Key == key
+	}
+}
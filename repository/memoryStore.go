@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps every collection in process memory. It is meant for tests
+// and local development, so nothing survives a restart.
+type memoryStore struct {
+	mu     sync.RWMutex // guards every table
+	txMu   sync.Mutex   // serialises transactions and the writes made outside them
+	tables []snapshotter
+
+	books          *memTable[models.Book]
+	users          *memTable[models.User]
+	loans          *memTable[models.BorrowHistory]
+	fines          *memTable[models.Fine]
+	holds          *memTable[models.Hold]
+	copies         *memTable[models.Copy]
+	sessions       *memTable[models.Session]
+	roles          *memTable[models.Role]
+	invitations    *memTable[models.Invitation]
+	passwordResets *memTable[models.PasswordReset]
+	loginAttempts  *memTable[models.LoginAttempt]
+	audit          *memTable[models.AuditEntry]
+	mfaChallenges  *memTable[models.MFAChallenge]
+	signingKeys    *memTable[models.SigningKey]
+	apiKeys        *memTable[models.APIKey]
+	oidcLogins     *memTable[models.OIDCLogin]
+}
+
+// NewMemoryStore returns an empty Store that needs no database.
+func NewMemoryStore() Store {
+	s := &memoryStore{
+		books:          newMemTable[models.Book](),
+		users:          newMemTable[models.User](),
+		loans:          newMemTable[models.BorrowHistory](),
+		fines:          newMemTable[models.Fine](),
+		holds:          newMemTable[models.Hold](),
+		copies:         newMemTable[models.Copy](),
+		sessions:       newMemTable[models.Session](),
+		roles:          newMemTable[models.Role](),
+		invitations:    newMemTable[models.Invitation](),
+		passwordResets: newMemTable[models.PasswordReset](),
+		loginAttempts:  newMemTable[models.LoginAttempt](),
+		audit:          newMemTable[models.AuditEntry](),
+		mfaChallenges:  newMemTable[models.MFAChallenge](),
+		signingKeys:    newMemTable[models.SigningKey](),
+		apiKeys:        newMemTable[models.APIKey](),
+		oidcLogins:     newMemTable[models.OIDCLogin](),
+	}
+	s.tables = []snapshotter{s.books, s.users, s.loans, s.fines, s.holds, s.copies, s.sessions, s.roles, s.invitations, s.passwordResets, s.loginAttempts, s.audit, s.mfaChallenges, s.signingKeys, s.apiKeys, s.oidcLogins}
+
+	return s
+}
+
+func (s *memoryStore) Books() BookRepository             { return &memoryBookRepository{s} }
+func (s *memoryStore) Users() UserRepository             { return &memoryUserRepository{s} }
+func (s *memoryStore) Loans() LoanRepository             { return &memoryLoanRepository{s} }
+func (s *memoryStore) Fines() FineRepository             { return &memoryFineRepository{s} }
+func (s *memoryStore) Holds() HoldRepository             { return &memoryHoldRepository{s} }
+func (s *memoryStore) Copies() CopyRepository            { return &memoryCopyRepository{s} }
+func (s *memoryStore) Sessions() SessionRepository       { return &memorySessionRepository{s} }
+func (s *memoryStore) Roles() RoleRepository             { return &memoryRoleRepository{s} }
+func (s *memoryStore) Invitations() InvitationRepository { return &memoryInvitationRepository{s} }
+func (s *memoryStore) PasswordResets() PasswordResetRepository {
+	return &memoryPasswordResetRepository{s}
+}
+func (s *memoryStore) LoginAttempts() LoginAttemptRepository { return &memoryLoginAttemptRepository{s} }
+func (s *memoryStore) Audit() AuditRepository                { return &memoryAuditRepository{s} }
+func (s *memoryStore) MFAChallenges() MFAChallengeRepository { return &memoryMFAChallengeRepository{s} }
+func (s *memoryStore) SigningKeys() SigningKeyRepository     { return &memorySigningKeyRepository{s} }
+func (s *memoryStore) APIKeys() APIKeyRepository             { return &memoryAPIKeyRepository{s} }
+func (s *memoryStore) OIDCLogins() OIDCLoginRepository       { return &memoryOIDCLoginRepository{s} }
+
+// memoryTxKey marks the context of a running memory transaction.
+type memoryTxKey struct{}
+
+// WithTransaction runs one transaction at a time and rolls every table back
+// to its state before fn if fn returns an error. Writes made outside the
+// transaction wait for it to finish, so the rollback only undoes its own.
+func (s *memoryStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	// a transaction inside a transaction is part of it
+	if ctx.Value(memoryTxKey{}) == s {
+		return fn(ctx)
+	}
+
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	restores := make([]func(), 0, len(s.tables))
+	for _, table := range s.tables {
+		restores = append(restores, table.snapshot())
+	}
+	s.mu.Unlock()
+
+	if err := fn(context.WithValue(ctx, memoryTxKey{}, s)); err != nil {
+		s.mu.Lock()
+		for _, restore := range restores {
+			restore()
+		}
+		s.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// lock takes the table lock for a write made through ctx, together with the
+// transaction lock unless ctx belongs to the running transaction. The
+// returned func releases them.
+func (s *memoryStore) lock(ctx context.Context) (unlock func()) {
+	if ctx.Value(memoryTxKey{}) == s {
+		s.mu.Lock()
+		return s.mu.Unlock
+	}
+
+	s.txMu.Lock()
+	s.mu.Lock()
+	return func() {
+		s.mu.Unlock()
+		s.txMu.Unlock()
+	}
+}
+
+type snapshotter interface {
+	snapshot() (restore func())
+}
+
+// memTable holds the documents of one collection keyed by _id. Rows are
+// treated as immutable values: updates replace the stored row.
+type memTable[T any] struct {
+	rows map[primitive.ObjectID]T
+}
+
+func newMemTable[T any]() *memTable[T] {
+	return &memTable[T]{rows: map[primitive.ObjectID]T{}}
+}
+
+func (t *memTable[T]) snapshot() func() {
+	saved := maps.Clone(t.rows)
+	return func() { t.rows = saved }
+}
+
+func (t *memTable[T]) get(id primitive.ObjectID) (*T, error) {
+	row, ok := t.rows[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &row, nil
+}
+
+func (t *memTable[T]) put(id primitive.ObjectID, row T) {
+	t.rows[id] = row
+}
+
+func (t *memTable[T]) delete(id primitive.ObjectID) {
+	delete(t.rows, id)
+}
+
+// ids returns the keys of the table in insertion (_id) order.
+func (t *memTable[T]) ids() []primitive.ObjectID {
+	return slices.SortedFunc(maps.Keys(t.rows), func(a, b primitive.ObjectID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+}
+
+// find returns the rows matching match in insertion (_id) order.
+func (t *memTable[T]) find(match func(T) bool) []T {
+	rows := []T{}
+	for _, id := range t.ids() {
+		if match == nil || match(t.rows[id]) {
+			rows = append(rows, t.rows[id])
+		}
+	}
+
+	return rows
+}
+
+// findOne returns the first matching row together with its _id.
+func (t *memTable[T]) findOne(match func(T) bool) (primitive.ObjectID, *T, error) {
+	for _, id := range t.ids() {
+		if row := t.rows[id]; match(row) {
+			return id, &row, nil
+		}
+	}
+
+	return primitive.NilObjectID, nil, ErrNotFound
+}
+
+// applySet returns a copy of doc with the $set style fields in set applied,
+// going through BSON so field names match the ones stored in MongoDB.
+func applySet[T any](doc T, set bson.M) (T, error) {
+	var out T
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return out, err
+	}
+
+	fields := bson.M{}
+	if err = bson.Unmarshal(raw, &fields); err != nil {
+		return out, err
+	}
+
+	for key, value := range set {
+		fields[key] = value
+	}
+
+	raw, err = bson.Marshal(fields)
+	if err != nil {
+		return out, err
+	}
+
+	err = bson.Unmarshal(raw, &out)
+	return out, err
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+)
+
+func TestMemoryRollbackKeepsWritesMadeOutside(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	inside, outside := "9780131103627", "9780201633610"
+	started, written := make(chan struct{}), make(chan error)
+	errFailed := errors.New("failed")
+
+	go func() {
+		<-started
+		written <- store.Books().Insert(ctx, &models.Book{ISBN: &outside})
+	}()
+
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Books().Insert(ctx, &models.Book{ISBN: &inside}); err != nil {
+			return err
+		}
+
+		close(started)
+		// give the outside write the chance to land in the middle
+		time.Sleep(20 * time.Millisecond)
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("WithTransaction: %v", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("insert outside the transaction: %v", err)
+	}
+
+	if _, err := store.Books().FindByISBN(ctx, inside); !errors.Is(err, ErrNotFound) {
+		t.Errorf("book inserted by the failed transaction: %v, want ErrNotFound", err)
+	}
+	if _, err := store.Books().FindByISBN(ctx, outside); err != nil {
+		t.Errorf("book inserted outside the transaction: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MFAChallengeRepository interface {
+	Insert(ctx context.Context, challenge *models.MFAChallenge) error
+	// FindActive returns the unused, unexpired challenge whose token hashes
+	// to hash, or ErrNotFound if there is none.
+	FindActive(ctx context.Context, hash string, at time.Time) (*models.MFAChallenge, error)
+	// Fail counts a wrong code against the challenge, using it up once
+	// maxAttempts were made.
+	Fail(ctx context.Context, id primitive.ObjectID, maxAttempts int, at time.Time) error
+	// Redeem marks the challenge as used, returning ErrNotFound if it was
+	// used already.
+	Redeem(ctx context.Context, id primitive.ObjectID, at time.Time) error
+}
+
+type mongoMFAChallengeRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoMFAChallengeRepository) Insert(ctx context.Context, challenge *models.MFAChallenge) error {
+	if challenge.ID.IsZero() {
+		challenge.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, challenge)
+	return err
+}
+
+func (r *mongoMFAChallengeRepository) FindActive(ctx context.Context, hash string, at time.Time) (*models.MFAChallenge, error) {
+	filter := bson.M{"token_hash": hash, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": at}}
+	return findOne[models.MFAChallenge](ctx, r.collection, filter)
+}
+
+func (r *mongoMFAChallengeRepository) Fail(ctx context.Context, id primitive.ObjectID, maxAttempts int, at time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"attempts": 1}})
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": id, "attempts": bson.M{"$gte": maxAttempts}, "used_at": bson.M{"$exists": false}}
+	_, err = r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"used_at": at}})
+	return err
+}
+
+func (r *mongoMFAChallengeRepository) Redeem(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"_id": id, "used_at": bson.M{"$exists": false}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"used_at": at}})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type memoryMFAChallengeRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryMFAChallengeRepository) Insert(ctx context.Context, challenge *models.MFAChallenge) error {
+	defer r.s.lock(ctx)()
+
+	if challenge.ID.IsZero() {
+		challenge.ID = primitive.NewObjectID()
+	}
+
+	r.s.mfaChallenges.put(challenge.ID, *challenge)
+	return nil
+}
+
+func (r *memoryMFAChallengeRepository) FindActive(ctx context.Context, hash string, at time.Time) (*models.MFAChallenge, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, challenge, err := r.s.mfaChallenges.findOne(func(challenge models.MFAChallenge) bool {
+		return challenge.TokenHash == hash && challenge.UsedAt == nil && at.Before(challenge.ExpiresAt)
+	})
+	return challenge, err
+}
+
+func (r *memoryMFAChallengeRepository) Fail(ctx context.Context, id primitive.ObjectID, maxAttempts int, at time.Time) error {
+	defer r.s.lock(ctx)()
+
+	challenge, err := r.s.mfaChallenges.get(id)
+	if err != nil {
+		return nil
+	}
+
+	challenge.Attempts++
+	if challenge.Attempts >= maxAttempts && challenge.UsedAt == nil {
+		challenge.UsedAt = &at
+	}
+
+	r.s.mfaChallenges.put(id, *challenge)
+	return nil
+}
+
+func (r *memoryMFAChallengeRepository) Redeem(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	defer r.s.lock(ctx)()
+
+	challenge, err := r.s.mfaChallenges.get(id)
+	if err != nil {
+		return err
+	}
+
+	if challenge.UsedAt != nil {
+		return ErrNotFound
+	}
+
+	challenge.UsedAt = &at
+	r.s.mfaChallenges.put(id, *challenge)
+	return nil
+}
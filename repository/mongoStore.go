@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoStore struct {
+	client         *mongo.Client
+	books          *mongoBookRepository
+	users          *mongoUserRepository
+	loans          *mongoLoanRepository
+	fines          *mongoFineRepository
+	holds          *mongoHoldRepository
+	copies         *mongoCopyRepository
+	sessions       *mongoSessionRepository
+	roles          *mongoRoleRepository
+	invitations    *mongoInvitationRepository
+	passwordResets *mongoPasswordResetRepository
+	loginAttempts  *mongoLoginAttemptRepository
+	audit          *mongoAuditRepository
+	mfaChallenges  *mongoMFAChallengeRepository
+	signingKeys    *mongoSigningKeyRepository
+	apiKeys        *mongoAPIKeyRepository
+	oidcLogins     *mongoOIDCLoginRepository
+}
+
+// NewMongoStore returns a Store backed by the collections of db.
+func NewMongoStore(db *mongo.Database) Store {
+	return &mongoStore{
+		client:         db.Client(),
+		books:          &mongoBookRepository{collection: db.Collection(BookCollectionName)},
+		users:          &mongoUserRepository{collection: db.Collection(UserCollectionName)},
+		loans:          &mongoLoanRepository{collection: db.Collection(BorrowHistoryCollectionName)},
+		fines:          &mongoFineRepository{collection: db.Collection(FineCollectionName)},
+		holds:          &mongoHoldRepository{collection: db.Collection(HoldCollectionName)},
+		copies:         &mongoCopyRepository{collection: db.Collection(CopyCollectionName)},
+		sessions:       &mongoSessionRepository{collection: db.Collection(SessionCollectionName)},
+		roles:          &mongoRoleRepository{collection: db.Collection(RoleCollectionName)},
+		invitations:    &mongoInvitationRepository{collection: db.Collection(InvitationCollectionName)},
+		passwordResets: &mongoPasswordResetRepository{collection: db.Collection(PasswordResetCollectionName)},
+		loginAttempts:  &mongoLoginAttemptRepository{collection: db.Collection(LoginAttemptCollectionName)},
+		audit:          &mongoAuditRepository{collection: db.Collection(AuditCollectionName)},
+		mfaChallenges:  &mongoMFAChallengeRepository{collection: db.Collection(MFAChallengeCollectionName)},
+		signingKeys:    &mongoSigningKeyRepository{collection: db.Collection(SigningKeyCollectionName)},
+		apiKeys:        &mongoAPIKeyRepository{collection: db.Collection(APIKeyCollectionName)},
+		oidcLogins:     &mongoOIDCLoginRepository{collection: db.Collection(OIDCLoginCollectionName)},
+	}
+}
+
+func (s *mongoStore) Books() BookRepository                   { return s.books }
+func (s *mongoStore) Users() UserRepository                   { return s.users }
+func (s *mongoStore) Loans() LoanRepository                   { return s.loans }
+func (s *mongoStore) Fines() FineRepository                   { return s.fines }
+func (s *mongoStore) Holds() HoldRepository                   { return s.holds }
+func (s *mongoStore) Copies() CopyRepository                  { return s.copies }
+func (s *mongoStore) Sessions() SessionRepository             { return s.sessions }
+func (s *mongoStore) Roles() RoleRepository                   { return s.roles }
+func (s *mongoStore) Invitations() InvitationRepository       { return s.invitations }
+func (s *mongoStore) PasswordResets() PasswordResetRepository { return s.passwordResets }
+func (s *mongoStore) LoginAttempts() LoginAttemptRepository   { return s.loginAttempts }
+func (s *mongoStore) Audit() AuditRepository                  { return s.audit }
+func (s *mongoStore) MFAChallenges() MFAChallengeRepository   { return s.mfaChallenges }
+func (s *mongoStore) SigningKeys() SigningKeyRepository       { return s.signingKeys }
+func (s *mongoStore) APIKeys() APIKeyRepository               { return s.apiKeys }
+func (s *mongoStore) OIDCLogins() OIDCLoginRepository         { return s.oidcLogins }
+
+// WithTransaction runs fn inside a MongoDB transaction. The session context
+// handed to fn makes every repository call it performs part of the transaction.
+func (s *mongoStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	session, err := s.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
+
+// EnsureIndexes creates the indexes the queries of the Mongo store rely on. It
+// is safe to call on every start.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	// a collection has a single text index, and books_text only covered the
+	// title and author
+	books := db.Collection(BookCollectionName).Indexes()
+	if _, err := books.DropOne(ctx, "books_text"); err != nil && !indexNotFound(err) {
+		return err
+	}
+
+	_, err := books.CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "title", Value: "text"},
+				{Key: "author", Value: "text"},
+				{Key: "contributors.name", Value: "text"},
+				{Key: "subjects", Value: "text"},
+				{Key: "series.title", Value: "text"},
+				{Key: "description", Value: "text"},
+			},
+			Options: options.Index().SetName("books_catalog_text").SetWeights(bson.M{"title": 2}),
+		},
+		{Keys: bson.D{{Key: "subjects", Value: 1}}},
+		{Keys: bson.D{{Key: "language", Value: 1}}},
+		{Keys: bson.D{{Key: "call_number.scheme", Value: 1}, {Key: "call_number.number", Value: 1}}},
+		// the sort orders a search offers, with the _id that breaks ties
+		{Keys: bson.D{{Key: "title", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "author", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "qty", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// expired sessions are of no use, so let MongoDB drop them
+	_, err = db.Collection(SessionCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "refresh_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "previous_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(RoleCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(InvitationCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// used and expired reset tokens are kept a day for reference
+	_, err = db.Collection(PasswordResetCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// failures a day old no longer count towards a lockout
+	_, err = db.Collection(LoginAttemptCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "last_failure_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// the audit log is searched by actor, target and time
+	_, err = db.Collection(AuditCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "target", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(MFAChallengeCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// overdue loans and a member's history are listed a page at a time
+	_, err = db.Collection(BorrowHistoryCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// barcodes identify copies, and borrowing looks for an available copy of a
+	// book
+	_, err = db.Collection(CopyCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "barcode", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "book_id", Value: 1}, {Key: "status", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(SigningKeyCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "kid", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(APIKeyCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	// one user per account at the OpenID provider
+	_, err = db.Collection(UserCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "oidc.issuer", Value: 1}, {Key: "oidc.subject", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"oidc": bson.M{"$exists": true}}),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Collection(OIDCLoginCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "state_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// indexNotFound tells whether err reports dropping an index or collection
+// that does not exist.
+func indexNotFound(err error) bool {
+	var commandErr mongo.CommandError
+	return errors.As(err, &commandErr) && (commandErr.Code == 26 || commandErr.Code == 27)
+}
+
+func findAll[T any](ctx context.Context, collection *mongo.Collection, filter interface{}, opts ...*options.FindOptions) ([]T, error) {
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []T
+	if err = cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	return docs, nil
+}
+
+func findOne[T any](ctx context.Context, collection *mongo.Collection, filter interface{}) (*T, error) {
+	var doc T
+	err := collection.FindOne(ctx, filter).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
+}
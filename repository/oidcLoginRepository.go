@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OIDCLoginRepository interface {
+	Insert(ctx context.Context, login *models.OIDCLogin) error
+	// Redeem marks the unused, unexpired login whose state hashes to hash as
+	// used and returns it, or ErrNotFound if there is none.
+	Redeem(ctx context.Context, hash string, at time.Time) (*models.OIDCLogin, error)
+}
+
+type mongoOIDCLoginRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoOIDCLoginRepository) Insert(ctx context.Context, login *models.OIDCLogin) error {
+	if login.ID.IsZero() {
+		login.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, login)
+	return err
+}
+
+func (r *mongoOIDCLoginRepository) Redeem(ctx context.Context, hash string, at time.Time) (*models.OIDCLogin, error) {
+	var login models.OIDCLogin
+	filter := bson.M{"state_hash": hash, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": at}}
+	update := bson.M{"$set": bson.M{"used_at": at}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&login)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &login, nil
+}
+
+type memoryOIDCLoginRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryOIDCLoginRepository) Insert(ctx context.Context, login *models.OIDCLogin) error {
+	defer r.s.lock(ctx)()
+
+	if login.ID.IsZero() {
+		login.ID = primitive.NewObjectID()
+	}
+
+	r.s.oidcLogins.put(login.ID, *login)
+	return nil
+}
+
+func (r *memoryOIDCLoginRepository) Redeem(ctx context.Context, hash string, at time.Time) (*models.OIDCLogin, error) {
+	defer r.s.lock(ctx)()
+
+	id, login, err := r.s.oidcLogins.findOne(func(login models.OIDCLogin) bool {
+		return login.StateHash == hash && login.UsedAt == nil && at.Before(login.ExpiresAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	login.UsedAt = &at
+	r.s.oidcLogins.put(id, *login)
+	return login, nil
+}
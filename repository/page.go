@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page asks for at most Limit documents following the one Cursor points at.
+// An empty Cursor starts from the beginning.
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+// Paged is one page of a listing. NextCursor is empty on the last page and
+// Total counts every match, not only the ones on the page.
+type Paged[T any] struct {
+	Items      []T
+	NextCursor string
+	Total      int64
+}
+
+// pageCursor is the position of the last document of a page: the value of the
+// sort field, if the listing is not in _id order, and the _id as tie breaker.
+type pageCursor struct {
+	Value interface{}        `bson:"v,omitempty"`
+	ID    primitive.ObjectID `bson:"id"`
+}
+
+func (p Page) limit() int {
+	if p.Limit <= 0 {
+		return DefaultPageLimit
+	}
+
+	return min(p.Limit, MaxPageLimit)
+}
+
+// after decodes the cursor, returning nil when the page starts at the beginning.
+func (p Page) after() (*pageCursor, error) {
+	if p.Cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor pageCursor
+	if err = bson.Unmarshal(raw, &cursor); err != nil || cursor.ID.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+func encodeCursor(value interface{}, id primitive.ObjectID) string {
+	raw, _ := bson.Marshal(pageCursor{Value: sortValue(value), ID: id})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// sortValue converts a Go value to the form it comes back in from BSON, so
+// that cursor values and document values compare alike.
+func sortValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case time.Time:
+		return primitive.NewDateTimeFromTime(v)
+	case *string:
+		if v == nil {
+			return ""
+		}
+		return *v
+	}
+
+	return value
+}
+
+// compareSortValues orders two values produced by sortValue.
+func compareSortValues(a, b interface{}) int {
+	switch a := sortValue(a).(type) {
+	case string:
+		b, _ := sortValue(b).(string)
+		return cmp.Compare(a, b)
+	case int64:
+		b, _ := sortValue(b).(int64)
+		return cmp.Compare(a, b)
+	case float64:
+		b, _ := sortValue(b).(float64)
+		return cmp.Compare(a, b)
+	case primitive.DateTime:
+		b, _ := sortValue(b).(primitive.DateTime)
+		return cmp.Compare(a, b)
+	}
+
+	return 0
+}
+
+// afterCursor is the filter matching the documents that follow cursor in a
+// listing ordered by field (then _id) in the given direction.
+func afterCursor(field string, order int, cursor *pageCursor) bson.M {
+	op := "$gt"
+	if order < 0 {
+		op = "$lt"
+	}
+
+	if field == "_id" {
+		return bson.M{"_id": bson.M{"$gt": cursor.ID}}
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{op: cursor.Value}},
+		bson.M{field: cursor.Value, "_id": bson.M{"$gt": cursor.ID}},
+	}}
+}
+
+// findPage reads one page of a collection in _id order.
+func findPage[T any](ctx context.Context, collection *mongo.Collection, filter bson.M, page Page, id func(T) primitive.ObjectID) (*Paged[T], error) {
+	cursor, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := filter
+	if cursor != nil {
+		query = bson.M{"$and": bson.A{filter, afterCursor("_id", 1, cursor)}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(page.limit() + 1))
+
+	docs, err := findAll[T](ctx, collection, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageOf(docs, page.limit(), total, func(doc T) string { return encodeCursor(nil, id(doc)) }), nil
+}
+
+// pageOf cuts a page out of docs, which hold up to one document more than
+// the limit to tell whether another page follows.
+func pageOf[T any](docs []T, limit int, total int64, cursor func(T) string) *Paged[T] {
+	paged := Paged[T]{Items: []T{}, Total: total}
+	if len(docs) > limit {
+		docs = docs[:limit]
+		paged.NextCursor = cursor(docs[limit-1])
+	}
+
+	paged.Items = append(paged.Items, docs...)
+	return &paged
+}
+
+// slicePage pages through rows already filtered and sorted in memory. key
+// returns the sort value of a row; nil for listings in _id order.
+func slicePage[T any](rows []T, page Page, id func(T) primitive.ObjectID, key func(T) interface{}, order int) (*Paged[T], error) {
+	cursor, err := page.after()
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		key = func(T) interface{} { return nil }
+	}
+
+	start := 0
+	if cursor != nil {
+		for start < len(rows) {
+			row := rows[start]
+			c := order * compareSortValues(key(row), cursor.Value)
+			if c > 0 || (c == 0 && compareIDs(id(row), cursor.ID) > 0) {
+				break
+			}
+			start++
+		}
+	}
+
+	end := min(start+page.limit()+1, len(rows))
+
+	return pageOf(rows[start:end], page.limit(), int64(len(rows)), func(row T) string {
+		return encodeCursor(key(row), id(row))
+	}), nil
+}
+
+func compareIDs(a, b primitive.ObjectID) int {
+	return bytes.Compare(a[:], b[:])
+}
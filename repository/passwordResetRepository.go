@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type PasswordResetRepository interface {
+	Insert(ctx context.Context, reset *models.PasswordReset) error
+	// Redeem marks the unused, unexpired reset whose token hashes to hash as
+	// used and returns it, or ErrNotFound if there is none.
+	Redeem(ctx context.Context, hash string, at time.Time) (*models.PasswordReset, error)
+	// InvalidateByUser marks every unused reset of the user as used.
+	InvalidateByUser(ctx context.Context, userId primitive.ObjectID, at time.Time) error
+}
+
+type mongoPasswordResetRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoPasswordResetRepository) Insert(ctx context.Context, reset *models.PasswordReset) error {
+	if reset.ID.IsZero() {
+		reset.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, reset)
+	return err
+}
+
+func (r *mongoPasswordResetRepository) Redeem(ctx context.Context, hash string, at time.Time) (*models.PasswordReset, error) {
+	var reset models.PasswordReset
+	filter := bson.M{"token_hash": hash, "used_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": at}}
+	update := bson.M{"$set": bson.M{"used_at": at}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &reset, nil
+}
+
+func (r *mongoPasswordResetRepository) InvalidateByUser(ctx context.Context, userId primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"user_id": userId, "used_at": bson.M{"$exists": false}}
+
+	_, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"used_at": at}})
+	return err
+}
+
+type memoryPasswordResetRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryPasswordResetRepository) Insert(ctx context.Context, reset *models.PasswordReset) error {
+	defer r.s.lock(ctx)()
+
+	if reset.ID.IsZero() {
+		reset.ID = primitive.NewObjectID()
+	}
+
+	r.s.passwordResets.put(reset.ID, *reset)
+	return nil
+}
+
+func (r *memoryPasswordResetRepository) Redeem(ctx context.Context, hash string, at time.Time) (*models.PasswordReset, error) {
+	defer r.s.lock(ctx)()
+
+	id, reset, err := r.s.passwordResets.findOne(func(reset models.PasswordReset) bool {
+		return reset.TokenHash == hash && reset.UsedAt == nil && at.Before(reset.ExpiresAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reset.UsedAt = &at
+	r.s.passwordResets.put(id, *reset)
+	return reset, nil
+}
+
+func (r *memoryPasswordResetRepository) InvalidateByUser(ctx context.Context, userId primitive.ObjectID, at time.Time) error {
+	defer r.s.lock(ctx)()
+
+	for _, reset := range r.s.passwordResets.find(nil) {
+		if reset.UserID == userId && reset.UsedAt == nil {
+			reset.UsedAt = &at
+			r.s.passwordResets.put(reset.ID, reset)
+		}
+	}
+
+	return nil
+}
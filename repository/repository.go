@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	DatabaseName                = "Cluster0"
+	BookCollectionName          = "books"
+	UserCollectionName          = "users"
+	BorrowHistoryCollectionName = "borrowHistory"
+	FineCollectionName          = "fines"
+	HoldCollectionName          = "holds"
+	CopyCollectionName          = "copies"
+	SessionCollectionName       = "sessions"
+	RoleCollectionName          = "roles"
+	InvitationCollectionName    = "invitations"
+	PasswordResetCollectionName = "passwordResets"
+	LoginAttemptCollectionName  = "loginAttempts"
+	AuditCollectionName         = "auditLog"
+	MFAChallengeCollectionName  = "mfaChallenges"
+	SigningKeyCollectionName    = "signingKeys"
+	APIKeyCollectionName        = "apiKeys"
+	OIDCLoginCollectionName     = "oidcLogins"
+)
+
+var (
+	ErrNotFound   = errors.New("document not found")
+	ErrOutOfStock = errors.New("book is out of stock")
+	// ErrDuplicate is returned by Insert when a unique field, like the barcode
+	// of a copy, is already taken.
+	ErrDuplicate = errors.New("duplicate key")
+	// ErrUnhashedPassword stops a plain text password from being written to
+	// the users collection.
+	ErrUnhashedPassword = errors.New("refusing to store a password that is not hashed")
+)
+
+// Store bundles the repositories the handlers depend on. Calls made with the
+// context passed to fn by WithTransaction either all take effect or none do.
+type Store interface {
+	Books() BookRepository
+	Users() UserRepository
+	Loans() LoanRepository
+	Fines() FineRepository
+	Holds() HoldRepository
+	Copies() CopyRepository
+	Sessions() SessionRepository
+	Roles() RoleRepository
+	Invitations() InvitationRepository
+	PasswordResets() PasswordResetRepository
+	LoginAttempts() LoginAttemptRepository
+	Audit() AuditRepository
+	MFAChallenges() MFAChallengeRepository
+	SigningKeys() SigningKeyRepository
+	APIKeys() APIKeyRepository
+	OIDCLogins() OIDCLoginRepository
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
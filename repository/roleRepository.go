@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type RoleRepository interface {
+	// List returns every role in the order they were created.
+	List(ctx context.Context) ([]models.Role, error)
+	FindByName(ctx context.Context, name string) (*models.Role, error)
+	Insert(ctx context.Context, role *models.Role) error
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	// Delete removes the role, returning ErrNotFound if there was none.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoRoleRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoRoleRepository) List(ctx context.Context) ([]models.Role, error) {
+	return findAll[models.Role](ctx, r.collection, bson.M{})
+}
+
+func (r *mongoRoleRepository) FindByName(ctx context.Context, name string) (*models.Role, error) {
+	return findOne[models.Role](ctx, r.collection, bson.M{"name": name})
+}
+
+func (r *mongoRoleRepository) Insert(ctx context.Context, role *models.Role) error {
+	if role.ID.IsZero() {
+		role.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, role)
+	return err
+}
+
+func (r *mongoRoleRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": bson.M{"$eq": id}}, bson.M{"$set": set})
+	return err
+}
+
+func (r *mongoRoleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type memoryRoleRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryRoleRepository) List(ctx context.Context) ([]models.Role, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.roles.find(nil), nil
+}
+
+func (r *memoryRoleRepository) FindByName(ctx context.Context, name string) (*models.Role, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, role, err := r.s.roles.findOne(func(role models.Role) bool {
+		return role.Name != nil && *role.Name == name
+	})
+	return role, err
+}
+
+func (r *memoryRoleRepository) Insert(ctx context.Context, role *models.Role) error {
+	defer r.s.lock(ctx)()
+
+	if role.ID.IsZero() {
+		role.ID = primitive.NewObjectID()
+	}
+
+	r.s.roles.put(role.ID, *role)
+	return nil
+}
+
+func (r *memoryRoleRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	defer r.s.lock(ctx)()
+
+	role, err := r.s.roles.get(id)
+	if err != nil {
+		return nil
+	}
+
+	updated, err := applySet(*role, set)
+	if err != nil {
+		return err
+	}
+
+	r.s.roles.put(id, updated)
+	return nil
+}
+
+func (r *memoryRoleRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+
+	if _, err := r.s.roles.get(id); err != nil {
+		return err
+	}
+
+	r.s.roles.delete(id)
+	return nil
+}
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type SessionRepository interface {
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error)
+	// FindByRefreshHash returns the session whose current or previous refresh
+	// token hashes to hash, revoked or not.
+	FindByRefreshHash(ctx context.Context, hash string) (*models.Session, error)
+	Insert(ctx context.Context, session *models.Session) error
+	// Rotate replaces the refresh token of an active session, provided oldHash
+	// is still its current one, and returns ErrNotFound otherwise.
+	Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expiresAt, at time.Time) error
+	Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	// RevokeByUser revokes every active session of the user and returns how
+	// many there were.
+	RevokeByUser(ctx context.Context, userId primitive.ObjectID, at time.Time) (int64, error)
+}
+
+type mongoSessionRepository struct {
+	collection *mongo.Collection
+}
+
+func activeSessionQuery(at time.Time) bson.M {
+	return bson.M{"revoked_at": bson.M{"$exists": false}, "expires_at": bson.M{"$gt": at}}
+}
+
+func (r *mongoSessionRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	return findOne[models.Session](ctx, r.collection, bson.M{"_id": id})
+}
+
+func (r *mongoSessionRepository) FindByRefreshHash(ctx context.Context, hash string) (*models.Session, error) {
+	filter := bson.M{"$or": bson.A{bson.M{"refresh_hash": hash}, bson.M{"previous_hash": hash}}}
+	return findOne[models.Session](ctx, r.collection, filter)
+}
+
+func (r *mongoSessionRepository) Insert(ctx context.Context, session *models.Session) error {
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (r *mongoSessionRepository) Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expiresAt, at time.Time) error {
+	filter := activeSessionQuery(at)
+	filter["_id"] = id
+	filter["refresh_hash"] = oldHash
+
+	update := bson.M{"$set": bson.M{
+		"refresh_hash":  newHash,
+		"previous_hash": oldHash,
+		"refreshed_at":  at,
+		"expires_at":    expiresAt,
+	}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *mongoSessionRepository) Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"_id": id, "revoked_at": bson.M{"$exists": false}}
+
+	_, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": at}})
+	return err
+}
+
+func (r *mongoSessionRepository) RevokeByUser(ctx context.Context, userId primitive.ObjectID, at time.Time) (int64, error) {
+	filter := activeSessionQuery(at)
+	filter["user_id"] = userId
+
+	result, err := r.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+type memorySessionRepository struct {
+	s *memoryStore
+}
+
+func (r *memorySessionRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.Session, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.sessions.get(id)
+}
+
+func (r *memorySessionRepository) FindByRefreshHash(ctx context.Context, hash string) (*models.Session, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, session, err := r.s.sessions.findOne(func(session models.Session) bool {
+		return session.RefreshHash == hash || (session.PreviousHash != "" && session.PreviousHash == hash)
+	})
+	return session, err
+}
+
+func (r *memorySessionRepository) Insert(ctx context.Context, session *models.Session) error {
+	defer r.s.lock(ctx)()
+
+	if session.ID.IsZero() {
+		session.ID = primitive.NewObjectID()
+	}
+
+	r.s.sessions.put(session.ID, *session)
+	return nil
+}
+
+func (r *memorySessionRepository) Rotate(ctx context.Context, id primitive.ObjectID, oldHash, newHash string, expiresAt, at time.Time) error {
+	defer r.s.lock(ctx)()
+
+	session, err := r.s.sessions.get(id)
+	if err != nil {
+		return err
+	}
+
+	if !session.Active(at) || session.RefreshHash != oldHash {
+		return ErrNotFound
+	}
+
+	session.PreviousHash = oldHash
+	session.RefreshHash = newHash
+	session.RefreshedAt = at
+	session.ExpiresAt = expiresAt
+
+	r.s.sessions.put(id, *session)
+	return nil
+}
+
+func (r *memorySessionRepository) Revoke(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	defer r.s.lock(ctx)()
+
+	session, err := r.s.sessions.get(id)
+	if err != nil || session.RevokedAt != nil {
+		return nil
+	}
+
+	session.RevokedAt = &at
+	r.s.sessions.put(id, *session)
+	return nil
+}
+
+func (r *memorySessionRepository) RevokeByUser(ctx context.Context, userId primitive.ObjectID, at time.Time) (int64, error) {
+	defer r.s.lock(ctx)()
+
+	var revoked int64
+	for _, session := range r.s.sessions.find(nil) {
+		if session.UserID == userId && session.Active(at) {
+			session.RevokedAt = &at
+			r.s.sessions.put(session.ID, session)
+			revoked++
+		}
+	}
+
+	return revoked, nil
+}
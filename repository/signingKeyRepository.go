@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"slices"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SigningKeyRepository interface {
+	// List returns every key, in the order they activate.
+	List(ctx context.Context) ([]models.SigningKey, error)
+	Insert(ctx context.Context, key *models.SigningKey) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoSigningKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoSigningKeyRepository) List(ctx context.Context) ([]models.SigningKey, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "activates_at", Value: 1}, {Key: "_id", Value: 1}})
+	return findAll[models.SigningKey](ctx, r.collection, bson.M{}, opts)
+}
+
+func (r *mongoSigningKeyRepository) Insert(ctx context.Context, key *models.SigningKey) error {
+	if key.ID.IsZero() {
+		key.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, key)
+	return err
+}
+
+func (r *mongoSigningKeyRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
+
+type memorySigningKeyRepository struct {
+	s *memoryStore
+}
+
+func (r *memorySigningKeyRepository) List(ctx context.Context) ([]models.SigningKey, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	keys := r.s.signingKeys.find(nil)
+	slices.SortStableFunc(keys, func(a, b models.SigningKey) int {
+		return a.ActivatesAt.Compare(b.ActivatesAt)
+	})
+
+	return keys, nil
+}
+
+func (r *memorySigningKeyRepository) Insert(ctx context.Context, key *models.SigningKey) error {
+	defer r.s.lock(ctx)()
+
+	if key.ID.IsZero() {
+		key.ID = primitive.NewObjectID()
+	}
+
+	r.s.signingKeys.put(key.ID, *key)
+	return nil
+}
+
+func (r *memorySigningKeyRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+
+	r.s.signingKeys.delete(id)
+	return nil
+}
@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"context"
+	"slices"
+
+	"github.com/roh4nyh/iit_bombay/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserFilter narrows ListPage down; nil fields match every user.
+type UserFilter struct {
+	IsActive      *bool
+	Role          string
+	AccountStatus string
+}
+
+// MFACode names what a code used up: a TOTP step, or a recovery code by the
+// hash stored for it.
+type MFACode struct {
+	Step         int64
+	RecoveryHash string
+}
+
+type UserRepository interface {
+	ListPage(ctx context.Context, filter UserFilter, page Page) (*Paged[models.User], error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error)
+	FindByUsername(ctx context.Context, username string) (*models.User, error)
+	// FindByOIDC returns the user linked to subject at the OpenID provider issuer.
+	FindByOIDC(ctx context.Context, issuer, subject string) (*models.User, error)
+	CountByUsername(ctx context.Context, username string) (int64, error)
+	Insert(ctx context.Context, user *models.User) error
+	Update(ctx context.Context, id primitive.ObjectID, set bson.M) error
+	// UseMFACode stores mfa as the MFA of the user provided code is still
+	// unused: a step after the last one used, or a recovery code still listed.
+	// Otherwise it returns ErrNotFound, so of two requests with one code only
+	// the first gets through.
+	UseMFACode(ctx context.Context, id primitive.ObjectID, code MFACode, mfa *models.MFA) error
+	// Delete removes the user, returning ErrNotFound if there was none.
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoUserRepository struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoUserRepository) ListPage(ctx context.Context, filter UserFilter, page Page) (*Paged[models.User], error) {
+	query := bson.M{}
+	if filter.IsActive != nil {
+		query["is_active"] = *filter.IsActive
+	}
+
+	if filter.Role != "" {
+		query["role"] = filter.Role
+	}
+
+	if filter.AccountStatus != "" {
+		query["account_status"] = filter.AccountStatus
+	}
+
+	return findPage(ctx, r.collection, query, page, userID)
+}
+
+func (r *mongoUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	return findOne[models.User](ctx, r.collection, bson.M{"_id": id})
+}
+
+func (r *mongoUserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	return findOne[models.User](ctx, r.collection, bson.M{"username": username})
+}
+
+func (r *mongoUserRepository) FindByOIDC(ctx context.Context, issuer, subject string) (*models.User, error) {
+	return findOne[models.User](ctx, r.collection, bson.M{"oidc.issuer": issuer, "oidc.subject": subject})
+}
+
+func (r *mongoUserRepository) CountByUsername(ctx context.Context, username string) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"username": username})
+}
+
+func (r *mongoUserRepository) Insert(ctx context.Context, user *models.User) error {
+	if err := checkPasswordHashed(user.Password); err != nil {
+		return err
+	}
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+
+	_, err := r.collection.InsertOne(ctx, user)
+	return err
+}
+
+func (r *mongoUserRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	if err := checkSetPasswordHashed(set); err != nil {
+		return err
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": bson.M{"$eq": id}}, bson.M{"$set": set})
+	return err
+}
+
+func (r *mongoUserRepository) UseMFACode(ctx context.Context, id primitive.ObjectID, code MFACode, mfa *models.MFA) error {
+	filter := bson.M{"_id": id, "mfa": bson.M{"$ne": nil}}
+	if code.Step > 0 {
+		// matches a missing last_step too
+		filter["mfa.last_step"] = bson.M{"$not": bson.M{"$gte": code.Step}}
+	}
+	if code.RecoveryHash != "" {
+		filter["mfa.recovery_hashes"] = code.RecoveryHash
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"mfa": mfa}})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *mongoUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+type memoryUserRepository struct {
+	s *memoryStore
+}
+
+func (r *memoryUserRepository) ListPage(ctx context.Context, filter UserFilter, page Page) (*Paged[models.User], error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	users := r.s.users.find(func(user models.User) bool {
+		if filter.IsActive != nil && (user.IsActive == nil || *user.IsActive != *filter.IsActive) {
+			return false
+		}
+		if filter.Role != "" && (user.Role == nil || *user.Role != filter.Role) {
+			return false
+		}
+		return filter.AccountStatus == "" || user.AccountStatus == filter.AccountStatus
+	})
+
+	return slicePage(users, page, userID, nil, 1)
+}
+
+func (r *memoryUserRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*models.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return r.s.users.get(id)
+}
+
+func (r *memoryUserRepository) FindByUsername(ctx context.Context, username string) (*models.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, user, err := r.s.users.findOne(matchUsername(username))
+	return user, err
+}
+
+func (r *memoryUserRepository) FindByOIDC(ctx context.Context, issuer, subject string) (*models.User, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	_, user, err := r.s.users.findOne(func(user models.User) bool {
+		return user.OIDC != nil && user.OIDC.Issuer == issuer && user.OIDC.Subject == subject
+	})
+	return user, err
+}
+
+func (r *memoryUserRepository) CountByUsername(ctx context.Context, username string) (int64, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+
+	return int64(len(r.s.users.find(matchUsername(username)))), nil
+}
+
+func (r *memoryUserRepository) Insert(ctx context.Context, user *models.User) error {
+	if err := checkPasswordHashed(user.Password); err != nil {
+		return err
+	}
+
+	defer r.s.lock(ctx)()
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+
+	r.s.users.put(user.ID, *user)
+	return nil
+}
+
+func (r *memoryUserRepository) Update(ctx context.Context, id primitive.ObjectID, set bson.M) error {
+	if err := checkSetPasswordHashed(set); err != nil {
+		return err
+	}
+
+	defer r.s.lock(ctx)()
+
+	user, err := r.s.users.get(id)
+	if err != nil {
+		return nil
+	}
+
+	updated, err := applySet(*user, set)
+	if err != nil {
+		return err
+	}
+
+	r.s.users.put(id, updated)
+	return nil
+}
+
+func (r *memoryUserRepository) UseMFACode(ctx context.Context, id primitive.ObjectID, code MFACode, mfa *models.MFA) error {
+	defer r.s.lock(ctx)()
+
+	user, err := r.s.users.get(id)
+	if err != nil {
+		return err
+	}
+
+	stored := user.MFA
+	if stored == nil || (code.Step > 0 && stored.LastStep >= code.Step) ||
+		(code.RecoveryHash != "" && !slices.Contains(stored.RecoveryHashes, code.RecoveryHash)) {
+		return ErrNotFound
+	}
+
+	user.MFA = mfa
+	r.s.users.put(id, *user)
+	return nil
+}
+
+func (r *memoryUserRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	defer r.s.lock(ctx)()
+
+	if _, err := r.s.users.get(id); err != nil {
+		return err
+	}
+
+	r.s.users.delete(id)
+	return nil
+}
+
+func matchUsername(username string) func(models.User) bool {
+	return func(user models.User) bool {
+		return user.Username != nil && *user.Username == username
+	}
+}
+
+// checkPasswordHashed returns ErrUnhashedPassword unless password is missing
+// or a bcrypt hash.
+func checkPasswordHashed(password *string) error {
+	if password == nil {
+		return nil
+	}
+
+	if _, err := bcrypt.Cost([]byte(*password)); err != nil {
+		return ErrUnhashedPassword
+	}
+
+	return nil
+}
+
+func checkSetPasswordHashed(set bson.M) error {
+	switch password := set["password"].(type) {
+	case nil:
+		return nil
+	case string:
+		return checkPasswordHashed(&password)
+	case *string:
+		return checkPasswordHashed(password)
+	default:
+		return ErrUnhashedPassword
+	}
+}
+
+func userID(user models.User) primitive.ObjectID { return user.ID }
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/roh4nyh/iit_bombay/controllers"
+	"github.com/roh4nyh/iit_bombay/middleware"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+func AdminRoutes(incomingRoutes *gin.Engine, store repository.Store) {
+	adminRoutes := incomingRoutes.Group("/admin")
+	adminRoutes.Use(middleware.Authenticate(store))
+
+	can := middleware.RequirePermission
+
+	// roles and the permissions they grant
+	adminRoutes.GET("/roles", can(models.PERM_ROLES_MANAGE), controller.GetRoles(store))
+	adminRoutes.POST("/roles", can(models.PERM_ROLES_MANAGE), controller.AddRole(store))
+	adminRoutes.PUT("/roles/:name", can(models.PERM_ROLES_MANAGE), controller.UpdateRole(store))
+	adminRoutes.DELETE("/roles/:name", can(models.PERM_ROLES_MANAGE), controller.DeleteRole(store))
+}
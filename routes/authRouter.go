@@ -1,11 +1,36 @@
-package routes
-
-import (
-	"github.com/gin-gonic/gin"
-	"github.com/roh4nyh/iit_bombay/controllers"
-)
-
-func AuthRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("users/signup", controllers.UserSignUp())
-	incomingRoutes.POST("users/login", controllers.UserLogIn())
-}
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/iit_bombay/controllers"
+	"github.com/roh4nyh/iit_bombay/middleware"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+func AuthRoutes(incomingRoutes *gin.Engine, store repository.Store) {
+	incomingRoutes.POST("users/signup", controllers.UserSignUp(store))
+	incomingRoutes.POST("users/setup", controllers.UserSetup(store))
+	incomingRoutes.POST("users/login", controllers.UserLogIn(store))
+	incomingRoutes.POST("users/login/mfa", controllers.UserLogInMFA(store))
+	incomingRoutes.POST("users/refresh", controllers.UserRefresh(store))
+	incomingRoutes.POST("users/logout", controllers.UserLogOut(store))
+
+	// campus single sign-on, the provider redirects back to the callback
+	incomingRoutes.GET("users/oidc/login", controllers.OIDCLogin(store))
+	incomingRoutes.GET("users/oidc/callback", controllers.OIDCCallback(store))
+
+	// public keys for verifying access tokens elsewhere
+	incomingRoutes.GET(".well-known/jwks.json", controllers.GetJWKS())
+
+	// passwords, changing one only needs a login
+	incomingRoutes.POST("users/password/change", middleware.Authenticate(store), controllers.ChangePassword(store))
+	incomingRoutes.POST("users/password/forgot", controllers.ForgotPassword(store))
+	incomingRoutes.POST("users/password/reset", controllers.ResetPassword(store))
+
+	// TOTP second factor of the logged in user
+	mfa := incomingRoutes.Group("users/mfa", middleware.Authenticate(store))
+	mfa.POST("/enroll", controllers.EnrollMFA(store))
+	mfa.POST("/confirm", controllers.ConfirmMFA(store))
+	mfa.POST("/recovery-codes", controllers.RegenerateRecoveryCodes(store))
+	mfa.POST("/disable", controllers.DisableMFA(store))
+}
@@ -1,37 +1,85 @@
-package routes
-
-import (
-	"github.com/gin-gonic/gin"
-	controller "github.com/roh4nyh/iit_bombay/controllers"
-	"github.com/roh4nyh/iit_bombay/middleware"
-)
-
-func LibrarianRoutes(incomingRoutes *gin.Engine) {
-	librarianRoutes := incomingRoutes.Group("/librarian")
-	librarianRoutes.Use(middleware.Authenticate(), middleware.AuthenticateLibrarian())
-
-	// librarian CRUD operations
-	librarianRoutes.POST("/books", controller.AddBook())
-	librarianRoutes.GET("/books", controller.GetBooks())
-	librarianRoutes.GET("/books/:isbn", controller.GetBook())
-	librarianRoutes.PUT("/books/:isbn", controller.UpdateBook())
-	librarianRoutes.DELETE("/books/:isbn", controller.DeleteBook())
-
-	// member CRUD operations
-	librarianRoutes.GET("/users", controller.GetUsers())
-	librarianRoutes.POST("/users", controller.AddUser())
-	librarianRoutes.GET("/users/:user_id", controller.GetUser())
-	librarianRoutes.PUT("/users/:user_id", controller.UpdateUser())
-	librarianRoutes.DELETE("/users/:user_id", controller.DeActivateUser())
-	// force delete user (optional)
-	librarianRoutes.DELETE("/users/:user_id/force", controller.DeleteUser())
-
-	// get active users
-	librarianRoutes.GET("/users/active", controller.GetActiveUsers())
-
-	// get deleted users
-	librarianRoutes.GET("/users/deleted", controller.GetNonActiveUsers())
-
-	// member borrowed history
-	librarianRoutes.GET("/users/:user_id/history", controller.GetTransactionHistory())
-}
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/roh4nyh/iit_bombay/controllers"
+	"github.com/roh4nyh/iit_bombay/middleware"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+func LibrarianRoutes(incomingRoutes *gin.Engine, store repository.Store) {
+	librarianRoutes := incomingRoutes.Group("/librarian")
+	librarianRoutes.Use(middleware.Authenticate(store))
+
+	can := middleware.RequirePermission
+
+	// librarian CRUD operations
+	librarianRoutes.POST("/books", can(models.PERM_BOOKS_WRITE), controller.AddBook(store))
+	librarianRoutes.POST("/books/import", can(models.PERM_BOOKS_WRITE), controller.ImportBooks(store))
+	librarianRoutes.GET("/books", can(models.PERM_BOOKS_READ), controller.GetBooks(store))
+	librarianRoutes.GET("/books/:isbn", can(models.PERM_BOOKS_READ), controller.GetBook(store))
+	librarianRoutes.PUT("/books/:isbn", can(models.PERM_BOOKS_WRITE), controller.UpdateBook(store))
+	librarianRoutes.DELETE("/books/:isbn", can(models.PERM_BOOKS_WRITE), controller.DeleteBook(store))
+
+	// physical copies of a book
+	librarianRoutes.GET("/books/:isbn/copies", can(models.PERM_COPIES_READ), controller.GetBookCopies(store))
+	librarianRoutes.POST("/books/:isbn/copies", can(models.PERM_COPIES_WRITE), controller.AddCopy(store))
+	librarianRoutes.GET("/copies/:barcode", can(models.PERM_COPIES_READ), controller.GetCopy(store))
+	librarianRoutes.PUT("/copies/:barcode", can(models.PERM_COPIES_WRITE), controller.UpdateCopy(store))
+	librarianRoutes.DELETE("/copies/:barcode", can(models.PERM_COPIES_WRITE), controller.DeleteCopy(store))
+
+	// hold queues
+	librarianRoutes.GET("/books/:isbn/holds", can(models.PERM_HOLDS_READ), controller.GetBookHolds(store))
+	librarianRoutes.DELETE("/holds/:hold_id", can(models.PERM_HOLDS_CANCEL), controller.CancelHold(store))
+
+	// member CRUD operations
+	librarianRoutes.GET("/users", can(models.PERM_USERS_READ), controller.GetUsers(store))
+	librarianRoutes.POST("/users", can(models.PERM_USERS_WRITE), controller.AddUser(store))
+	librarianRoutes.GET("/users/:user_id", can(models.PERM_USERS_READ), controller.GetUser(store))
+	librarianRoutes.PUT("/users/:user_id", can(models.PERM_USERS_WRITE), controller.UpdateUser(store))
+	librarianRoutes.DELETE("/users/:user_id", can(models.PERM_USERS_DEACTIVATE), controller.DeActivateUser(store))
+	// force delete user (optional)
+	librarianRoutes.DELETE("/users/:user_id/force", can(models.PERM_USERS_DELETE), controller.DeleteUser(store))
+
+	// log a user out of every session
+	librarianRoutes.DELETE("/users/:user_id/sessions", can(models.PERM_SESSIONS_REVOKE), controller.RevokeUserSessions(store))
+
+	// lift a lockout after too many failed logins
+	librarianRoutes.POST("/users/:user_id/unlock", can(models.PERM_USERS_WRITE), controller.UnlockUser(store))
+
+	// get active users
+	librarianRoutes.GET("/users/active", can(models.PERM_USERS_READ), controller.GetActiveUsers(store))
+
+	// get deleted users
+	librarianRoutes.GET("/users/deleted", can(models.PERM_USERS_READ), controller.GetNonActiveUsers(store))
+
+	// members who signed up themselves wait for approval
+	librarianRoutes.GET("/users/pending", can(models.PERM_USERS_READ), controller.GetPendingUsers(store))
+	librarianRoutes.POST("/users/:user_id/approve", can(models.PERM_USERS_WRITE), controller.ApproveUser(store))
+	librarianRoutes.POST("/users/:user_id/reject", can(models.PERM_USERS_WRITE), controller.RejectUser(store))
+
+	// invitation codes for signing up staff accounts
+	librarianRoutes.GET("/invitations", can(models.PERM_USERS_WRITE), controller.GetInvitations(store))
+	librarianRoutes.POST("/invitations", can(models.PERM_USERS_WRITE), controller.CreateInvitation(store))
+	librarianRoutes.DELETE("/invitations/:invitation_id", can(models.PERM_USERS_WRITE), controller.DeleteInvitation(store))
+
+	// member borrowed history
+	librarianRoutes.GET("/users/:user_id/history", can(models.PERM_LOANS_READ), controller.GetTransactionHistory(store))
+
+	// overdue loans across all members
+	librarianRoutes.GET("/loans/overdue", can(models.PERM_LOANS_READ), controller.GetOverdueLoans(store))
+
+	// fines
+	librarianRoutes.GET("/users/:user_id/fines", can(models.PERM_FINES_READ), controller.GetUserFines(store))
+	librarianRoutes.POST("/fines/:fine_id/payments", can(models.PERM_FINES_COLLECT), controller.RecordFinePayment(store))
+	librarianRoutes.POST("/fines/:fine_id/waive", can(models.PERM_FINES_WAIVE), controller.WaiveFine(store))
+
+	// API keys for integrations that cannot log in
+	librarianRoutes.GET("/api-keys", can(models.PERM_API_KEYS_MANAGE), controller.GetAPIKeys(store))
+	librarianRoutes.POST("/api-keys", can(models.PERM_API_KEYS_MANAGE), controller.CreateAPIKey(store))
+	librarianRoutes.DELETE("/api-keys/:key_id", can(models.PERM_API_KEYS_MANAGE), controller.RevokeAPIKey(store))
+
+	// who changed what, and when
+	librarianRoutes.GET("/audit", can(models.PERM_AUDIT_READ), controller.GetAuditLog(store))
+}
@@ -1,22 +1,36 @@
-package routes
-
-import (
-	"github.com/gin-gonic/gin"
-	controller "github.com/roh4nyh/iit_bombay/controllers"
-	"github.com/roh4nyh/iit_bombay/middleware"
-)
-
-func MemberRoutes(incomingRoutes *gin.Engine) {
-	memberRoutes := incomingRoutes.Group("/member")
-	memberRoutes.Use(middleware.Authenticate(), middleware.AuthenticateMember())
-
-	// user crud
-	memberRoutes.GET("/books", controller.GetBooks())
-	memberRoutes.GET("/books/:isbn", controller.GetBook())
-
-	// member crud
-	memberRoutes.POST("/books/borrow/:isbn", controller.BorrowBook())
-	memberRoutes.PUT("/books/return/:isbn", controller.ReturnBook())
-	memberRoutes.GET("/books/borrowed", controller.BorrowedBooks())
-	memberRoutes.DELETE("/account", controller.DeActivateMember())
-}
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	controller "github.com/roh4nyh/iit_bombay/controllers"
+	"github.com/roh4nyh/iit_bombay/middleware"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+func MemberRoutes(incomingRoutes *gin.Engine, store repository.Store) {
+	memberRoutes := incomingRoutes.Group("/member")
+	memberRoutes.Use(middleware.Authenticate(store))
+
+	can := middleware.RequirePermission
+
+	// user crud
+	memberRoutes.GET("/books", can(models.PERM_BOOKS_READ), controller.GetBooks(store))
+	memberRoutes.GET("/books/:isbn", can(models.PERM_BOOKS_READ), controller.GetBook(store))
+
+	// member crud
+	memberRoutes.POST("/books/borrow/:isbn", can(models.PERM_LOANS_BORROW), controller.BorrowBook(store))
+	memberRoutes.PUT("/books/return/:isbn", can(models.PERM_LOANS_BORROW), controller.ReturnBook(store))
+	memberRoutes.PUT("/books/renew/:isbn", can(models.PERM_LOANS_BORROW), controller.RenewBook(store))
+	memberRoutes.POST("/copies/borrow/:barcode", can(models.PERM_LOANS_BORROW), controller.BorrowCopy(store))
+	memberRoutes.PUT("/copies/return/:barcode", can(models.PERM_LOANS_BORROW), controller.ReturnCopy(store))
+	memberRoutes.GET("/books/borrowed", can(models.PERM_LOANS_BORROW), controller.BorrowedBooks(store))
+	memberRoutes.GET("/books/overdue", can(models.PERM_LOANS_BORROW), controller.MemberOverdueLoans(store))
+	memberRoutes.GET("/fines", can(models.PERM_LOANS_BORROW), controller.MemberFines(store))
+
+	// holds on out of stock books
+	memberRoutes.POST("/holds/:isbn", can(models.PERM_HOLDS_PLACE), controller.PlaceHold(store))
+	memberRoutes.GET("/holds", can(models.PERM_HOLDS_PLACE), controller.MemberHolds(store))
+	memberRoutes.DELETE("/holds/:hold_id", can(models.PERM_HOLDS_PLACE), controller.CancelMemberHold(store))
+	memberRoutes.DELETE("/account", can(models.PERM_ACCOUNT_DELETE), controller.DeActivateMember(store))
+}
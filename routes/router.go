@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	controller "github.com/roh4nyh/iit_bombay/controllers"
+	"github.com/roh4nyh/iit_bombay/middleware"
+	"github.com/roh4nyh/iit_bombay/repository"
+)
+
+// NewRouter builds the complete HTTP API on top of store, so it can be served
+// by main or driven directly with httptest.
+func NewRouter(store repository.Store) *gin.Engine {
+	app := gin.New()
+	app.Use(gin.Logger())
+	app.Use(middleware.RequestID())
+
+	config := cors.DefaultConfig()
+	config.AllowOrigins = []string{"*"}
+	config.AllowCredentials = true
+	config.AllowHeaders = []string{"Authorization", "Content-Type", middleware.REQUEST_ID_HEADER}
+	config.ExposeHeaders = []string{middleware.REQUEST_ID_HEADER, controller.NEXT_CURSOR_HEADER, controller.TOTAL_COUNT_HEADER}
+	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	app.Use(cors.New(config))
+
+	// every change made through the API ends up in the audit log
+	app.Use(middleware.Audit(store))
+
+	app.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": "iit bombay server is up and running..."})
+	})
+
+	AuthRoutes(app, store)
+
+	LibrarianRoutes(app, store)
+
+	MemberRoutes(app, store)
+
+	AdminRoutes(app, store)
+
+	app.GET("/api/v1/whoami", middleware.Authenticate(store), func(c *gin.Context) {
+		username := c.GetString("username")
+		role := c.GetString("role")
+		c.JSON(http.StatusOK, gin.H{"username": username, "role": role})
+	})
+
+	return app
+}
@@ -0,0 +1,325 @@
+package routes_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roh4nyh/iit_bombay/controllers"
+	"github.com/roh4nyh/iit_bombay/models"
+	"github.com/roh4nyh/iit_bombay/repository"
+	"github.com/roh4nyh/iit_bombay/routes"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse battery staple"
+
+// newTestAPI builds the API on an empty memory store, set up like main does.
+func newTestAPI(t *testing.T) (repository.Store, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := repository.NewMemoryStore()
+	controllers.EnsureRoles(store)
+	if err := controllers.RotateSigningKeys(store); err != nil {
+		t.Fatalf("RotateSigningKeys: %v", err)
+	}
+
+	return store, routes.NewRouter(store)
+}
+
+// addUser adds an approved user with role and testPassword, hashed cheaply
+// to keep the tests fast.
+func addUser(t *testing.T, store repository.Store, username, role string) {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password, active := string(hash), false
+	user := models.User{Username: &username, Password: &password, Role: &role, IsActive: &active, AccountStatus: models.STATUS_APPROVED}
+	if err := store.Users().Insert(context.Background(), &user); err != nil {
+		t.Fatalf("insert %s: %v", username, err)
+	}
+}
+
+func request(app *gin.Engine, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func login(t *testing.T, app *gin.Engine, username, password string) *httptest.ResponseRecorder {
+	t.Helper()
+	return request(app, http.MethodPost, "/users/login", "", `{"username":"`+username+`","password":"`+password+`"}`)
+}
+
+// loginToken logs in with testPassword and returns the access token.
+func loginToken(t *testing.T, app *gin.Engine, username string) string {
+	t.Helper()
+
+	w := login(t, app, username, testPassword)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login %s: %d %s", username, w.Code, w.Body)
+	}
+
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Token == "" {
+		t.Fatalf("login %s: no token in %s", username, w.Body)
+	}
+
+	return response.Token
+}
+
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int, what string) {
+	t.Helper()
+	if w.Code != status {
+		t.Errorf("%s: got %d %s, want %d", what, w.Code, w.Body, status)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	store, app := newTestAPI(t)
+	addUser(t, store, "member@example.com", models.ROLE_MEMBER)
+
+	token := loginToken(t, app, "member@example.com")
+
+	expect(t, request(app, http.MethodGet, "/member/books", token, ""), http.StatusOK, "search with a token")
+	expect(t, request(app, http.MethodGet, "/member/books", "", ""), http.StatusUnauthorized, "search without a token")
+	expect(t, request(app, http.MethodGet, "/member/books", "not-a-token", ""), http.StatusUnauthorized, "search with a bad token")
+
+	// failed logins slow down the next ones, so they come last
+	if w := login(t, app, "member@example.com", "wrong password"); w.Code == http.StatusOK {
+		t.Errorf("login with a wrong password succeeded")
+	}
+	if w := login(t, app, "nobody@example.com", testPassword); w.Code == http.StatusOK {
+		t.Errorf("login of an unknown user succeeded")
+	}
+}
+
+func TestBorrowAndReturn(t *testing.T) {
+	store, app := newTestAPI(t)
+	addUser(t, store, "librarian@example.com", models.ROLE_LIBRARIAN)
+	addUser(t, store, "first@example.com", models.ROLE_MEMBER)
+	addUser(t, store, "second@example.com", models.ROLE_MEMBER)
+
+	librarian := loginToken(t, app, "librarian@example.com")
+	first := loginToken(t, app, "first@example.com")
+	second := loginToken(t, app, "second@example.com")
+
+	book := `{"isbn":"978-0-13-110362-7","title":"The C Programming Language","author":"Brian W. Kernighan","qty":1}`
+	expect(t, request(app, http.MethodPost, "/librarian/books", librarian, book), http.StatusOK, "add book")
+
+	expect(t, request(app, http.MethodPost, "/member/books/borrow/9780131103627", first, ""), http.StatusOK, "borrow")
+	expect(t, request(app, http.MethodPost, "/member/books/borrow/9780131103627", second, ""), http.StatusConflict, "borrow when out of stock")
+	expect(t, request(app, http.MethodPut, "/member/books/return/9780131103627", second, ""), http.StatusNotFound, "return a book not borrowed")
+
+	w := request(app, http.MethodGet, "/member/books/borrowed", first, "")
+	expect(t, w, http.StatusOK, "borrowed books")
+	if !strings.Contains(w.Body.String(), "9780131103627") {
+		t.Errorf("borrowed books do not list the loan: %s", w.Body)
+	}
+
+	expect(t, request(app, http.MethodPut, "/member/books/return/9780131103627", first, ""), http.StatusOK, "return")
+	expect(t, request(app, http.MethodPost, "/member/books/borrow/9780131103627", second, ""), http.StatusOK, "borrow after the return")
+}
+
+func TestPermissionDenied(t *testing.T) {
+	store, app := newTestAPI(t)
+	addUser(t, store, "member@example.com", models.ROLE_MEMBER)
+	addUser(t, store, "librarian@example.com", models.ROLE_LIBRARIAN)
+
+	member := loginToken(t, app, "member@example.com")
+	librarian := loginToken(t, app, "librarian@example.com")
+
+	book := `{"isbn":"978-0-13-110362-7","title":"The C Programming Language","author":"Brian W. Kernighan","qty":1}`
+	expect(t, request(app, http.MethodPost, "/librarian/books", member, book), http.StatusForbidden, "member adding a book")
+	expect(t, request(app, http.MethodGet, "/librarian/users", member, ""), http.StatusForbidden, "member listing users")
+	expect(t, request(app, http.MethodGet, "/admin/roles", member, ""), http.StatusForbidden, "member listing roles")
+	expect(t, request(app, http.MethodGet, "/admin/roles", librarian, ""), http.StatusForbidden, "librarian listing roles")
+	expect(t, request(app, http.MethodPost, "/librarian/books", "", book), http.StatusUnauthorized, "adding a book without a token")
+}
+
+func TestOverdueLoansArePaged(t *testing.T) {
+	store, app := newTestAPI(t)
+	addUser(t, store, "librarian@example.com", models.ROLE_LIBRARIAN)
+
+	librarian := loginToken(t, app, "librarian@example.com")
+
+	w := request(app, http.MethodGet, "/librarian/loans/overdue?limit=10", librarian, "")
+	expect(t, w, http.StatusOK, "overdue loans")
+	if !strings.Contains(w.Body.String(), `"next_cursor"`) {
+		t.Errorf("overdue loans are not paged: %s", w.Body)
+	}
+
+	expect(t, request(app, http.MethodGet, "/librarian/loans/overdue?limit=0", librarian, ""), http.StatusBadRequest, "overdue loans with limit 0")
+
+	addUser(t, store, "member@example.com", models.ROLE_MEMBER)
+	member := loginToken(t, app, "member@example.com")
+
+	w = request(app, http.MethodGet, "/member/books/overdue?limit=10", member, "")
+	expect(t, w, http.StatusOK, "a member's overdue loans")
+	if !strings.Contains(w.Body.String(), `"next_cursor"`) {
+		t.Errorf("a member's overdue loans are not paged: %s", w.Body)
+	}
+
+	expect(t, request(app, http.MethodGet, "/member/books/overdue?limit=0", member, ""), http.StatusBadRequest, "a member's overdue loans with limit 0")
+}
+
+func TestLibrarianCannotManageAdmins(t *testing.T) {
+	store, app := newTestAPI(t)
+	addUser(t, store, "librarian@example.com", models.ROLE_LIBRARIAN)
+	addUser(t, store, "admin@example.com", models.ROLE_ADMIN)
+	addUser(t, store, "member@example.com", models.ROLE_MEMBER)
+
+	librarian := loginToken(t, app, "librarian@example.com")
+
+	admin, err := store.Users().FindByUsername(context.Background(), "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	member, err := store.Users().FindByUsername(context.Background(), "member@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminPath := "/librarian/users/" + admin.ID.Hex()
+	expect(t, request(app, http.MethodPut, adminPath, librarian, `{"role":"MEMBER"}`), http.StatusForbidden, "librarian demoting an admin")
+	expect(t, request(app, http.MethodPut, adminPath, librarian, `{"password":"taken over"}`), http.StatusForbidden, "librarian resetting an admin's password")
+	expect(t, request(app, http.MethodDelete, adminPath+"/sessions", librarian, ""), http.StatusForbidden, "librarian logging an admin out")
+
+	if admin, err = store.Users().FindByUsername(context.Background(), "admin@example.com"); err != nil || *admin.Role != models.ROLE_ADMIN {
+		t.Errorf("admin after the attempts: %v, %v", admin, err)
+	}
+
+	expect(t, request(app, http.MethodPut, "/librarian/users/"+member.ID.Hex(), librarian, `{"is_active":true}`), http.StatusOK, "librarian updating a member")
+}
+
+func TestRoleManagersCannotExceedTheirPermissions(t *testing.T) {
+	store, app := newTestAPI(t)
+
+	name := "ROLE_MANAGER"
+	role := models.Role{Name: &name, Permissions: []string{models.PERM_ROLES_MANAGE, models.PERM_BOOKS_READ}}
+	if err := store.Roles().Insert(context.Background(), &role); err != nil {
+		t.Fatal(err)
+	}
+	addUser(t, store, "manager@example.com", name)
+
+	manager := loginToken(t, app, "manager@example.com")
+
+	expect(t, request(app, http.MethodPost, "/admin/roles", manager, `{"name":"READER","permissions":["books:read"]}`), http.StatusCreated, "adding a role within one's permissions")
+	expect(t, request(app, http.MethodPost, "/admin/roles", manager, `{"name":"WRITER","permissions":["books:write"]}`), http.StatusForbidden, "adding a role beyond one's permissions")
+	expect(t, request(app, http.MethodPut, "/admin/roles/READER", manager, `{"permissions":["books:read","users:delete"]}`), http.StatusForbidden, "widening a role beyond one's permissions")
+	expect(t, request(app, http.MethodPut, "/admin/roles/LIBRARIAN", manager, `{"permissions":["books:read"]}`), http.StatusForbidden, "changing a role one could not grant")
+}
+
+func TestRoleManagersCannotDeleteRolesBeyondTheirPermissions(t *testing.T) {
+	store, app := newTestAPI(t)
+
+	for name, permissions := range map[string][]string{
+		"ROLE_MANAGER": {models.PERM_ROLES_MANAGE, models.PERM_BOOKS_READ},
+		"READER":       {models.PERM_BOOKS_READ},
+		"WRITER":       {models.PERM_BOOKS_WRITE},
+	} {
+		role := models.Role{Name: &name, Permissions: permissions}
+		if err := store.Roles().Insert(context.Background(), &role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addUser(t, store, "manager@example.com", "ROLE_MANAGER")
+
+	manager := loginToken(t, app, "manager@example.com")
+
+	expect(t, request(app, http.MethodDelete, "/admin/roles/WRITER", manager, ""), http.StatusForbidden, "deleting a role beyond one's permissions")
+	expect(t, request(app, http.MethodDelete, "/admin/roles/READER", manager, ""), http.StatusOK, "deleting a role within one's permissions")
+}
+
+func TestParallelGuessesAreSlowedDown(t *testing.T) {
+	const guesses = 10
+
+	store, app := newTestAPI(t)
+	addUser(t, store, "member@example.com", models.ROLE_MEMBER)
+
+	codes := make([]int, guesses)
+
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = login(t, app, "member@example.com", "wrong password").Code
+		}()
+	}
+	wg.Wait()
+
+	checked := 0
+	for _, code := range codes {
+		if code != http.StatusTooManyRequests {
+			checked++
+		}
+	}
+	if checked != 1 {
+		t.Errorf("%d of %d parallel guesses were checked, want 1: %v", checked, guesses, codes)
+	}
+}
+
+// addFailures counts failed logins under key as made at, the way a
+// login does.
+func addFailures(t *testing.T, store repository.Store, key string, failures int, at time.Time) {
+	t.Helper()
+
+	for range failures {
+		if _, _, err := store.LoginAttempts().RecordFailure(context.Background(), key, at, at.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBlockedRetriesDoNotExtendTheBlock(t *testing.T) {
+	store, app := newTestAPI(t)
+	addUser(t, store, "member@example.com", models.ROLE_MEMBER)
+
+	// 5 failures wait 16 seconds, 10 of them have passed
+	addFailures(t, store, "user:member@example.com", 5, time.Now().Add(-10*time.Second))
+
+	first := login(t, app, "member@example.com", testPassword)
+	expect(t, first, http.StatusTooManyRequests, "login while blocked")
+
+	for range 3 {
+		w := login(t, app, "member@example.com", testPassword)
+		expect(t, w, http.StatusTooManyRequests, "retry while blocked")
+
+		wait, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+		if limit, _ := strconv.Atoi(first.Header().Get("Retry-After")); wait > limit {
+			t.Fatalf("retry has to wait %d seconds, the first attempt %d", wait, limit)
+		}
+	}
+}
+
+func TestLoginLeavesTheAddressFree(t *testing.T) {
+	store, app := newTestAPI(t)
+	addUser(t, store, "member@example.com", models.ROLE_MEMBER)
+
+	// earlier failures from the same address, whose delay has passed
+	addFailures(t, store, "ip:192.0.2.1", 3, time.Now().Add(-time.Minute))
+
+	loginToken(t, app, "member@example.com")
+	loginToken(t, app, "member@example.com")
+}